@@ -0,0 +1,213 @@
+package memstore
+
+import (
+	"math"
+)
+
+/*
+	Aggregate over items of an index
+
+	Defined as a monoid over values extracted from items:
+	Combine must be associative, and Identity neutral for it
+	Result turns the combined value into the answer returned (optional)
+	Name identifies an aggregate maintained on an index, aggregates defined otherwise need other names
+*/
+type Aggregate struct {
+	Name     string
+	Extract  func(Item) interface{}
+	Combine  func(a, b interface{}) interface{}
+	Identity interface{}
+	Result   func(interface{}) interface{}
+}
+
+// Final answer from a combined value
+func (agg Aggregate) result(value interface{}) interface{} {
+	if agg.Result == nil {
+		return value
+	}
+	return agg.Result(value)
+}
+
+// Number of items (as int)
+func Count() Aggregate {
+	return Aggregate{
+		Name: "count",
+		Extract: func(Item) interface{} {
+			return 1
+		},
+		Combine: func(a, b interface{}) interface{} {
+			return a.(int) + b.(int)
+		},
+		Identity: 0,
+	}
+}
+
+// Sum of values of items (as float64)
+func Sum(name string, value func(Item) float64) Aggregate {
+	return Aggregate{
+		Name: name,
+		Extract: func(x Item) interface{} {
+			return value(x)
+		},
+		Combine: func(a, b interface{}) interface{} {
+			return a.(float64) + b.(float64)
+		},
+		Identity: 0.0,
+	}
+}
+
+// Smallest value of items (as float64, nil if there are none)
+func Minimum(name string, value func(Item) float64) Aggregate {
+	return Aggregate{
+		Name: name,
+		Extract: func(x Item) interface{} {
+			return value(x)
+		},
+		Combine: func(a, b interface{}) interface{} {
+			if a == nil {
+				return b
+			}
+			if b == nil {
+				return a
+			}
+			return math.Min(a.(float64), b.(float64))
+		},
+	}
+}
+
+// Largest value of items (as float64, nil if there are none)
+func Maximum(name string, value func(Item) float64) Aggregate {
+	return Aggregate{
+		Name: name,
+		Extract: func(x Item) interface{} {
+			return value(x)
+		},
+		Combine: func(a, b interface{}) interface{} {
+			if a == nil {
+				return b
+			}
+			if b == nil {
+				return a
+			}
+			return math.Max(a.(float64), b.(float64))
+		},
+	}
+}
+
+// Running sum and count used to average
+type averageState struct {
+	sum   float64
+	count int
+}
+
+// Average value of items (as float64, nil if there are none)
+func Average(name string, value func(Item) float64) Aggregate {
+	return Aggregate{
+		Name: name,
+		Extract: func(x Item) interface{} {
+			return averageState{sum: value(x), count: 1}
+		},
+		Combine: func(a, b interface{}) interface{} {
+			as, bs := a.(averageState), b.(averageState)
+			return averageState{sum: as.sum + bs.sum, count: as.count + bs.count}
+		},
+		Identity: averageState{},
+		Result: func(v interface{}) interface{} {
+			state := v.(averageState)
+			if state.count == 0 {
+				return nil
+			}
+			return state.sum / float64(state.count)
+		},
+	}
+}
+
+/*
+	Maintain an aggregate on an index so that range aggregates run in O(log n)
+
+	Every aggregate maintained keeps its own treap mirroring the tree of the index, updated by every write:
+	maintaining one roughly doubles the cost of writes, and each other one adds as much.
+	Fails if index is unknown or an aggregate with the same name is already maintained on it
+*/
+func (ms *Memstore) AddAggregate(index string, agg Aggregate) bool {
+	op := ms.begin(opAddAggregate, index)
+	defer ms.end(op)
+
+	// Get corresponding tree
+	tree := ms.lookupTree(index)
+	if tree == nil {
+		return false
+	}
+
+	ms.lock(op)
+	defer ms.unlock(op)
+
+	if ms.findAugmented(index, agg.Name) != nil {
+		return false
+	}
+
+	// Build augmented tree from current items
+	aug := newAugmentedTree(index, agg)
+	ascendRange(tree, index, All(), func(ii *internalItem) bool {
+		aug.insert(ii)
+		return true
+	})
+
+	if ms.augmented == nil {
+		ms.augmented = map[string][]*augmentedTree{}
+	}
+	ms.augmented[index] = append(ms.augmented[index], aug)
+
+	return true
+}
+
+// Get augmented tree maintaining an aggregate (expects at least a read lock)
+func (ms *Memstore) findAugmented(index string, name string) *augmentedTree {
+	for _, aug := range ms.augmented[index] {
+		if aug.agg.Name == name {
+			return aug
+		}
+	}
+	return nil
+}
+
+/*
+	Aggregate of items of an index between two bounds
+
+	Runs in O(log n) if an aggregate with the same name is maintained on the index (see AddAggregate),
+	which then answers whatever the functions of agg, O(k + log n) otherwise.
+	Returns nil if index is unknown or key bounds don't compare with items.
+*/
+func (ms *Memstore) Aggregate(index string, from, to Bound, agg Aggregate) interface{} {
+	op := ms.begin(opAggregate, index)
+	defer ms.end(op)
+
+	// Get corresponding tree
+	tree := ms.lookupTree(index)
+	if tree == nil {
+		return nil
+	}
+
+	r := NewRange(from, to)
+
+	ms.rlock(op)
+	defer ms.runlock(op)
+
+	if r.check(tree, index) != nil {
+		return nil
+	}
+
+	// Use maintained aggregate if there is one
+	if aug := ms.findAugmented(index, agg.Name); aug != nil {
+		return aug.agg.result(aug.query(r))
+	}
+
+	// Otherwise, combine every item in range
+	value := agg.Identity
+	ascendRange(tree, index, r, func(ii *internalItem) bool {
+		value = agg.Combine(value, agg.Extract(ii.value))
+		return true
+	})
+
+	return agg.result(value)
+}
@@ -0,0 +1,232 @@
+package memstore
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func importanceValue(x Item) float64 {
+	return float64(x.(TestStruct).importance)
+}
+
+func approximately(value interface{}, expected float64) bool {
+	v, ok := value.(float64)
+	return ok && math.Abs(v-expected) < 1e-6
+}
+
+func aggregateTestStore(maintained bool) *Memstore {
+	ms := New([]string{"id", "importance"})
+	if maintained {
+		ms.AddAggregate("id", Count())
+		ms.AddAggregate("id", Sum("sum", importanceValue))
+		ms.AddAggregate("id", Minimum("min", importanceValue))
+		ms.AddAggregate("id", Maximum("max", importanceValue))
+		ms.AddAggregate("id", Average("avg", importanceValue))
+	}
+	for _, v := range shuffeledTestData() {
+		var vItem Item = v
+		ms.Add(vItem)
+	}
+	return ms
+}
+
+func TestAddAggregateInvalid(t *testing.T) {
+	ms := aggregateTestStore(true)
+
+	if ms.AddAggregate("notID", Count()) {
+		t.Error("Maintaining aggregate on unspecified index didn't fail")
+	}
+
+	if ms.AddAggregate("id", Count()) {
+		t.Error("Maintaining aggregate twice didn't fail")
+	}
+
+	if ms.Aggregate("notID", Unbounded(), Unbounded(), Count()) != nil {
+		t.Error("Aggregating on unspecified index didn't fail")
+	}
+}
+
+func TestAggregateSameName(t *testing.T) {
+	ms := aggregateTestStore(true)
+	all := Unbounded()
+
+	// Maintained aggregates answer for aggregates with their name, whatever their functions
+	idValue := func(x Item) float64 {
+		return float64(x.(TestStruct).id)
+	}
+	var importances, ids float64
+	for _, x := range testData() {
+		importances += importanceValue(x)
+		ids += idValue(x)
+	}
+	if sum := ms.Aggregate("id", all, all, Sum("sum", idValue)); !approximately(sum, importances) {
+		t.Errorf("Sum with the name of a maintained one should be the maintained one, sum=%v", sum)
+	}
+
+	// Others are computed from items
+	if sum := ms.Aggregate("id", all, all, Sum("idSum", idValue)); !approximately(sum, ids) {
+		t.Errorf("Sum of other values shouldn't be the maintained one, sum=%v", sum)
+	}
+	double := Count()
+	double.Name = "double"
+	double.Extract = func(Item) interface{} {
+		return 2
+	}
+	if count := ms.Aggregate("id", all, all, double); count != 2*len(testData()) {
+		t.Errorf("Count with another extractor shouldn't be the maintained one, count=%v", count)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	for _, maintained := range []bool{false, true} {
+		ms := aggregateTestStore(maintained)
+
+		from, to := Inclusive(TestStruct{id: 2}), Exclusive(TestStruct{id: 9})
+
+		if count := ms.Aggregate("id", from, to, Count()); count != 4 {
+			t.Errorf("Count failed (maintained=%v), result=%v", maintained, count)
+		}
+		if sum := ms.Aggregate("id", from, to, Sum("sum", importanceValue)); !approximately(sum, 2+5+0+3.2) {
+			t.Errorf("Sum failed (maintained=%v), result=%v", maintained, sum)
+		}
+		if min := ms.Aggregate("id", from, to, Minimum("min", importanceValue)); min != 0.0 {
+			t.Errorf("Minimum failed (maintained=%v), result=%v", maintained, min)
+		}
+		if max := ms.Aggregate("id", from, to, Maximum("max", importanceValue)); max != 5.0 {
+			t.Errorf("Maximum failed (maintained=%v), result=%v", maintained, max)
+		}
+		if avg := ms.Aggregate("id", from, to, Average("avg", importanceValue)); !approximately(avg, (2+5+0+3.2)/4) {
+			t.Errorf("Average failed (maintained=%v), result=%v", maintained, avg)
+		}
+
+		empty := Exclusive(TestStruct{id: 5})
+		if min := ms.Aggregate("id", empty, empty, Minimum("min", importanceValue)); min != nil {
+			t.Errorf("Minimum of empty range should be nil (maintained=%v), result=%v", maintained, min)
+		}
+		if avg := ms.Aggregate("id", empty, empty, Average("avg", importanceValue)); avg != nil {
+			t.Errorf("Average of empty range should be nil (maintained=%v), result=%v", maintained, avg)
+		}
+	}
+}
+
+func TestAggregateFollowsUpdates(t *testing.T) {
+	// Importance isn't indexed so that it can be updated in place
+	ms := New([]string{"id"})
+	ms.AddAggregate("id", Count())
+	ms.AddAggregate("id", Sum("sum", importanceValue))
+	ms.AddAggregate("id", Maximum("max", importanceValue))
+	for _, v := range shuffeledTestData() {
+		var vItem Item = v
+		ms.Add(vItem)
+	}
+
+	ms.Delete(TestStruct{id: 3}, "id")
+	ms.UpdateData(TestStruct{id: 1}, "id", func(x Item) (Item, bool) {
+		updated := x.(TestStruct)
+		updated.importance = 10
+		return updated, true
+	})
+	ms.UpdateWithIndexes(TestStruct{id: 9}, "id", dataModifierFuncWithIndex)
+	ms.Add(TestStruct{id: 4, importance: 1, name: "t"})
+
+	// Items are now {-1, 3.1} {1, 10} {2, 2} {4, 1} {8, 3.2}
+	if count := ms.Aggregate("id", Unbounded(), Unbounded(), Count()); count != 5 {
+		t.Errorf("Count after updates failed, result=%v", count)
+	}
+	if sum := ms.Aggregate("id", Unbounded(), Unbounded(), Sum("sum", importanceValue)); !approximately(sum, 3.1+10+2+1+3.2) {
+		t.Errorf("Sum after updates failed, result=%v", sum)
+	}
+	if max := ms.Aggregate("id", Unbounded(), Exclusive(TestStruct{id: 2}), Maximum("max", importanceValue)); max != 10.0 {
+		t.Errorf("Maximum after updates failed, result=%v", max)
+	}
+}
+
+func TestAggregateRandomRanges(t *testing.T) {
+	maintained := New([]string{"id"})
+	maintained.AddAggregate("id", Sum("sum", importanceValue))
+	scanned := New([]string{"id"})
+
+	for n := 0; n < 500; n++ {
+		var x Item = TestStruct{id: rand.Intn(1000), importance: float32(rand.Intn(100))}
+		maintained.Add(x)
+		scanned.Add(x)
+		if n%3 == 0 {
+			var deleted Item = TestStruct{id: rand.Intn(1000)}
+			maintained.Delete(deleted, "id")
+			scanned.Delete(deleted, "id")
+		}
+	}
+
+	for n := 0; n < 100; n++ {
+		from := Inclusive(TestStruct{id: rand.Intn(1000)})
+		to := Exclusive(TestStruct{id: rand.Intn(1000)})
+		fast := maintained.Aggregate("id", from, to, Sum("sum", importanceValue))
+		slow := scanned.Aggregate("id", from, to, Sum("sum", importanceValue))
+		if fast != slow {
+			t.Fatalf("Maintained aggregate differs from scan, maintained=%v scanned=%v", fast, slow)
+		}
+	}
+}
+
+/*
+	Group by
+*/
+
+// Group items by integer part of their importance
+func importanceGroup(x Item) interface{} {
+	return int(x.(TestStruct).importance)
+}
+
+func TestGroupByInvalidIndex(t *testing.T) {
+	ms := aggregateTestStore(false)
+
+	if res := ms.GroupBy("notID", importanceGroup, Count(), 0); res != nil {
+		t.Error("Grouping by unspecified index didn't fail")
+	}
+}
+
+func TestGroupBy(t *testing.T) {
+	ms := aggregateTestStore(false)
+
+	res := ms.GroupBy("importance", importanceGroup, Count(), 0)
+
+	expected := []Group{{0, 1}, {2, 1}, {3, 3}, {5, 1}}
+	if !reflect.DeepEqual(res, expected) {
+		t.Errorf("Grouping failed, result=%v expected=%v", res, expected)
+	}
+}
+
+func TestGroupByLimit(t *testing.T) {
+	ms := aggregateTestStore(false)
+
+	res := ms.GroupBy("importance", importanceGroup, Sum("sum", importanceValue), 3)
+
+	if len(res) != 3 || res[2].Key != 3 || !approximately(res[2].Value, 3+3.1+3.2) {
+		t.Errorf("Grouping with limit failed, result=%v", res)
+	}
+}
+
+func TestGroupByUserAggregate(t *testing.T) {
+	ms := aggregateTestStore(false)
+
+	// Concatenate names in index order
+	names := Aggregate{
+		Name: "names",
+		Extract: func(x Item) interface{} {
+			return x.(TestStruct).name
+		},
+		Combine: func(a, b interface{}) interface{} {
+			return a.(string) + b.(string)
+		},
+		Identity: "",
+	}
+
+	res := ms.GroupBy("importance", importanceGroup, names, 0)
+
+	expected := []Group{{0, "t"}, {2, "y"}, {3, "xvu"}, {5, "z"}}
+	if !reflect.DeepEqual(res, expected) {
+		t.Errorf("Grouping with user aggregate failed, result=%v expected=%v", res, expected)
+	}
+}
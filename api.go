@@ -6,52 +6,137 @@ package memstore
 
 import (
 	"github.com/mngharbi/GoLLRB/llrb"
+	"reflect"
+	"sync/atomic"
 )
 
-func New(indexes []string) *Memstore {
+func New(indexes []string, options ...Option) *Memstore {
 	ms := &Memstore{}
 
-	ms.indexTree = map[string]*llrb.LLRB{}
+	ms.indexes = indexes
+	ms.indexTree = map[string]OrderedIndex{}
+	ms.lineage = newLineage()
+
+	// Options first, as they pick backends
+	for _, option := range options {
+		option(ms)
+	}
 
 	// Create trees and reverse dictionary
-	ms.trees = make([]*llrb.LLRB, len(indexes))
+	ms.trees = make([]OrderedIndex, len(indexes))
 	for i, index := range indexes {
-		ms.trees[i] = llrb.New(index)
+		ms.trees[i] = newOrderedIndex(ms.backendOf(index), index)
 		ms.indexTree[index] = ms.trees[i]
 	}
+	if ms.merge != nil {
+		ms.merge.init(indexes[0])
+	}
 
 	return ms
 }
 
+/*
+	Add item, replacing the one with the same primary key
+
+	Items taking the key of another one in a unique index aren't added, like writes to followers (see Put).
+*/
 func (ms *Memstore) Add(x Item) {
+	if ms.rejectsWrites() {
+		return
+	}
+
+	op := ms.begin(opAdd, "")
+	defer ms.end(op)
+
 	// Make internal node to use in llrb
 	ix := makeInternalItem(x)
 
-	ms.m.Lock()
+	ms.lock(op)
+
+	// Item replaced may hold the same keys in unique indexes
+	if ms.unique != nil && ms.duplicate(x, getFromTree(ix, ms.trees[0])) {
+		ms.unlock(op)
+		atomic.AddUint64(&ms.rejectedWrites, 1)
+		return
+	}
 
 	// Add to every internal tree
-	for _, tree := range ms.indexTree {
-		tree.ReplaceOrInsert(ix)
+	ms.identify(ix.(*internalItem))
+	ms.insert(ix.(*internalItem))
+	op.addItems(1)
+
+	ms.unlock(op)
+
+	ms.debugCheck(op, x)
+}
+
+/*
+	Add item, or replace the one with the same primary key keeping its identity and moving it within trees
+
+	Fails with ErrDuplicate if another item has the same key in a unique index (see WithUnique),
+	or ErrReadOnly on followers.
+*/
+func (ms *Memstore) Put(x Item) (err error) {
+	if ms.rejectsWrites() {
+		return ErrReadOnly
 	}
 
-	ms.m.Unlock()
+	op := ms.begin(opPut, "")
+	defer ms.end(op)
+
+	// Make probe to search llrb with
+	ix := makeProbe(x)
+	defer releaseProbe(ix)
+
+	ms.lock(op)
+
+	existing := getFromTree(ix, ms.trees[0])
+	switch {
+	case ms.duplicate(x, existing):
+		err = ErrDuplicate
+	case existing == nil:
+		ii := makeInternalItem(x).(*internalItem)
+		ms.identify(ii)
+		ms.insert(ii)
+	case ms.indexesChanged(existing.value, x):
+		ms.reposition(existing, x)
+	default:
+		existing.value = x
+		ms.refresh(existing)
+	}
+
+	ms.unlock(op)
+
+	if err != nil {
+		return err
+	}
+	op.addItems(1)
+	ms.debugCheck(op, x)
+	return nil
 }
 
-func getFromTree(ix llrb.Item, tree *llrb.LLRB) *Item {
+func getFromTree(ix llrb.Item, tree OrderedIndex) *internalItem {
 	ifound := tree.Get(ix)
 	if ifound == nil {
 		return nil
 	}
-	return ifound.(*internalItem).item
+	return ifound.(*internalItem)
 }
 
 func (ms *Memstore) AddOrGet(x Item) Item {
+	if ms.rejectsWrites() {
+		return nil
+	}
+
+	op := ms.begin(opAddOrGet, "")
+	defer ms.end(op)
+
 	// Make internal node to use in llrb
 	ix := makeInternalItem(x)
 
-	var res *Item
+	var res *internalItem
 
-	ms.m.Lock()
+	ms.lock(op)
 
 	// Search for item in all trees
 	for _, tree := range ms.indexTree {
@@ -63,210 +148,276 @@ func (ms *Memstore) AddOrGet(x Item) Item {
 
 	// Add to internal trees only if not found
 	if res == nil {
-		res = ix.(*internalItem).item
-		for _, tree := range ms.indexTree {
-			tree.ReplaceOrInsert(ix)
-		}
+		res = ix.(*internalItem)
+		ms.identify(ix.(*internalItem))
+		ms.insert(ix.(*internalItem))
 	}
+	op.addItems(1)
 
-	ms.m.Unlock()
+	ms.unlock(op)
+
+	ms.debugCheck(op, x)
 
 	if res == nil {
 		return nil
 	} else {
-		return *res
+		return res.value
 	}
 }
 
 func (ms *Memstore) Delete(x Item, index string) Item {
-	// Make internal node to use in llrb
-	ix := makeInternalItem(x)
-
-	// Get corresponding tree
-	initialTree := ms.indexTree[index]
-	if initialTree == nil {
+	if ms.rejectsWrites() {
 		return nil
 	}
 
-	ms.m.Lock()
+	op := ms.begin(opDelete, index)
+	defer ms.end(op)
 
-	var res *Item = nil
+	// Make probe to search llrb with
+	ix := makeProbe(x)
+	defer releaseProbe(ix)
 
-	// Delete from corresponding internal tree
-	initialDeleted := ms.delete(ix.(*internalItem), initialTree)
-	if initialDeleted == nil {
+	// Get corresponding tree
+	initialTree := ms.lookupTree(index)
+	if initialTree == nil {
 		return nil
 	}
-	res = initialDeleted.item
 
-	// Remove from other trees using full object
-	for _, tree := range ms.indexTree {
-		if tree == initialTree {
-			continue
-		}
-		var internalItem llrb.Item = initialDeleted
-		tree.Delete(internalItem)
-	}
+	ms.lock(op)
+
+	// Delete from corresponding internal tree, then from others using full object
+	deleted := ms.deleteEverywhere(ix, index)
 
-	ms.m.Unlock()
+	ms.unlock(op)
 
-	return *res
+	if deleted == nil {
+		return nil
+	}
+	op.addItems(1)
+	return deleted.value
 }
 
 func (ms *Memstore) Get(x Item, index string) (res Item) {
-	// Make internal node to use with llrb
-	ix := makeInternalItem(x)
+	op := ms.begin(opGet, index)
+	defer ms.end(op)
+
+	// Make probe to search llrb with
+	ix := makeProbe(x)
+	defer releaseProbe(ix)
 
 	// Get corresponding tree
-	tree := ms.indexTree[index]
+	tree := ms.lookupTree(index)
 	if tree == nil {
 		return nil
 	}
 
-	ms.m.RLock()
+	ms.rlock(op)
 
 	ifound := tree.Get(ix)
 	if ifound == nil {
 		res = nil
 	} else {
-		res = *(ifound.(*internalItem).item)
+		res = ifound.(*internalItem).value
+		op.addItems(1)
 	}
 
-	ms.m.RUnlock()
+	ms.runlock(op)
 
 	return res
 }
 
 func (ms *Memstore) GetRange(from, to Item, index string, test func(Item) bool) {
-	// Make internal nodes to use with llrb
-	ifrom := makeInternalItem(from)
-	ito := makeInternalItem(to)
+	ms.GetRangeBounds(NewRange(Inclusive(from), Exclusive(to)), index, test)
+}
 
-	// Transform iterator
-	iterator := func(it llrb.Item) bool {
-		extItPtr := it.(*internalItem).item
-		return test(*extItPtr)
-	}
+/*
+	Run test on items of an index within a range in ascending order, until it returns false
+
+	Fails with ErrUnknownIndex, or ErrNotKeyed and ErrKeyType if key bounds don't compare with items.
+*/
+func (ms *Memstore) GetRangeBounds(r Range, index string, test func(Item) bool) error {
+	op := ms.begin(opGetRange, index)
+	defer ms.end(op)
 
 	// Get corresponding tree
-	tree := ms.indexTree[index]
+	tree := ms.lookupTree(index)
 	if tree == nil {
-		return
+		return ErrUnknownIndex
 	}
 
-	ms.m.RLock()
+	ms.rlock(op)
+	defer ms.runlock(op)
 
-	tree.AscendRange(ifrom.(llrb.Item), ito.(llrb.Item), iterator)
+	if err := r.check(tree, index); err != nil {
+		return err
+	}
+	ascendRange(tree, index, r, func(ii *internalItem) bool {
+		op.addItems(1)
+		return test(ii.value)
+	})
 
-	ms.m.RUnlock()
+	return nil
 }
 
 func (ms *Memstore) Len() (res int) {
+	op := ms.begin(opLen, "")
+	defer ms.end(op)
+
 	// Get first tree
 	tree := ms.trees[0]
 
-	ms.m.RLock()
+	ms.rlock(op)
 
 	// Look up size
 	res = tree.Len()
 
-	ms.m.RUnlock()
+	ms.runlock(op)
 
 	return res
 }
 
 func (ms *Memstore) Max(index string) (res Item) {
+	op := ms.begin(opMax, index)
+	defer ms.end(op)
+
 	// Get corresponding tree
-	tree := ms.indexTree[index]
+	tree := ms.lookupTree(index)
 	if tree == nil {
 		return nil
 	}
 
-	ms.m.RLock()
+	ms.rlock(op)
 
 	// Look up max
 	maxResult := tree.Max()
 	if maxResult == nil {
 		res = nil
 	} else {
-		res = *(maxResult.(*internalItem).item)
+		res = maxResult.(*internalItem).value
+		op.addItems(1)
 	}
 
-	ms.m.RUnlock()
+	ms.runlock(op)
 
 	return res
 }
 
 func (ms *Memstore) Min(index string) (res Item) {
+	op := ms.begin(opMin, index)
+	defer ms.end(op)
+
 	// Get corresponding tree
-	tree := ms.indexTree[index]
+	tree := ms.lookupTree(index)
 	if tree == nil {
 		return nil
 	}
 
-	ms.m.RLock()
+	ms.rlock(op)
 
 	// Look up min
 	minResult := tree.Min()
 	if minResult == nil {
 		res = nil
 	} else {
-		res = *(minResult.(*internalItem).item)
+		res = minResult.(*internalItem).value
+		op.addItems(1)
 	}
 
-	ms.m.RUnlock()
+	ms.runlock(op)
 
 	return res
 }
 
 func (ms *Memstore) UpdateData(x Item, index string, modify func(Item) (Item, bool)) (res Item) {
-	// Make internal node to use with llrb
-	ix := makeInternalItem(x)
+	res, _ = ms.Update(x, index, modify)
+	return res
+}
+
+/*
+	Same as UpdateData, reporting why the update didn't happen
+
+	Fails with ErrDuplicate if the item would be moved to the primary key of another one.
+*/
+func (ms *Memstore) Update(x Item, index string, modify func(Item) (Item, bool)) (res Item, err error) {
+	if ms.rejectsWrites() {
+		return nil, ErrReadOnly
+	}
+
+	op := ms.begin(opUpdate, index)
+	defer ms.end(op)
+
+	// Make probe to search llrb with
+	ix := makeProbe(x)
+	defer releaseProbe(ix)
 
 	// Get corresponding tree
-	tree := ms.indexTree[index]
+	tree := ms.lookupTree(index)
 	if tree == nil {
-		return nil
+		return nil, ErrUnknownIndex
 	}
 
-	ms.m.RLock()
+	ms.lock(op)
 
 	internalFoundInterfaced := tree.Get(ix)
 	if internalFoundInterfaced == nil {
-		res = nil
+		err = ErrNotFound
 	} else {
 		// Calculate result with modify
 		var itemFoundCopy Item
 		internalFound := internalFoundInterfaced.(*internalItem)
-		itemFoundCopy = *(internalFound.item)
+		itemFoundCopy = internalFound.value
 		itemResult, modifyResult := modify(itemFoundCopy)
 
-		// If update is successful, update internal item
-		if modifyResult {
-			*(internalFound.item) = itemResult
+		// If update is successful, update internal item in place unless indexed fields changed
+		switch {
+		case !modifyResult:
+			err = ErrNotModified
+		case !ms.indexesChanged(itemFoundCopy, itemResult):
+			internalFound.value = itemResult
+			ms.refresh(internalFound)
 			res = itemResult
-		} else {
-			res = nil
+		case ms.indexChangePolicy == RepositionOnIndexChange && ms.duplicate(itemResult, internalFound):
+			err = ErrDuplicate
+		case ms.indexChangePolicy == RepositionOnIndexChange:
+			ms.reposition(internalFound, itemResult)
+			res = itemResult
+		default:
+			err = ErrIndexChanged
 		}
 	}
 
-	ms.m.RUnlock()
+	ms.unlock(op)
 
-	return res
+	if err == nil {
+		op.addItems(1)
+		ms.debugCheck(op, res)
+	}
+
+	return res, err
 }
 
+/*
+	Run a function on the item found, under the read lock
+
+	The function must not change the item it's given: changes made in place skip versions, indexes
+	and followers. Use Update or CompareAndSwap to change an item.
+*/
 func (ms *Memstore) ApplyData(x Item, index string, run func(Item) bool) (res Item) {
-	// Make internal node to use with llrb
-	ix := makeInternalItem(x)
+	op := ms.begin(opApplyData, index)
+	defer ms.end(op)
+
+	// Make probe to search llrb with
+	ix := makeProbe(x)
+	defer releaseProbe(ix)
 
 	// Get corresponding tree
-	tree := ms.indexTree[index]
+	tree := ms.lookupTree(index)
 	if tree == nil {
 		return nil
 	}
 
-	ms.m.RLock()
-	defer func() { ms.m.RUnlock() }()
+	ms.rlock(op)
+	defer ms.runlock(op)
 
 	internalFoundInterfaced := tree.Get(ix)
 	if internalFoundInterfaced == nil {
@@ -275,12 +426,13 @@ func (ms *Memstore) ApplyData(x Item, index string, run func(Item) bool) (res It
 		// Calculate result with modify
 		var itemFoundCopy Item
 		internalFound := internalFoundInterfaced.(*internalItem)
-		itemFoundCopy = *(internalFound.item)
+		itemFoundCopy = internalFound.value
 		runResult := run(itemFoundCopy)
 
 		// If result is successful, return item
 		if runResult {
 			res = itemFoundCopy
+			op.addItems(1)
 		} else {
 			res = nil
 		}
@@ -289,12 +441,21 @@ func (ms *Memstore) ApplyData(x Item, index string, run func(Item) bool) (res It
 	return res
 }
 
+// Same as UpdateData, moving the item within trees, nil if it would be moved to the primary key of another one
 func (ms *Memstore) UpdateWithIndexes(x Item, index string, modify func(Item) (Item, bool)) (res Item) {
-	// Make internal node to use with llrb
-	ix := makeInternalItem(x)
+	if ms.rejectsWrites() {
+		return nil
+	}
+
+	op := ms.begin(opUpdateWithIndexes, index)
+	defer ms.end(op)
+
+	// Make probe to search llrb with
+	ix := makeProbe(x)
+	defer releaseProbe(ix)
 
 	// Get corresponding tree
-	tree := ms.indexTree[index]
+	tree := ms.lookupTree(index)
 	if tree == nil {
 		return nil
 	}
@@ -302,7 +463,7 @@ func (ms *Memstore) UpdateWithIndexes(x Item, index string, modify func(Item) (I
 	var ok bool
 	var itemCopy, itemResult Item
 
-	ms.m.Lock()
+	ms.lock(op)
 
 	internalFoundInterfaced := tree.Get(ix)
 	if internalFoundInterfaced == nil {
@@ -310,34 +471,32 @@ func (ms *Memstore) UpdateWithIndexes(x Item, index string, modify func(Item) (I
 	} else {
 		// Modify copy using user-provided function
 		var internalFound *internalItem = internalFoundInterfaced.(*internalItem)
-		itemCopy = *(internalFound.item)
+		itemCopy = internalFound.value
 		itemResult, ok = modify(itemCopy)
 
-		// If found and update would be successful, delete using copy item then add modified one to all tables
+		// If found and update would be successful, move item within all tables unless another one has its key
+		ok = ok && !ms.duplicate(itemResult, internalFound)
 		if ok {
-			// Delete from all trees
-			for _, tree := range ms.indexTree {
-				tree.Delete(internalFound)
-			}
-
-			// Add to every internal tree
-			ix := makeInternalItem(itemResult)
-			for _, tree := range ms.indexTree {
-				tree.ReplaceOrInsert(ix)
-			}
+			ms.reposition(internalFound, itemResult)
 		}
 	}
 
-	ms.m.Unlock()
+	ms.unlock(op)
 
 	if ok {
+		op.addItems(1)
+		ms.debugCheck(op, itemResult)
 		return itemResult
 	} else {
 		return nil
 	}
 }
 
+// Same as ApplyData for several items, all under one read lock
 func (ms *Memstore) ApplyDataSubset(items []Item, index string, apply func(Item) bool) (res []Item) {
+	op := ms.begin(opApplyDataSubset, index)
+	defer ms.end(op)
+
 	// Make internal nodes to use with llrb
 	internalItems := []llrb.Item{}
 	for _, it := range items {
@@ -345,12 +504,12 @@ func (ms *Memstore) ApplyDataSubset(items []Item, index string, apply func(Item)
 	}
 
 	// Get corresponding tree
-	tree := ms.indexTree[index]
+	tree := ms.lookupTree(index)
 	if tree == nil {
 		return nil
 	}
 
-	ms.m.RLock()
+	ms.rlock(op)
 
 	for _, iitem := range internalItems {
 		internalFoundInterfaced := tree.Get(iitem)
@@ -360,19 +519,74 @@ func (ms *Memstore) ApplyDataSubset(items []Item, index string, apply func(Item)
 			// Run apply on item
 			var itemFoundCopy Item
 			internalFound := internalFoundInterfaced.(*internalItem)
-			itemFoundCopy = *(internalFound.item)
+			itemFoundCopy = internalFound.value
 			applyResult := apply(itemFoundCopy)
 
-			// If update is successful, update internal item
+			// If apply is successful, return item
 			if applyResult {
 				res = append(res, itemFoundCopy)
+				op.addItems(1)
 			} else {
 				res = append(res, nil)
 			}
 		}
 	}
 
-	ms.m.RUnlock()
+	ms.runlock(op)
 
 	return res
 }
+
+// Replace the stored item with another only if it's deeply equal to old, and the replacement takes no other item's key
+func (ms *Memstore) CompareAndSwap(old, replacement Item, index string) (swapped bool) {
+	if ms.rejectsWrites() {
+		return false
+	}
+
+	op := ms.begin(opCompareAndSwap, index)
+	defer ms.end(op)
+
+	// Make probe to search llrb with
+	ix := makeProbe(old)
+	defer releaseProbe(ix)
+
+	// Get corresponding tree
+	tree := ms.lookupTree(index)
+	if tree == nil {
+		return false
+	}
+
+	// Check replacement once lock is released
+	defer func() {
+		if swapped {
+			ms.debugCheck(op, replacement)
+		}
+	}()
+
+	ms.lock(op)
+	defer ms.unlock(op)
+
+	// Swap only if stored item is exactly the old one
+	internalFoundInterfaced := tree.Get(ix)
+	if internalFoundInterfaced == nil {
+		return false
+	}
+	internalFound := internalFoundInterfaced.(*internalItem)
+	if !reflect.DeepEqual(internalFound.value, old) {
+		return false
+	}
+
+	// Update in place, or move item if indexed fields changed unless another one has its key
+	if ms.indexesChanged(old, replacement) {
+		if ms.duplicate(replacement, internalFound) {
+			return false
+		}
+		ms.reposition(internalFound, replacement)
+	} else {
+		internalFound.value = replacement
+		ms.refresh(internalFound)
+	}
+	op.addItems(1)
+
+	return true
+}
@@ -0,0 +1,197 @@
+package memstore
+
+/*
+	Treap mirroring the tree of an index, where every node holds the aggregate of its subtree
+
+	Nodes are ordered like the index tree, so any range aggregate combines O(log n) nodes
+*/
+type augmentedTree struct {
+	index string
+	agg   Aggregate
+	root  *augmentedNode
+
+	// State of the generator of node priorities
+	seed uint64
+}
+
+type augmentedNode struct {
+	ii       *internalItem
+	priority uint64
+
+	// Value extracted from the item, and aggregate of the whole subtree
+	value interface{}
+	total interface{}
+
+	left, right *augmentedNode
+}
+
+func newAugmentedTree(index string, agg Aggregate) *augmentedTree {
+	return &augmentedTree{
+		index: index,
+		agg:   agg,
+		seed:  0x9e3779b97f4a7c15,
+	}
+}
+
+// Next pseudo-random priority (xorshift)
+func (t *augmentedTree) nextPriority() uint64 {
+	t.seed ^= t.seed << 13
+	t.seed ^= t.seed >> 7
+	t.seed ^= t.seed << 17
+	return t.seed
+}
+
+func (t *augmentedTree) totalOf(n *augmentedNode) interface{} {
+	if n == nil {
+		return t.agg.Identity
+	}
+	return n.total
+}
+
+// Recompute aggregate of a subtree from its children
+func (t *augmentedTree) pull(n *augmentedNode) {
+	n.total = t.agg.Combine(t.agg.Combine(t.totalOf(n.left), n.value), t.totalOf(n.right))
+}
+
+func (t *augmentedTree) rotateLeft(n *augmentedNode) *augmentedNode {
+	r := n.right
+	n.right = r.left
+	r.left = n
+	t.pull(n)
+	t.pull(r)
+	return r
+}
+
+func (t *augmentedTree) rotateRight(n *augmentedNode) *augmentedNode {
+	l := n.left
+	n.left = l.right
+	l.right = n
+	t.pull(n)
+	t.pull(l)
+	return l
+}
+
+func (t *augmentedTree) insert(ii *internalItem) {
+	t.root = t.insertAt(t.root, &augmentedNode{
+		ii:       ii,
+		priority: t.nextPriority(),
+		value:    t.agg.Extract(ii.value),
+	})
+}
+
+func (t *augmentedTree) insertAt(n, inserted *augmentedNode) *augmentedNode {
+	if n == nil {
+		inserted.total = inserted.value
+		return inserted
+	}
+
+	switch {
+	case inserted.ii.Less(t.index, n.ii):
+		n.left = t.insertAt(n.left, inserted)
+		if n.left.priority > n.priority {
+			return t.rotateRight(n)
+		}
+	case n.ii.Less(t.index, inserted.ii):
+		n.right = t.insertAt(n.right, inserted)
+		if n.right.priority > n.priority {
+			return t.rotateLeft(n)
+		}
+	default:
+		// Same key, replace item
+		n.ii = inserted.ii
+		n.value = inserted.value
+	}
+
+	t.pull(n)
+	return n
+}
+
+func (t *augmentedTree) remove(ii *internalItem) {
+	t.root = t.removeAt(t.root, ii)
+}
+
+func (t *augmentedTree) removeAt(n *augmentedNode, ii *internalItem) *augmentedNode {
+	if n == nil {
+		return nil
+	}
+
+	switch {
+	case ii.Less(t.index, n.ii):
+		n.left = t.removeAt(n.left, ii)
+	case n.ii.Less(t.index, ii):
+		n.right = t.removeAt(n.right, ii)
+	default:
+		return t.merge(n.left, n.right)
+	}
+
+	t.pull(n)
+	return n
+}
+
+// Merge two treaps, every node of the left one being before the right one
+func (t *augmentedTree) merge(left, right *augmentedNode) *augmentedNode {
+	if left == nil {
+		return right
+	}
+	if right == nil {
+		return left
+	}
+	if left.priority > right.priority {
+		left.right = t.merge(left.right, right)
+		t.pull(left)
+		return left
+	}
+	right.left = t.merge(left, right.left)
+	t.pull(right)
+	return right
+}
+
+// Extract value again after item changed in place (indexed fields must not have changed)
+func (t *augmentedTree) update(ii *internalItem) {
+	t.updateAt(t.root, ii)
+}
+
+func (t *augmentedTree) updateAt(n *augmentedNode, ii *internalItem) {
+	if n == nil {
+		return
+	}
+
+	switch {
+	case ii.Less(t.index, n.ii):
+		t.updateAt(n.left, ii)
+	case n.ii.Less(t.index, ii):
+		t.updateAt(n.right, ii)
+	default:
+		n.value = t.agg.Extract(n.ii.value)
+	}
+
+	t.pull(n)
+}
+
+// Aggregate of items within range
+func (t *augmentedTree) query(r Range) interface{} {
+	return t.queryAt(t.root, r, r.From.kind == unbounded, r.To.kind == unbounded)
+}
+
+// Aggregate of a subtree within range, open flags telling a side can't exclude any node
+func (t *augmentedTree) queryAt(n *augmentedNode, r Range, fromOpen, toOpen bool) interface{} {
+	if n == nil {
+		return t.agg.Identity
+	}
+	if fromOpen && toOpen {
+		return n.total
+	}
+
+	x := n.ii.value
+	if !fromOpen && !r.From.admitsFrom(t.index, x) {
+		return t.queryAt(n.right, r, fromOpen, toOpen)
+	}
+	if !toOpen && !r.To.admitsTo(t.index, x) {
+		return t.queryAt(n.left, r, fromOpen, toOpen)
+	}
+
+	// Node is within range: everything on its left is below upper bound, and on its right above lower bound
+	left := t.queryAt(n.left, r, fromOpen, true)
+	right := t.queryAt(n.right, r, true, toOpen)
+	return t.agg.Combine(t.agg.Combine(left, n.value), right)
+}
@@ -0,0 +1,428 @@
+package memstore
+
+import (
+	"fmt"
+	"github.com/mngharbi/GoLLRB/llrb"
+	"sort"
+)
+
+// Minimum number of children of inner nodes (except the root)
+const btreeDegree = 32
+
+/*
+	B-tree backend
+
+	Every node holds between degree-1 and 2*degree-1 items (except the root), in order,
+	and inner nodes have one more child than items
+*/
+type btreeIndex struct {
+	index  string
+	root   *btreeNode
+	length int
+}
+
+type btreeNode struct {
+	items    []llrb.Item
+	children []*btreeNode
+}
+
+func newBTreeIndex(index string) *btreeIndex {
+	return &btreeIndex{index: index}
+}
+
+func (t *btreeIndex) maxItems() int {
+	return 2*btreeDegree - 1
+}
+
+func (t *btreeIndex) minItems() int {
+	return btreeDegree - 1
+}
+
+func (t *btreeIndex) less(a, b llrb.Item) bool {
+	return a.Less(t.index, b)
+}
+
+// Position of the first item of a node not less than key, and whether it is equivalent to key
+func (t *btreeIndex) find(n *btreeNode, key llrb.Item) (int, bool) {
+	i := sort.Search(len(n.items), func(i int) bool {
+		return !t.less(n.items[i], key)
+	})
+	return i, i < len(n.items) && !t.less(key, n.items[i])
+}
+
+// Position of the first item of a node greater than key
+func (t *btreeIndex) findAfter(n *btreeNode, key llrb.Item) int {
+	return sort.Search(len(n.items), func(i int) bool {
+		return t.less(key, n.items[i])
+	})
+}
+
+func (t *btreeIndex) Len() int {
+	return t.length
+}
+
+func (t *btreeIndex) Get(key llrb.Item) llrb.Item {
+	n := t.root
+	for n != nil {
+		i, found := t.find(n, key)
+		if found {
+			return n.items[i]
+		}
+		if len(n.children) == 0 {
+			return nil
+		}
+		n = n.children[i]
+	}
+	return nil
+}
+
+func (t *btreeIndex) Min() llrb.Item {
+	n := t.root
+	if n == nil || len(n.items) == 0 {
+		return nil
+	}
+	for len(n.children) > 0 {
+		n = n.children[0]
+	}
+	return n.items[0]
+}
+
+func (t *btreeIndex) Max() llrb.Item {
+	n := t.root
+	if n == nil || len(n.items) == 0 {
+		return nil
+	}
+	for len(n.children) > 0 {
+		n = n.children[len(n.children)-1]
+	}
+	return n.items[len(n.items)-1]
+}
+
+func (t *btreeIndex) ReplaceOrInsert(item llrb.Item) llrb.Item {
+	if t.root == nil {
+		t.root = &btreeNode{}
+	}
+
+	// Split full root first, so that inserting never goes back up
+	if len(t.root.items) >= t.maxItems() {
+		middle, second := t.split(t.root, t.maxItems()/2)
+		t.root = &btreeNode{
+			items:    []llrb.Item{middle},
+			children: []*btreeNode{t.root, second},
+		}
+	}
+
+	replaced := t.insert(t.root, item)
+	if replaced == nil {
+		t.length++
+	}
+	return replaced
+}
+
+// Split a node at an item, returns the item and the node made of what followed it
+func (t *btreeIndex) split(n *btreeNode, i int) (llrb.Item, *btreeNode) {
+	middle := n.items[i]
+
+	second := &btreeNode{}
+	second.items = append(second.items, n.items[i+1:]...)
+	clearItems(n.items[i:])
+	n.items = n.items[:i]
+
+	if len(n.children) > 0 {
+		second.children = append(second.children, n.children[i+1:]...)
+		clearChildren(n.children[i+1:])
+		n.children = n.children[:i+1]
+	}
+
+	return middle, second
+}
+
+// Insert in a node that isn't full
+func (t *btreeIndex) insert(n *btreeNode, item llrb.Item) llrb.Item {
+	i, found := t.find(n, item)
+	if found {
+		replaced := n.items[i]
+		n.items[i] = item
+		return replaced
+	}
+
+	if len(n.children) == 0 {
+		n.items = insertItem(n.items, i, item)
+		return nil
+	}
+
+	// Split full child before going down, the item moved up may be where to insert
+	if len(n.children[i].items) >= t.maxItems() {
+		middle, second := t.split(n.children[i], t.maxItems()/2)
+		n.items = insertItem(n.items, i, middle)
+		n.children = insertChild(n.children, i+1, second)
+
+		switch {
+		case t.less(item, middle):
+		case t.less(middle, item):
+			i++
+		default:
+			n.items[i] = item
+			return middle
+		}
+	}
+
+	return t.insert(n.children[i], item)
+}
+
+// What to remove from a subtree
+type btreeRemoval int
+
+const (
+	removeKey btreeRemoval = iota
+	removeMax
+)
+
+func (t *btreeIndex) Delete(key llrb.Item) llrb.Item {
+	if t.root == nil || len(t.root.items) == 0 {
+		return nil
+	}
+
+	deleted := t.remove(t.root, key, removeKey)
+
+	// Root emptied by a merge is replaced by its only child
+	if len(t.root.items) == 0 && len(t.root.children) > 0 {
+		t.root = t.root.children[0]
+	}
+
+	if deleted != nil {
+		t.length--
+	}
+	return deleted
+}
+
+// Remove from a subtree, making sure every node gone through can spare an item
+func (t *btreeIndex) remove(n *btreeNode, key llrb.Item, removal btreeRemoval) llrb.Item {
+	var i int
+	var found bool
+
+	switch removal {
+	case removeMax:
+		if len(n.children) == 0 {
+			last := n.items[len(n.items)-1]
+			n.items[len(n.items)-1] = nil
+			n.items = n.items[:len(n.items)-1]
+			return last
+		}
+		i = len(n.items)
+	default:
+		i, found = t.find(n, key)
+		if len(n.children) == 0 {
+			if !found {
+				return nil
+			}
+			deleted := n.items[i]
+			n.items = removeItem(n.items, i)
+			return deleted
+		}
+	}
+
+	if len(n.children[i].items) <= t.minItems() {
+		t.growChild(n, i)
+		return t.remove(n, key, removal)
+	}
+
+	// Replace item found in inner node with its predecessor
+	if found {
+		deleted := n.items[i]
+		n.items[i] = t.remove(n.children[i], nil, removeMax)
+		return deleted
+	}
+
+	return t.remove(n.children[i], key, removal)
+}
+
+// Give a child an extra item, stealing from a sibling or merging with one
+func (t *btreeIndex) growChild(n *btreeNode, i int) {
+	switch {
+	case i > 0 && len(n.children[i-1].items) > t.minItems():
+		child, left := n.children[i], n.children[i-1]
+
+		stolen := left.items[len(left.items)-1]
+		left.items[len(left.items)-1] = nil
+		left.items = left.items[:len(left.items)-1]
+		child.items = insertItem(child.items, 0, n.items[i-1])
+		n.items[i-1] = stolen
+
+		if len(left.children) > 0 {
+			last := left.children[len(left.children)-1]
+			left.children[len(left.children)-1] = nil
+			left.children = left.children[:len(left.children)-1]
+			child.children = insertChild(child.children, 0, last)
+		}
+
+	case i < len(n.items) && len(n.children[i+1].items) > t.minItems():
+		child, right := n.children[i], n.children[i+1]
+
+		stolen := right.items[0]
+		right.items = removeItem(right.items, 0)
+		child.items = append(child.items, n.items[i])
+		n.items[i] = stolen
+
+		if len(right.children) > 0 {
+			first := right.children[0]
+			right.children = removeChild(right.children, 0)
+			child.children = append(child.children, first)
+		}
+
+	default:
+		if i >= len(n.items) {
+			i--
+		}
+		child, right := n.children[i], n.children[i+1]
+
+		child.items = append(child.items, n.items[i])
+		child.items = append(child.items, right.items...)
+		child.children = append(child.children, right.children...)
+		n.items = removeItem(n.items, i)
+		n.children = removeChild(n.children, i+1)
+	}
+}
+
+func (t *btreeIndex) AscendGreaterOrEqual(pivot llrb.Item, iterator llrb.ItemIterator) {
+	if t.root != nil {
+		t.ascend(t.root, pivot, iterator)
+	}
+}
+
+// Ascend from pivot (from the first item if nil), returns false once iterator stopped
+func (t *btreeIndex) ascend(n *btreeNode, pivot llrb.Item, iterator llrb.ItemIterator) bool {
+	i := 0
+	if pivot != nil {
+		i, _ = t.find(n, pivot)
+	}
+
+	for ; i < len(n.items); i++ {
+		if len(n.children) > 0 && !t.ascend(n.children[i], pivot, iterator) {
+			return false
+		}
+		// Everything after the first child visited is past pivot
+		pivot = nil
+		if !iterator(n.items[i]) {
+			return false
+		}
+	}
+
+	if len(n.children) > 0 {
+		return t.ascend(n.children[len(n.items)], pivot, iterator)
+	}
+	return true
+}
+
+func (t *btreeIndex) DescendLessOrEqual(pivot llrb.Item, iterator llrb.ItemIterator) {
+	if t.root != nil {
+		t.descend(t.root, pivot, iterator)
+	}
+}
+
+// Descend from pivot (from the last item if nil), returns false once iterator stopped
+func (t *btreeIndex) descend(n *btreeNode, pivot llrb.Item, iterator llrb.ItemIterator) bool {
+	i := len(n.items)
+	if pivot != nil {
+		i = t.findAfter(n, pivot)
+	}
+
+	if len(n.children) > 0 && !t.descend(n.children[i], pivot, iterator) {
+		return false
+	}
+
+	for i--; i >= 0; i-- {
+		if !iterator(n.items[i]) {
+			return false
+		}
+		if len(n.children) > 0 && !t.descend(n.children[i], nil, iterator) {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *btreeIndex) clear() {
+	t.root = nil
+	t.length = 0
+}
+
+func (t *btreeIndex) height() int {
+	res := 0
+	for n := t.root; n != nil && len(n.items) > 0; res++ {
+		if len(n.children) == 0 {
+			return res + 1
+		}
+		n = n.children[0]
+	}
+	return res
+}
+
+// Check node sizes, number of children, and that every leaf is at the same depth
+func (t *btreeIndex) check(ir *IndexReport) {
+	if t.root == nil {
+		return
+	}
+	leafDepth := -1
+	t.checkNode(t.root, 0, &leafDepth, ir)
+}
+
+func (t *btreeIndex) checkNode(n *btreeNode, depth int, leafDepth *int, ir *IndexReport) {
+	if len(n.items) > t.maxItems() || (n != t.root && len(n.items) < t.minItems()) {
+		ir.addStructureViolation(fmt.Sprintf("node at depth %v has %v items", depth, len(n.items)))
+	}
+
+	if len(n.children) == 0 {
+		if *leafDepth == -1 {
+			*leafDepth = depth
+		} else if *leafDepth != depth {
+			ir.addStructureViolation(fmt.Sprintf("leaves at depths %v and %v", *leafDepth, depth))
+		}
+		return
+	}
+
+	if len(n.children) != len(n.items)+1 {
+		ir.addStructureViolation(fmt.Sprintf("node at depth %v has %v items but %v children", depth, len(n.items), len(n.children)))
+	}
+	for _, child := range n.children {
+		t.checkNode(child, depth+1, leafDepth, ir)
+	}
+}
+
+func insertItem(items []llrb.Item, i int, item llrb.Item) []llrb.Item {
+	items = append(items, nil)
+	copy(items[i+1:], items[i:])
+	items[i] = item
+	return items
+}
+
+func removeItem(items []llrb.Item, i int) []llrb.Item {
+	copy(items[i:], items[i+1:])
+	items[len(items)-1] = nil
+	return items[:len(items)-1]
+}
+
+func clearItems(items []llrb.Item) {
+	for i := range items {
+		items[i] = nil
+	}
+}
+
+func insertChild(children []*btreeNode, i int, child *btreeNode) []*btreeNode {
+	children = append(children, nil)
+	copy(children[i+1:], children[i:])
+	children[i] = child
+	return children
+}
+
+func removeChild(children []*btreeNode, i int) []*btreeNode {
+	copy(children[i:], children[i+1:])
+	children[len(children)-1] = nil
+	return children[:len(children)-1]
+}
+
+func clearChildren(children []*btreeNode) {
+	for i := range children {
+		children[i] = nil
+	}
+}
@@ -0,0 +1,42 @@
+package example
+
+import (
+	"github.com/mngharbi/memstore"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func benchmarkEvents(n int) []Event {
+	events := make([]Event, n)
+	for i := range events {
+		events[i] = Event{
+			ID:      uint64(i),
+			Email:   strconv.Itoa(i) + "@x",
+			Tenant:  strconv.Itoa(i % 10),
+			Time:    time.Unix(int64(i%100), 0),
+			Level:   Level(i % 3),
+			Payload: []byte(strconv.Itoa(i % 7)),
+			Version: Version{i % 5, i % 2},
+		}
+	}
+	return events
+}
+
+func BenchmarkGeneratedAdd(b *testing.B) {
+	events := benchmarkEvents(b.N)
+	s := NewEventStore()
+	b.ResetTimer()
+	for _, e := range events {
+		s.Add(e)
+	}
+}
+
+func BenchmarkReflectionAdd(b *testing.B) {
+	events := benchmarkEvents(b.N)
+	s, _ := memstore.NewFromStruct[Event]()
+	b.ResetTimer()
+	for _, e := range events {
+		s.Add(e)
+	}
+}
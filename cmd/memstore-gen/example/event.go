@@ -0,0 +1,44 @@
+/*
+	Store generated by memstore-gen for a struct, kept up to date by the tests of memstore-gen
+*/
+
+package example
+
+import (
+	"time"
+)
+
+//go:generate go run github.com/mngharbi/memstore/cmd/memstore-gen -type Event
+
+type Event struct {
+	ID      uint64    `memstore:"index=id,primary"`
+	Email   string    `memstore:"index=email,unique"`
+	Tenant  string    `memstore:"index=tenant_ts,part=0"`
+	Time    time.Time `memstore:"index=tenant_ts,part=1"`
+	Level   Level     `memstore:"index=level"`
+	Payload []byte    `memstore:"index=payload"`
+	Version Version   `memstore:"index=version"`
+	Note    string
+}
+
+// Severity of an event
+type Level int8
+
+const (
+	Debug Level = iota
+	Info
+	Error
+)
+
+// Version compared by its own Less, as custom types are
+type Version struct {
+	Major, Minor int
+}
+
+func (v Version) Less(index string, than interface{}) bool {
+	other := than.(Version)
+	if v.Major != other.Major {
+		return v.Major < other.Major
+	}
+	return v.Minor < other.Minor
+}
@@ -0,0 +1,387 @@
+// Code generated by memstore-gen -type Event; DO NOT EDIT.
+
+package example
+
+import (
+	"bytes"
+	"github.com/mngharbi/memstore"
+)
+
+// Indexes of EventStore
+const (
+	EventIndexID       = "id"
+	EventIndexEmail    = "email"
+	EventIndexTenantTs = "tenant_ts"
+	EventIndexLevel    = "level"
+	EventIndexPayload  = "payload"
+	EventIndexVersion  = "version"
+)
+
+/*
+Event as stored by EventStore
+
+Probes placed before (-1) every struct with the same fields of an index find items of non-unique indexes
+without knowing their primary key.
+*/
+type eventItem struct {
+	value Event
+	edge  int
+}
+
+func (x eventItem) Less(index string, than interface{}) bool {
+	y := than.(eventItem)
+	switch index {
+	case EventIndexID:
+		if c := compareEventID(&x.value, &y.value); c != 0 {
+			return c < 0
+		}
+		return x.edge < y.edge
+	case EventIndexEmail:
+		if c := compareEventEmail(&x.value, &y.value); c != 0 {
+			return c < 0
+		}
+		return x.edge < y.edge
+	case EventIndexTenantTs:
+		if c := compareEventTenantTs(&x.value, &y.value); c != 0 {
+			return c < 0
+		}
+		if x.edge != y.edge {
+			return x.edge < y.edge
+		}
+		return compareEventID(&x.value, &y.value) < 0
+	case EventIndexLevel:
+		if c := compareEventLevel(&x.value, &y.value); c != 0 {
+			return c < 0
+		}
+		if x.edge != y.edge {
+			return x.edge < y.edge
+		}
+		return compareEventID(&x.value, &y.value) < 0
+	case EventIndexPayload:
+		if c := compareEventPayload(&x.value, &y.value); c != 0 {
+			return c < 0
+		}
+		if x.edge != y.edge {
+			return x.edge < y.edge
+		}
+		return compareEventID(&x.value, &y.value) < 0
+	case EventIndexVersion:
+		if c := compareEventVersion(&x.value, &y.value); c != 0 {
+			return c < 0
+		}
+		if x.edge != y.edge {
+			return x.edge < y.edge
+		}
+		return compareEventID(&x.value, &y.value) < 0
+	}
+	return false
+}
+
+// Raw key of indexes of a single field, so that queries can match them
+func (x eventItem) Key(index string) interface{} {
+	switch index {
+	case EventIndexID:
+		return x.value.ID
+	case EventIndexEmail:
+		return x.value.Email
+	case EventIndexLevel:
+		return x.value.Level
+	case EventIndexPayload:
+		return x.value.Payload
+	case EventIndexVersion:
+		return x.value.Version
+	}
+	return nil
+}
+
+// Order of Events by the fields of index id
+func compareEventID(a, b *Event) int {
+	switch {
+	case a.ID < b.ID:
+		return -1
+	case a.ID > b.ID:
+		return 1
+	}
+	return 0
+}
+
+// Order of Events by the fields of index email
+func compareEventEmail(a, b *Event) int {
+	switch {
+	case a.Email < b.Email:
+		return -1
+	case a.Email > b.Email:
+		return 1
+	}
+	return 0
+}
+
+// Order of Events by the fields of index tenant_ts
+func compareEventTenantTs(a, b *Event) int {
+	switch {
+	case a.Tenant < b.Tenant:
+		return -1
+	case a.Tenant > b.Tenant:
+		return 1
+	}
+	switch {
+	case a.Time.Before(b.Time):
+		return -1
+	case a.Time.After(b.Time):
+		return 1
+	}
+	return 0
+}
+
+// Order of Events by the fields of index level
+func compareEventLevel(a, b *Event) int {
+	switch {
+	case a.Level < b.Level:
+		return -1
+	case a.Level > b.Level:
+		return 1
+	}
+	return 0
+}
+
+// Order of Events by the fields of index payload
+func compareEventPayload(a, b *Event) int {
+	if c := bytes.Compare(a.Payload, b.Payload); c != 0 {
+		return c
+	}
+	return 0
+}
+
+// Order of Events by the fields of index version
+func compareEventVersion(a, b *Event) int {
+	switch {
+	case a.Version.Less("version", b.Version):
+		return -1
+	case b.Version.Less("version", a.Version):
+		return 1
+	}
+	return 0
+}
+
+/*
+Store of Events indexed as told by their tags, comparing fields without reflection
+
+Structs are looked up by structs holding the fields of an index, other fields being ignored.
+*/
+type EventStore struct {
+	ms *memstore.Memstore
+}
+
+// Updates move structs within indexes unless options say otherwise (see memstore.WithIndexChangePolicy)
+func NewEventStore(options ...memstore.Option) *EventStore {
+	options = append([]memstore.Option{memstore.WithIndexChangePolicy(memstore.RepositionOnIndexChange)}, options...)
+	options = append(options, memstore.WithUnique(EventIndexEmail))
+	return &EventStore{
+		ms: memstore.New([]string{EventIndexID, EventIndexEmail, EventIndexTenantTs, EventIndexLevel, EventIndexPayload, EventIndexVersion}, options...),
+	}
+}
+
+// Underlying store, holding items that order Events (for aggregates, queries, metrics...)
+func (s *EventStore) Store() *memstore.Memstore {
+	return s.ms
+}
+
+func (s *EventStore) Len() int {
+	return s.ms.Len()
+}
+
+// Add struct, replacing the one with the same primary key, fails with ErrDuplicate if another one has the same unique fields
+func (s *EventStore) Add(x Event) error {
+	return s.ms.Put(eventItem{value: x})
+}
+
+// Get the struct with the same primary key
+func (s *EventStore) Get(x Event) (Event, bool) {
+	found := s.ms.Get(eventItem{value: x}, EventIndexID)
+	if found == nil {
+		return Event{}, false
+	}
+	return found.(eventItem).value, true
+}
+
+// Get the struct with the same fields of index email
+func (s *EventStore) GetByEmail(x Event) (Event, bool) {
+	found := s.ms.Get(eventItem{value: x}, EventIndexEmail)
+	if found == nil {
+		return Event{}, false
+	}
+	return found.(eventItem).value, true
+}
+
+// Get the first struct by primary key with the same fields of index tenant_ts
+func (s *EventStore) GetByTenantTs(x Event) (res Event, ok bool) {
+	r := memstore.NewRange(memstore.Inclusive(eventItem{value: x, edge: -1}), memstore.Unbounded())
+	s.ms.GetRangeBounds(r, EventIndexTenantTs, func(found memstore.Item) bool {
+		res = found.(eventItem).value
+		ok = compareEventTenantTs(&x, &res) == 0
+		return false
+	})
+	if !ok {
+		return Event{}, false
+	}
+	return res, true
+}
+
+// Get the first struct by primary key with the same fields of index level
+func (s *EventStore) GetByLevel(x Event) (res Event, ok bool) {
+	r := memstore.NewRange(memstore.Inclusive(eventItem{value: x, edge: -1}), memstore.Unbounded())
+	s.ms.GetRangeBounds(r, EventIndexLevel, func(found memstore.Item) bool {
+		res = found.(eventItem).value
+		ok = compareEventLevel(&x, &res) == 0
+		return false
+	})
+	if !ok {
+		return Event{}, false
+	}
+	return res, true
+}
+
+// Get the first struct by primary key with the same fields of index payload
+func (s *EventStore) GetByPayload(x Event) (res Event, ok bool) {
+	r := memstore.NewRange(memstore.Inclusive(eventItem{value: x, edge: -1}), memstore.Unbounded())
+	s.ms.GetRangeBounds(r, EventIndexPayload, func(found memstore.Item) bool {
+		res = found.(eventItem).value
+		ok = compareEventPayload(&x, &res) == 0
+		return false
+	})
+	if !ok {
+		return Event{}, false
+	}
+	return res, true
+}
+
+// Get the first struct by primary key with the same fields of index version
+func (s *EventStore) GetByVersion(x Event) (res Event, ok bool) {
+	r := memstore.NewRange(memstore.Inclusive(eventItem{value: x, edge: -1}), memstore.Unbounded())
+	s.ms.GetRangeBounds(r, EventIndexVersion, func(found memstore.Item) bool {
+		res = found.(eventItem).value
+		ok = compareEventVersion(&x, &res) == 0
+		return false
+	})
+	if !ok {
+		return Event{}, false
+	}
+	return res, true
+}
+
+// Iterate over structs from the fields of from (inclusive) to those of to (exclusive) for index id, until test returns false
+func (s *EventStore) RangeByID(from, to Event, test func(Event) bool) {
+	r := memstore.NewRange(memstore.Inclusive(eventItem{value: from, edge: -1}), memstore.Exclusive(eventItem{value: to, edge: -1}))
+	s.ms.GetRangeBounds(r, EventIndexID, func(x memstore.Item) bool {
+		return test(x.(eventItem).value)
+	})
+}
+
+// Iterate over every struct in order of index id, until test returns false
+func (s *EventStore) AscendByID(test func(Event) bool) {
+	s.ms.GetRangeBounds(memstore.All(), EventIndexID, func(x memstore.Item) bool {
+		return test(x.(eventItem).value)
+	})
+}
+
+// Iterate over structs from the fields of from (inclusive) to those of to (exclusive) for index email, until test returns false
+func (s *EventStore) RangeByEmail(from, to Event, test func(Event) bool) {
+	r := memstore.NewRange(memstore.Inclusive(eventItem{value: from, edge: -1}), memstore.Exclusive(eventItem{value: to, edge: -1}))
+	s.ms.GetRangeBounds(r, EventIndexEmail, func(x memstore.Item) bool {
+		return test(x.(eventItem).value)
+	})
+}
+
+// Iterate over every struct in order of index email, until test returns false
+func (s *EventStore) AscendByEmail(test func(Event) bool) {
+	s.ms.GetRangeBounds(memstore.All(), EventIndexEmail, func(x memstore.Item) bool {
+		return test(x.(eventItem).value)
+	})
+}
+
+// Iterate over structs from the fields of from (inclusive) to those of to (exclusive) for index tenant_ts, until test returns false
+func (s *EventStore) RangeByTenantTs(from, to Event, test func(Event) bool) {
+	r := memstore.NewRange(memstore.Inclusive(eventItem{value: from, edge: -1}), memstore.Exclusive(eventItem{value: to, edge: -1}))
+	s.ms.GetRangeBounds(r, EventIndexTenantTs, func(x memstore.Item) bool {
+		return test(x.(eventItem).value)
+	})
+}
+
+// Iterate over every struct in order of index tenant_ts, until test returns false
+func (s *EventStore) AscendByTenantTs(test func(Event) bool) {
+	s.ms.GetRangeBounds(memstore.All(), EventIndexTenantTs, func(x memstore.Item) bool {
+		return test(x.(eventItem).value)
+	})
+}
+
+// Iterate over structs from the fields of from (inclusive) to those of to (exclusive) for index level, until test returns false
+func (s *EventStore) RangeByLevel(from, to Event, test func(Event) bool) {
+	r := memstore.NewRange(memstore.Inclusive(eventItem{value: from, edge: -1}), memstore.Exclusive(eventItem{value: to, edge: -1}))
+	s.ms.GetRangeBounds(r, EventIndexLevel, func(x memstore.Item) bool {
+		return test(x.(eventItem).value)
+	})
+}
+
+// Iterate over every struct in order of index level, until test returns false
+func (s *EventStore) AscendByLevel(test func(Event) bool) {
+	s.ms.GetRangeBounds(memstore.All(), EventIndexLevel, func(x memstore.Item) bool {
+		return test(x.(eventItem).value)
+	})
+}
+
+// Iterate over structs from the fields of from (inclusive) to those of to (exclusive) for index payload, until test returns false
+func (s *EventStore) RangeByPayload(from, to Event, test func(Event) bool) {
+	r := memstore.NewRange(memstore.Inclusive(eventItem{value: from, edge: -1}), memstore.Exclusive(eventItem{value: to, edge: -1}))
+	s.ms.GetRangeBounds(r, EventIndexPayload, func(x memstore.Item) bool {
+		return test(x.(eventItem).value)
+	})
+}
+
+// Iterate over every struct in order of index payload, until test returns false
+func (s *EventStore) AscendByPayload(test func(Event) bool) {
+	s.ms.GetRangeBounds(memstore.All(), EventIndexPayload, func(x memstore.Item) bool {
+		return test(x.(eventItem).value)
+	})
+}
+
+// Iterate over structs from the fields of from (inclusive) to those of to (exclusive) for index version, until test returns false
+func (s *EventStore) RangeByVersion(from, to Event, test func(Event) bool) {
+	r := memstore.NewRange(memstore.Inclusive(eventItem{value: from, edge: -1}), memstore.Exclusive(eventItem{value: to, edge: -1}))
+	s.ms.GetRangeBounds(r, EventIndexVersion, func(x memstore.Item) bool {
+		return test(x.(eventItem).value)
+	})
+}
+
+// Iterate over every struct in order of index version, until test returns false
+func (s *EventStore) AscendByVersion(test func(Event) bool) {
+	s.ms.GetRangeBounds(memstore.All(), EventIndexVersion, func(x memstore.Item) bool {
+		return test(x.(eventItem).value)
+	})
+}
+
+// Delete the struct with the same primary key
+func (s *EventStore) Delete(x Event) (Event, bool) {
+	deleted := s.ms.Delete(eventItem{value: x}, EventIndexID)
+	if deleted == nil {
+		return Event{}, false
+	}
+	return deleted.(eventItem).value, true
+}
+
+/*
+Replace the struct with the same primary key with the result of modify, moving it within indexes
+
+Fails with ErrNotFound, ErrNotModified if modify rejects the struct, ErrDuplicate, or ErrReadOnly on followers
+*/
+func (s *EventStore) Update(x Event, modify func(Event) (Event, bool)) (Event, error) {
+	// Modify runs on the struct stored under the write lock
+	var modified Event
+	if _, err := s.ms.Update(eventItem{value: x}, EventIndexID, func(current memstore.Item) (memstore.Item, bool) {
+		var ok bool
+		modified, ok = modify(current.(eventItem).value)
+		return eventItem{value: modified}, ok
+	}); err != nil {
+		return Event{}, err
+	}
+	return modified, nil
+}
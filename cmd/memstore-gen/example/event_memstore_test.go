@@ -0,0 +1,150 @@
+// Code generated by memstore-gen -type Event; DO NOT EDIT.
+
+package example
+
+import (
+	"github.com/mngharbi/memstore"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Events differing on every indexed field, in increasing order
+func eventSamples() []Event {
+	return []Event{
+		{ID: 1, Email: "a", Tenant: "a", Time: time.Unix(1, 0), Level: 1, Payload: []byte("a")},
+		{ID: 2, Email: "b", Tenant: "b", Time: time.Unix(2, 0), Level: 2, Payload: []byte("b")},
+	}
+}
+
+func TestEventStoreOrder(t *testing.T) {
+	reference, err := memstore.NewFromStruct[Event]()
+	if err != nil {
+		t.Fatalf("Creating store from tags failed, err=%v", err)
+	}
+	s := NewEventStore()
+	samples := eventSamples()
+	for i := len(samples) - 1; i >= 0; i-- {
+		if err := reference.Add(samples[i]); err != nil {
+			t.Fatalf("Adding to store from tags failed, err=%v", err)
+		}
+		if err := s.Add(samples[i]); err != nil {
+			t.Fatalf("Adding failed, err=%v", err)
+		}
+	}
+
+	// Generated comparators order structs like reflection does
+	for _, index := range reference.Indexes() {
+		var expected, got []Event
+		reference.Ascend(index.Name, func(x Event) bool {
+			expected = append(expected, x)
+			return true
+		})
+		s.Store().GetRangeBounds(memstore.All(), index.Name, func(x memstore.Item) bool {
+			got = append(got, x.(eventItem).value)
+			return true
+		})
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("Index %v is in the wrong order, expected=%v got=%v", index.Name, expected, got)
+		}
+	}
+
+	var idRange []Event
+	s.RangeByID(samples[0], samples[1], func(x Event) bool {
+		idRange = append(idRange, x)
+		return true
+	})
+	if !reflect.DeepEqual(idRange, samples[:1]) {
+		t.Errorf("Range of index id is wrong, got=%v", idRange)
+	}
+
+	var emailRange []Event
+	s.RangeByEmail(samples[0], samples[1], func(x Event) bool {
+		emailRange = append(emailRange, x)
+		return true
+	})
+	if !reflect.DeepEqual(emailRange, samples[:1]) {
+		t.Errorf("Range of index email is wrong, got=%v", emailRange)
+	}
+
+	var tenanttsRange []Event
+	s.RangeByTenantTs(samples[0], samples[1], func(x Event) bool {
+		tenanttsRange = append(tenanttsRange, x)
+		return true
+	})
+	if !reflect.DeepEqual(tenanttsRange, samples[:1]) {
+		t.Errorf("Range of index tenant_ts is wrong, got=%v", tenanttsRange)
+	}
+
+	var levelRange []Event
+	s.RangeByLevel(samples[0], samples[1], func(x Event) bool {
+		levelRange = append(levelRange, x)
+		return true
+	})
+	if !reflect.DeepEqual(levelRange, samples[:1]) {
+		t.Errorf("Range of index level is wrong, got=%v", levelRange)
+	}
+
+	var payloadRange []Event
+	s.RangeByPayload(samples[0], samples[1], func(x Event) bool {
+		payloadRange = append(payloadRange, x)
+		return true
+	})
+	if !reflect.DeepEqual(payloadRange, samples[:1]) {
+		t.Errorf("Range of index payload is wrong, got=%v", payloadRange)
+	}
+}
+
+func TestEventStoreWrites(t *testing.T) {
+	s := NewEventStore()
+	samples := eventSamples()
+	for _, x := range samples {
+		if err := s.Add(x); err != nil {
+			t.Fatalf("Adding failed, err=%v", err)
+		}
+	}
+	if err := s.Add(samples[0]); err != nil || s.Len() != len(samples) {
+		t.Errorf("Adding again should replace, err=%v len=%v", err, s.Len())
+	}
+
+	for _, x := range samples {
+		if found, ok := s.Get(x); !ok || !reflect.DeepEqual(found, x) {
+			t.Errorf("%v not found, found=%v", x, found)
+		}
+		if found, ok := s.GetByEmail(x); !ok || compareEventEmail(&found, &x) != 0 {
+			t.Errorf("%v not found by index email, found=%v", x, found)
+		}
+		if found, ok := s.GetByTenantTs(x); !ok || compareEventTenantTs(&found, &x) != 0 {
+			t.Errorf("%v not found by index tenant_ts, found=%v", x, found)
+		}
+		if found, ok := s.GetByLevel(x); !ok || compareEventLevel(&found, &x) != 0 {
+			t.Errorf("%v not found by index level, found=%v", x, found)
+		}
+		if found, ok := s.GetByPayload(x); !ok || compareEventPayload(&found, &x) != 0 {
+			t.Errorf("%v not found by index payload, found=%v", x, found)
+		}
+		if found, ok := s.GetByVersion(x); !ok || compareEventVersion(&found, &x) != 0 {
+			t.Errorf("%v not found by index version, found=%v", x, found)
+		}
+	}
+
+	if _, err := s.Update(samples[0], func(x Event) (Event, bool) {
+		return x, false
+	}); err != memstore.ErrNotModified {
+		t.Errorf("Update rejected by modify should fail, err=%v", err)
+	}
+	if _, err := s.Update(samples[0], func(x Event) (Event, bool) {
+		return samples[1], true
+	}); err != memstore.ErrDuplicate {
+		t.Errorf("Update to the fields of another struct should fail, err=%v", err)
+	}
+
+	for _, x := range samples {
+		if deleted, ok := s.Delete(x); !ok || !reflect.DeepEqual(deleted, x) {
+			t.Errorf("%v not deleted, deleted=%v", x, deleted)
+		}
+	}
+	if _, ok := s.Get(samples[0]); ok || s.Len() != 0 {
+		t.Errorf("Store should be empty, len=%v", s.Len())
+	}
+}
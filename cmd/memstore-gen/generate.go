@@ -0,0 +1,429 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// Comparison of a field of structs a and b in an index, returning when they differ
+func (f field) Comparison(index string) string {
+	a, b := "a."+f.Name, "b."+f.Name
+	var less, greater string
+	switch f.Kind {
+	case kindBytes:
+		return fmt.Sprintf("if c := bytes.Compare(%v, %v); c != 0 {\nreturn c\n}", a, b)
+	case kindBool:
+		less, greater = fmt.Sprintf("!%v && %v", a, b), fmt.Sprintf("%v && !%v", a, b)
+	case kindTime:
+		less, greater = fmt.Sprintf("%v.Before(%v)", a, b), fmt.Sprintf("%v.After(%v)", a, b)
+	case kindItem:
+		less = fmt.Sprintf("%v.Less(%q, %v)", a, index, b)
+		greater = fmt.Sprintf("%v.Less(%q, %v)", b, index, a)
+	default:
+		less, greater = fmt.Sprintf("%v < %v", a, b), fmt.Sprintf("%v > %v", a, b)
+	}
+	return fmt.Sprintf("switch {\ncase %v:\nreturn -1\ncase %v:\nreturn 1\n}", less, greater)
+}
+
+// Literal of the nth of increasing values used by generated tests, empty for items
+func (f field) Sample(n int) string {
+	switch f.Kind {
+	case kindString:
+		return strconv.Quote(string(rune('a' + n)))
+	case kindBool:
+		return strconv.FormatBool(n > 0)
+	case kindTime:
+		return fmt.Sprintf("time.Unix(%v, 0)", n+1)
+	case kindBytes:
+		return fmt.Sprintf("[]byte(%q)", string(rune('a'+n)))
+	case kindItem:
+		return ""
+	default:
+		return strconv.Itoa(n + 1)
+	}
+}
+
+func (m *model) Primary() index {
+	return m.Indexes[0]
+}
+
+func (m *model) Secondary() []index {
+	return m.Indexes[1:]
+}
+
+// Name of the type starting with a lower case, prefixing unexported names
+func (m *model) Unexported() string {
+	runes := []rune(m.Type)
+	return string(unicode.ToLower(runes[0])) + string(runes[1:])
+}
+
+func (m *model) Item() string {
+	return m.Unexported() + "Item"
+}
+
+func (m *model) uses(kind fieldKind) bool {
+	for _, ix := range m.Indexes {
+		for _, f := range ix.Compare {
+			if f.Kind == kind {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (m *model) UsesBytes() bool {
+	return m.uses(kindBytes)
+}
+
+func (m *model) UsesTime() bool {
+	return m.uses(kindTime)
+}
+
+// Fields set in samples of generated tests, each once
+func (m *model) Sampled() []field {
+	var res []field
+	seen := map[string]bool{}
+	for _, ix := range m.Indexes {
+		for _, f := range ix.Compare {
+			if !seen[f.Name] && f.Kind != kindItem {
+				seen[f.Name] = true
+				res = append(res, f)
+			}
+		}
+	}
+	return res
+}
+
+// Whether generated tests can make structs differing on every unique index
+func (m *model) Testable() bool {
+	for _, ix := range m.Indexes {
+		if ix.Unique && !ix.Sampled {
+			return false
+		}
+	}
+	return true
+}
+
+// Generated source of the store and of its tests, formatted
+func (m *model) generate(command string) (store, tests []byte, err error) {
+	store, err = m.execute(storeTemplate, command)
+	if err != nil {
+		return nil, nil, err
+	}
+	tests, err = m.execute(testsTemplate, command)
+	if err != nil {
+		return nil, nil, err
+	}
+	return store, tests, nil
+}
+
+func (m *model) execute(t *template.Template, command string) ([]byte, error) {
+	var buf bytes.Buffer
+	err := t.Execute(&buf, struct {
+		*model
+		Command string
+	}{m, command})
+	if err != nil {
+		return nil, err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting generated code: %w\n%s", err, buf.Bytes())
+	}
+	return src, nil
+}
+
+var funcs = template.FuncMap{
+	"lower": strings.ToLower,
+}
+
+var storeTemplate = template.Must(template.New("store").Funcs(funcs).Parse(`// Code generated by {{.Command}}; DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	{{if .UsesBytes}}"bytes"{{end}}
+	"github.com/mngharbi/memstore"
+)
+
+// Indexes of {{.Type}}Store
+const (
+{{- range .Indexes}}
+	{{$.Type}}Index{{.Ident}} = {{printf "%q" .Name}}
+{{- end}}
+)
+
+/*
+	{{.Type}} as stored by {{.Type}}Store
+
+	Probes placed before (-1) every struct with the same fields of an index find items of non-unique indexes
+	without knowing their primary key.
+*/
+type {{.Item}} struct {
+	value {{.Type}}
+	edge  int
+}
+
+func (x {{.Item}}) Less(index string, than interface{}) bool {
+	y := than.({{.Item}})
+	switch index {
+{{- range .Indexes}}
+	case {{$.Type}}Index{{.Ident}}:
+		if c := compare{{$.Type}}{{.Ident}}(&x.value, &y.value); c != 0 {
+			return c < 0
+		}
+	{{- if .Unique}}
+		return x.edge < y.edge
+	{{- else}}
+		if x.edge != y.edge {
+			return x.edge < y.edge
+		}
+		return compare{{$.Type}}{{$.Primary.Ident}}(&x.value, &y.value) < 0
+	{{- end}}
+{{- end}}
+	}
+	return false
+}
+
+// Raw key of indexes of a single field, so that queries can match them
+func (x {{.Item}}) Key(index string) interface{} {
+	switch index {
+{{- range .Indexes}}{{if eq (len .Compare) 1}}
+	case {{$.Type}}Index{{.Ident}}:
+		return x.value.{{(index .Compare 0).Name}}
+{{- end}}{{end}}
+	}
+	return nil
+}
+{{range .Indexes}}{{$index := .}}
+// Order of {{$.Type}}s by the fields of index {{.Name}}
+func compare{{$.Type}}{{.Ident}}(a, b *{{$.Type}}) int {
+{{- range .Compare}}
+	{{.Comparison $index.Name}}
+{{- end}}
+	return 0
+}
+{{end}}
+/*
+	Store of {{.Type}}s indexed as told by their tags, comparing fields without reflection
+
+	Structs are looked up by structs holding the fields of an index, other fields being ignored.
+*/
+type {{.Type}}Store struct {
+	ms *memstore.Memstore
+}
+
+// Updates move structs within indexes unless options say otherwise (see memstore.WithIndexChangePolicy)
+func New{{.Type}}Store(options ...memstore.Option) *{{.Type}}Store {
+	options = append([]memstore.Option{memstore.WithIndexChangePolicy(memstore.RepositionOnIndexChange)}, options...)
+	options = append(options, memstore.WithUnique(
+{{- range $i, $ix := .Secondary}}{{if $ix.Unique}}{{$.Type}}Index{{$ix.Ident}}, {{end}}{{end -}}
+	))
+	return &{{.Type}}Store{
+		ms: memstore.New([]string{ {{- range $i, $ix := .Indexes}}{{if $i}}, {{end}}{{$.Type}}Index{{$ix.Ident}}{{end -}} }, options...),
+	}
+}
+
+// Underlying store, holding items that order {{.Type}}s (for aggregates, queries, metrics...)
+func (s *{{.Type}}Store) Store() *memstore.Memstore {
+	return s.ms
+}
+
+func (s *{{.Type}}Store) Len() int {
+	return s.ms.Len()
+}
+
+// Add struct, replacing the one with the same primary key, fails with ErrDuplicate if another one has the same unique fields
+func (s *{{.Type}}Store) Add(x {{.Type}}) error {
+	return s.ms.Put({{.Item}}{value: x})
+}
+
+// Get the struct with the same primary key
+func (s *{{.Type}}Store) Get(x {{.Type}}) ({{.Type}}, bool) {
+	found := s.ms.Get({{.Item}}{value: x}, {{.Type}}Index{{.Primary.Ident}})
+	if found == nil {
+		return {{.Type}}{}, false
+	}
+	return found.({{.Item}}).value, true
+}
+{{range .Secondary}}
+{{- if .Unique}}
+// Get the struct with the same fields of index {{.Name}}
+func (s *{{$.Type}}Store) GetBy{{.Ident}}(x {{$.Type}}) ({{$.Type}}, bool) {
+	found := s.ms.Get({{$.Item}}{value: x}, {{$.Type}}Index{{.Ident}})
+	if found == nil {
+		return {{$.Type}}{}, false
+	}
+	return found.({{$.Item}}).value, true
+}
+{{else}}
+// Get the first struct by primary key with the same fields of index {{.Name}}
+func (s *{{$.Type}}Store) GetBy{{.Ident}}(x {{$.Type}}) (res {{$.Type}}, ok bool) {
+	r := memstore.NewRange(memstore.Inclusive({{$.Item}}{value: x, edge: -1}), memstore.Unbounded())
+	s.ms.GetRangeBounds(r, {{$.Type}}Index{{.Ident}}, func(found memstore.Item) bool {
+		res = found.({{$.Item}}).value
+		ok = compare{{$.Type}}{{.Ident}}(&x, &res) == 0
+		return false
+	})
+	if !ok {
+		return {{$.Type}}{}, false
+	}
+	return res, true
+}
+{{end}}{{end}}
+{{- range .Indexes}}
+// Iterate over structs from the fields of from (inclusive) to those of to (exclusive) for index {{.Name}}, until test returns false
+func (s *{{$.Type}}Store) RangeBy{{.Ident}}(from, to {{$.Type}}, test func({{$.Type}}) bool) {
+	r := memstore.NewRange(memstore.Inclusive({{$.Item}}{value: from, edge: -1}), memstore.Exclusive({{$.Item}}{value: to, edge: -1}))
+	s.ms.GetRangeBounds(r, {{$.Type}}Index{{.Ident}}, func(x memstore.Item) bool {
+		return test(x.({{$.Item}}).value)
+	})
+}
+
+// Iterate over every struct in order of index {{.Name}}, until test returns false
+func (s *{{$.Type}}Store) AscendBy{{.Ident}}(test func({{$.Type}}) bool) {
+	s.ms.GetRangeBounds(memstore.All(), {{$.Type}}Index{{.Ident}}, func(x memstore.Item) bool {
+		return test(x.({{$.Item}}).value)
+	})
+}
+{{end}}
+// Delete the struct with the same primary key
+func (s *{{.Type}}Store) Delete(x {{.Type}}) ({{.Type}}, bool) {
+	deleted := s.ms.Delete({{.Item}}{value: x}, {{.Type}}Index{{.Primary.Ident}})
+	if deleted == nil {
+		return {{.Type}}{}, false
+	}
+	return deleted.({{.Item}}).value, true
+}
+
+/*
+	Replace the struct with the same primary key with the result of modify, moving it within indexes
+
+	Fails with ErrNotFound, ErrNotModified if modify rejects the struct, ErrDuplicate, or ErrReadOnly on followers
+*/
+func (s *{{.Type}}Store) Update(x {{.Type}}, modify func({{.Type}}) ({{.Type}}, bool)) ({{.Type}}, error) {
+	// Modify runs on the struct stored under the write lock
+	var modified {{.Type}}
+	if _, err := s.ms.Update({{.Item}}{value: x}, {{.Type}}Index{{.Primary.Ident}}, func(current memstore.Item) (memstore.Item, bool) {
+		var ok bool
+		modified, ok = modify(current.({{.Item}}).value)
+		return {{.Item}}{value: modified}, ok
+	}); err != nil {
+		return {{.Type}}{}, err
+	}
+	return modified, nil
+}
+`))
+
+var testsTemplate = template.Must(template.New("tests").Funcs(funcs).Parse(`// Code generated by {{.Command}}; DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"github.com/mngharbi/memstore"
+	"reflect"
+	"testing"
+	{{if .UsesTime}}"time"{{end}}
+)
+
+// {{.Type}}s differing on every indexed field, in increasing order
+func {{.Unexported}}Samples() []{{.Type}} {
+	return []{{.Type}}{
+		{ {{- range $i, $f := .Sampled}}{{if $i}}, {{end}}{{$f.Name}}: {{$f.Sample 0}}{{end -}} },
+		{ {{- range $i, $f := .Sampled}}{{if $i}}, {{end}}{{$f.Name}}: {{$f.Sample 1}}{{end -}} },
+	}
+}
+
+func Test{{.Type}}StoreOrder(t *testing.T) {
+	reference, err := memstore.NewFromStruct[{{.Type}}]()
+	if err != nil {
+		t.Fatalf("Creating store from tags failed, err=%v", err)
+	}
+	s := New{{.Type}}Store()
+	samples := {{.Unexported}}Samples()
+	for i := len(samples) - 1; i >= 0; i-- {
+		if err := reference.Add(samples[i]); err != nil {
+			t.Fatalf("Adding to store from tags failed, err=%v", err)
+		}
+		if err := s.Add(samples[i]); err != nil {
+			t.Fatalf("Adding failed, err=%v", err)
+		}
+	}
+
+	// Generated comparators order structs like reflection does
+	for _, index := range reference.Indexes() {
+		var expected, got []{{.Type}}
+		reference.Ascend(index.Name, func(x {{.Type}}) bool {
+			expected = append(expected, x)
+			return true
+		})
+		s.Store().GetRangeBounds(memstore.All(), index.Name, func(x memstore.Item) bool {
+			got = append(got, x.({{.Item}}).value)
+			return true
+		})
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("Index %v is in the wrong order, expected=%v got=%v", index.Name, expected, got)
+		}
+	}
+{{range .Indexes}}{{if .Sampled}}
+	var {{lower .Ident}}Range []{{$.Type}}
+	s.RangeBy{{.Ident}}(samples[0], samples[1], func(x {{$.Type}}) bool {
+		{{lower .Ident}}Range = append({{lower .Ident}}Range, x)
+		return true
+	})
+	if !reflect.DeepEqual({{lower .Ident}}Range, samples[:1]) {
+		t.Errorf("Range of index {{.Name}} is wrong, got=%v", {{lower .Ident}}Range)
+	}
+{{end}}{{end -}}
+}
+
+func Test{{.Type}}StoreWrites(t *testing.T) {
+	s := New{{.Type}}Store()
+	samples := {{.Unexported}}Samples()
+	for _, x := range samples {
+		if err := s.Add(x); err != nil {
+			t.Fatalf("Adding failed, err=%v", err)
+		}
+	}
+	if err := s.Add(samples[0]); err != nil || s.Len() != len(samples) {
+		t.Errorf("Adding again should replace, err=%v len=%v", err, s.Len())
+	}
+
+	for _, x := range samples {
+		if found, ok := s.Get(x); !ok || !reflect.DeepEqual(found, x) {
+			t.Errorf("%v not found, found=%v", x, found)
+		}
+{{- range .Secondary}}
+		if found, ok := s.GetBy{{.Ident}}(x); !ok || compare{{$.Type}}{{.Ident}}(&found, &x) != 0 {
+			t.Errorf("%v not found by index {{.Name}}, found=%v", x, found)
+		}
+{{- end}}
+	}
+
+	if _, err := s.Update(samples[0], func(x {{.Type}}) ({{.Type}}, bool) {
+		return x, false
+	}); err != memstore.ErrNotModified {
+		t.Errorf("Update rejected by modify should fail, err=%v", err)
+	}
+	if _, err := s.Update(samples[0], func(x {{.Type}}) ({{.Type}}, bool) {
+		return samples[1], true
+	}); err != memstore.ErrDuplicate {
+		t.Errorf("Update to the fields of another struct should fail, err=%v", err)
+	}
+
+	for _, x := range samples {
+		if deleted, ok := s.Delete(x); !ok || !reflect.DeepEqual(deleted, x) {
+			t.Errorf("%v not deleted, deleted=%v", x, deleted)
+		}
+	}
+	if _, ok := s.Get(samples[0]); ok || s.Len() != 0 {
+		t.Errorf("Store should be empty, len=%v", s.Len())
+	}
+}
+`))
@@ -0,0 +1,71 @@
+/*
+	Generate a typed store for a struct indexed by its memstore tags (see memstore.FieldIndex)
+
+	Generated stores order structs with comparators written for their fields instead of the reflection
+	memstore.NewFromStruct relies on, and have typed Get, GetBy<Index>, RangeBy<Index> and AscendBy<Index> methods.
+	Tests checking them against memstore.NewFromStruct are generated next to them.
+
+	Meant to be run by go generate from the package of the struct:
+
+		//go:generate go run github.com/mngharbi/memstore/cmd/memstore-gen -type Event
+
+	which writes event_memstore.go and event_memstore_test.go.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	typeName := flag.String("type", "", "name of the struct type (required)")
+	dir := flag.String("dir", ".", "directory of the package of the struct")
+	output := flag.String("output", "", "file to write the store to, <type>_memstore.go if empty")
+	tests := flag.Bool("tests", true, "also write tests of the store to <output>_test.go")
+	flag.Parse()
+
+	if *typeName == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *output == "" {
+		*output = filepath.Join(*dir, strings.ToLower(*typeName)+"_memstore.go")
+	}
+
+	if err := run(*typeName, *dir, *output, *tests); err != nil {
+		log.Fatalf("memstore-gen: %v", err)
+	}
+}
+
+func run(typeName, dir, output string, tests bool) error {
+	pkg, err := parsePackage(dir, filepath.Base(output))
+	if err != nil {
+		return err
+	}
+	m, err := pkg.model(typeName)
+	if err != nil {
+		return err
+	}
+	store, testSource, err := m.generate("memstore-gen -type " + typeName)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(output, store, 0644); err != nil {
+		return err
+	}
+	if !tests {
+		return nil
+	}
+	if !m.Testable() {
+		fmt.Fprintf(os.Stderr, "memstore-gen: no tests for %v, samples can't differ on unique indexes of items only\n", typeName)
+		return nil
+	}
+	return os.WriteFile(strings.TrimSuffix(output, ".go")+"_test.go", testSource, 0644)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"github.com/mngharbi/memstore"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The example package holds the output of the generator, which must stay up to date
+func TestGenerateExample(t *testing.T) {
+	pkg, err := parsePackage("example", "event_memstore.go")
+	if err != nil {
+		t.Fatalf("Parsing failed, err=%v", err)
+	}
+	m, err := pkg.model("Event")
+	if err != nil {
+		t.Fatalf("Reading tags failed, err=%v", err)
+	}
+	store, tests, err := m.generate("memstore-gen -type Event")
+	if err != nil {
+		t.Fatalf("Generating failed, err=%v", err)
+	}
+
+	for file, generated := range map[string][]byte{"event_memstore.go": store, "event_memstore_test.go": tests} {
+		expected, err := os.ReadFile(filepath.Join("example", file))
+		if err != nil {
+			t.Fatalf("Reading %v failed, err=%v", file, err)
+		}
+		if !bytes.Equal(generated, expected) {
+			t.Errorf("%v is out of date, run go generate in the example package", file)
+		}
+	}
+}
+
+// Write a file of package p and read a model of type T from it
+func testModel(t *testing.T, source string) (*model, error) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "t.go"), []byte("package p\n"+source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := parsePackage(dir)
+	if err != nil {
+		return nil, err
+	}
+	return pkg.model("T")
+}
+
+func TestModel(t *testing.T) {
+	m, err := testModel(t, `
+import "time"
+
+type Name string
+type Names Name
+type Blob []byte
+
+type Item struct{}
+
+func (Item) Less(index string, than interface{}) bool { return false }
+
+type T struct {
+	A    Names     `+"`memstore:\"index=by_name,part=1;index=ip\"`"+`
+	B    int       `+"`memstore:\"index=by_name,part=0\"`"+`
+	C    int       `+"`memstore:\"index=c\"`"+`
+	D    time.Time `+"`memstore:\"index=d,primary\"`"+`
+	E    Blob      `+"`memstore:\"index=e,unique\"`"+`
+	F    *Item     `+"`memstore:\"index=f\"`"+`
+	G    Item      `+"`memstore:\"index=f,part=1\"`"+`
+	h    int
+}`)
+	if err != nil {
+		t.Fatalf("Reading tags failed, err=%v", err)
+	}
+
+	idents := map[string]string{}
+	for _, ix := range m.Indexes {
+		idents[ix.Name] = ix.Ident
+	}
+	if m.Primary().Name != "d" || idents["by_name"] != "ByName" || idents["ip"] != "IP" || len(m.Indexes) != 6 {
+		t.Errorf("Indexes are wrong, indexes=%+v", m.Indexes)
+	}
+
+	kinds := map[string]fieldKind{}
+	for _, ix := range m.Indexes {
+		for _, f := range ix.Compare {
+			kinds[f.Name] = f.Kind
+		}
+	}
+	expected := map[string]fieldKind{"A": kindString, "B": kindNumber, "C": kindNumber, "D": kindTime, "E": kindBytes, "F": kindItem, "G": kindItem}
+	for name, kind := range expected {
+		if kinds[name] != kind {
+			t.Errorf("Field %v should be compared as %v, got=%v", name, kind, kinds[name])
+		}
+	}
+	if !m.Testable() {
+		t.Error("Structs should differ on every unique index")
+	}
+	if _, _, err := m.generate("test"); err != nil {
+		t.Errorf("Generating failed, err=%v", err)
+	}
+}
+
+func TestModelErrors(t *testing.T) {
+	for _, source := range []string{
+		"type U struct{}",
+		"type T int",
+		"type T struct{ a int `memstore:\"index=a\"` }",
+		"type T struct{ A map[int]int `memstore:\"index=a\"` }",
+		"type S struct{}\ntype T struct{ A S `memstore:\"index=a\"` }",
+		"type T struct{ A int `memstore:\"index=a,part\"` }",
+		"type T struct{ A int `memstore:\"index=a_b\"`; B int `memstore:\"index=a-b\"` }",
+		"type T struct{ A int }",
+	} {
+		if _, err := testModel(t, source); err == nil {
+			t.Errorf("Reading tags should fail, source=%q", source)
+		}
+	}
+
+	_, err := testModel(t, "type T struct{ A int `memstore:\"index=a;index=a\"` }")
+	if !errors.Is(err, memstore.ErrInvalidTag) {
+		t.Errorf("Invalid tags should fail with ErrInvalidTag, err=%v", err)
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mngharbi/memstore"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// How values of a field are compared
+type fieldKind int
+
+const (
+	kindString fieldKind = iota
+	kindNumber
+	kindBool
+	kindTime
+	kindBytes
+
+	// Named types implementing memstore.Item
+	kindItem
+)
+
+// Field of an index
+type field struct {
+	Name string
+	Kind fieldKind
+}
+
+/*
+	Index of the generated store
+
+	Ident names it in generated identifiers, Sampled tells whether generated tests can tell structs apart by it.
+*/
+type index struct {
+	memstore.StructIndex
+	Ident   string
+	Compare []field
+	Sampled bool
+}
+
+// Struct type and its indexes, the first one being the primary index
+type model struct {
+	Package string
+	Type    string
+	Indexes []index
+}
+
+// Declarations of a package read from source
+type sourcePackage struct {
+	name  string
+	types map[string]*ast.TypeSpec
+
+	// Types with a value receiver Less method, assumed to implement memstore.Item
+	items map[string]bool
+}
+
+// Parse Go files of a directory, skipping tests and those listed
+func parsePackage(dir string, skip ...string) (*sourcePackage, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+
+	pkg := &sourcePackage{types: map[string]*ast.TypeSpec{}, items: map[string]bool{}}
+	fset := token.NewFileSet()
+	for _, path := range paths {
+		base := filepath.Base(path)
+		if strings.HasSuffix(base, "_test.go") || contains(skip, base) {
+			continue
+		}
+		file, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		if pkg.name != "" && pkg.name != file.Name.Name {
+			return nil, fmt.Errorf("%v: found packages %v and %v", dir, pkg.name, file.Name.Name)
+		}
+		pkg.name = file.Name.Name
+		pkg.collect(file)
+	}
+
+	if pkg.name == "" {
+		return nil, fmt.Errorf("%v: no Go files", dir)
+	}
+	return pkg, nil
+}
+
+func (pkg *sourcePackage) collect(file *ast.File) {
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				if ts, ok := spec.(*ast.TypeSpec); ok {
+					pkg.types[ts.Name.Name] = ts
+				}
+			}
+		case *ast.FuncDecl:
+			if decl.Recv == nil || decl.Name.Name != "Less" || len(decl.Recv.List) != 1 {
+				continue
+			}
+			recv := decl.Recv.List[0].Type
+			if generic, ok := recv.(*ast.IndexExpr); ok {
+				recv = generic.X
+			}
+			if ident, ok := recv.(*ast.Ident); ok {
+				pkg.items[ident.Name] = true
+			}
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+	Read indexes of a struct type from its tags, like memstore.NewFromStruct does
+
+	Tagged fields must be exported, and of a type whose values generated code can compare:
+	strings, numbers, bools, time.Time, []byte or types implementing memstore.Item.
+*/
+func (pkg *sourcePackage) model(typeName string) (*model, error) {
+	ts, ok := pkg.types[typeName]
+	if !ok {
+		return nil, fmt.Errorf("type %v not found in package %v", typeName, pkg.name)
+	}
+	st, ok := ts.Type.(*ast.StructType)
+	if !ok || ts.TypeParams != nil {
+		return nil, fmt.Errorf("type %v isn't a struct without type parameters", typeName)
+	}
+
+	var tagged []memstore.TaggedField
+	fields := map[string]field{}
+	for _, f := range st.Fields.List {
+		if f.Tag == nil {
+			continue
+		}
+		raw, err := strconv.Unquote(f.Tag.Value)
+		if err != nil {
+			return nil, err
+		}
+		tag, ok := reflect.StructTag(raw).Lookup("memstore")
+		if !ok {
+			continue
+		}
+		if len(f.Names) == 0 {
+			return nil, fmt.Errorf("%w: embedded field of %v can't be indexed", memstore.ErrInvalidTag, typeName)
+		}
+
+		kind, err := pkg.kind(f.Type, map[string]bool{})
+		if err != nil {
+			return nil, fmt.Errorf("field %v.%v: %w", typeName, f.Names[0].Name, err)
+		}
+		for _, name := range f.Names {
+			if !name.IsExported() {
+				return nil, fmt.Errorf("%w: field %v isn't exported", memstore.ErrInvalidTag, name.Name)
+			}
+			tagged = append(tagged, memstore.TaggedField{Name: name.Name, Tag: tag})
+			fields[name.Name] = field{Name: name.Name, Kind: kind}
+		}
+	}
+
+	indexes, err := memstore.IndexesFromTags(tagged)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", typeName, err)
+	}
+
+	m := &model{Package: pkg.name, Type: typeName}
+	idents := map[string]string{}
+	for _, si := range indexes {
+		ix := index{StructIndex: si, Ident: identifier(si.Name)}
+		if other, ok := idents[ix.Ident]; ok {
+			return nil, fmt.Errorf("indexes %q and %q would both be named %v", other, si.Name, ix.Ident)
+		}
+		idents[ix.Ident] = si.Name
+
+		for _, name := range si.Fields {
+			f := fields[name]
+			ix.Compare = append(ix.Compare, f)
+			ix.Sampled = ix.Sampled || f.Kind != kindItem
+		}
+		m.Indexes = append(m.Indexes, ix)
+	}
+	return m, nil
+}
+
+var basicKinds = map[string]fieldKind{
+	"string":  kindString,
+	"bool":    kindBool,
+	"int":     kindNumber,
+	"int8":    kindNumber,
+	"int16":   kindNumber,
+	"int32":   kindNumber,
+	"int64":   kindNumber,
+	"uint":    kindNumber,
+	"uint8":   kindNumber,
+	"uint16":  kindNumber,
+	"uint32":  kindNumber,
+	"uint64":  kindNumber,
+	"uintptr": kindNumber,
+	"byte":    kindNumber,
+	"rune":    kindNumber,
+	"float32": kindNumber,
+	"float64": kindNumber,
+}
+
+var errUnsupported = errors.New("unsupported type")
+
+// How values of a type are compared, following named types of the package down to basic ones
+func (pkg *sourcePackage) kind(expr ast.Expr, seen map[string]bool) (fieldKind, error) {
+	switch expr := expr.(type) {
+	case *ast.Ident:
+		if pkg.items[expr.Name] {
+			return kindItem, nil
+		}
+		if kind, ok := basicKinds[expr.Name]; ok {
+			return kind, nil
+		}
+		ts, ok := pkg.types[expr.Name]
+		if !ok || seen[expr.Name] {
+			return 0, fmt.Errorf("%w %v", errUnsupported, expr.Name)
+		}
+		seen[expr.Name] = true
+		switch ts.Type.(type) {
+		case *ast.Ident, *ast.ArrayType:
+			return pkg.kind(ts.Type, seen)
+		}
+		return 0, fmt.Errorf("%w %v, which doesn't implement memstore.Item", errUnsupported, expr.Name)
+
+	case *ast.SelectorExpr:
+		if pkgName, ok := expr.X.(*ast.Ident); ok && pkgName.Name == "time" && expr.Sel.Name == "Time" {
+			return kindTime, nil
+		}
+		// Types of other packages can't be checked, they have to implement memstore.Item
+		return kindItem, nil
+
+	case *ast.StarExpr:
+		return kindItem, nil
+
+	case *ast.ArrayType:
+		if elt, ok := expr.Elt.(*ast.Ident); ok && expr.Len == nil && (elt.Name == "byte" || elt.Name == "uint8") {
+			return kindBytes, nil
+		}
+	}
+	return 0, errUnsupported
+}
+
+// Go identifier for an index name, e.g. tenant_ts gives TenantTs and user_id gives UserID
+func identifier(name string) string {
+	parts := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	var b strings.Builder
+	for _, part := range parts {
+		if initialisms[strings.ToLower(part)] {
+			b.WriteString(strings.ToUpper(part))
+			continue
+		}
+		runes := []rune(part)
+		b.WriteRune(unicode.ToUpper(runes[0]))
+		b.WriteString(string(runes[1:]))
+	}
+	if b.Len() == 0 {
+		return "Unnamed"
+	}
+	return b.String()
+}
+
+var initialisms = map[string]bool{"id": true, "ip": true, "url": true, "uri": true, "uuid": true, "api": true, "http": true, "json": true}
@@ -0,0 +1,76 @@
+/*
+	Server hosting named memstores over HTTP+JSON (see package server)
+
+	Stores are created from a JSON file mapping store names to schemas, e.g.
+
+		{"users": {"indexes": [{"name": "id", "fields": ["id"]}, {"name": "email", "fields": ["email"], "unique": true}]}}
+
+	and more can be created through the API. Stats of every store are served on /stats, with operation and lock
+	metrics given -metrics.
+
+	With -resp, Redis clients can use sorted sets and strings of a separate keyspace (see package resp)
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"github.com/mngharbi/memstore"
+	"github.com/mngharbi/memstore/resp"
+	"github.com/mngharbi/memstore/server"
+	"log"
+	"net/http"
+	"os"
+)
+
+func main() {
+	addr := flag.String("addr", ":7070", "address to listen on")
+	schemas := flag.String("schemas", "", "JSON file with schemas of stores to create")
+	metrics := flag.Bool("metrics", false, "instrument stores, their metrics being served on /stats (see memstore.WithMetrics)")
+	respAddr := flag.String("resp", "", "address to serve the Redis protocol on (e.g. localhost:6379), disabled if empty")
+	flag.Parse()
+
+	var options []memstore.Option
+	if *metrics {
+		options = append(options, memstore.WithMetrics())
+	}
+	srv := server.New(options...)
+
+	if *schemas != "" {
+		if err := createStores(srv, *schemas); err != nil {
+			log.Fatalf("memstored: %v", err)
+		}
+	}
+
+	if *respAddr != "" {
+		go func() {
+			log.Printf("memstored: serving Redis protocol on %v", *respAddr)
+			log.Fatal(resp.New(options...).ListenAndServe(*respAddr))
+		}()
+	}
+
+	log.Printf("memstored: listening on %v", *addr)
+	log.Fatal(http.ListenAndServe(*addr, srv))
+}
+
+func createStores(srv *server.Server, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stores := map[string]server.Schema{}
+	if err := json.NewDecoder(file).Decode(&stores); err != nil {
+		return err
+	}
+
+	for name, schema := range stores {
+		if err := srv.CreateStore(name, schema); err != nil {
+			return err
+		}
+		log.Printf("memstored: created store %q", name)
+	}
+	return nil
+}
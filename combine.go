@@ -0,0 +1,134 @@
+package memstore
+
+import (
+	"sort"
+)
+
+/*
+	Range over a specific index
+
+	Used to combine results of ranges over multiple indexes
+*/
+type IndexRange struct {
+	Index string
+	Range Range
+}
+
+func On(index string, r Range) IndexRange {
+	return IndexRange{Index: index, Range: r}
+}
+
+// Items within every range, ordered by index order (nil if an index is unknown or key bounds don't compare with items)
+func (ms *Memstore) Intersect(order string, ranges ...IndexRange) []Item {
+	op := ms.begin(opIntersect, order)
+	defer ms.end(op)
+
+	return ms.combine(op, order, ranges, intersectIdentities)
+}
+
+// Items within any of the ranges, ordered by index order (nil like Intersect)
+func (ms *Memstore) Union(order string, ranges ...IndexRange) []Item {
+	op := ms.begin(opUnion, order)
+	defer ms.end(op)
+
+	return ms.combine(op, order, ranges, unionIdentities)
+}
+
+// Evaluate every range, merge them by identity and sort the result
+func (ms *Memstore) combine(op *operation, order string, ranges []IndexRange, merge func(a, b []*internalItem) []*internalItem) []Item {
+	// Check every index used
+	if ms.lookupTree(order) == nil || len(ranges) == 0 {
+		return nil
+	}
+	for _, r := range ranges {
+		if ms.lookupTree(r.Index) == nil {
+			return nil
+		}
+	}
+
+	ms.rlock(op)
+
+	for _, r := range ranges {
+		if r.Range.check(ms.indexTree[r.Index], r.Index) != nil {
+			ms.runlock(op)
+			return nil
+		}
+	}
+
+	// Get items of every range sorted by identity
+	sets := make([][]*internalItem, len(ranges))
+	for i, r := range ranges {
+		ascendRange(ms.indexTree[r.Index], r.Index, r.Range, func(ii *internalItem) bool {
+			sets[i] = append(sets[i], ii)
+			return true
+		})
+		sortByIdentity(sets[i])
+	}
+
+	// Merge smallest sets first to keep intermediate results small
+	sort.Slice(sets, func(i, j int) bool {
+		return len(sets[i]) < len(sets[j])
+	})
+	merged := sets[0]
+	for _, set := range sets[1:] {
+		merged = merge(merged, set)
+	}
+
+	res := make([]Item, len(merged))
+	for i, ii := range merged {
+		res[i] = ii.value
+	}
+
+	ms.runlock(op)
+
+	op.addItems(len(res))
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Less(order, res[j])
+	})
+
+	return res
+}
+
+func sortByIdentity(items []*internalItem) {
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].id < items[j].id
+	})
+}
+
+// Sorted-merge of two sets sorted by identity, keeping items in both
+func intersectIdentities(a, b []*internalItem) (res []*internalItem) {
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i].id < b[j].id:
+			i++
+		case a[i].id > b[j].id:
+			j++
+		default:
+			res = append(res, a[i])
+			i++
+			j++
+		}
+	}
+	return res
+}
+
+// Sorted-merge of two sets sorted by identity, keeping items in either
+func unionIdentities(a, b []*internalItem) (res []*internalItem) {
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case j == len(b) || (i < len(a) && a[i].id < b[j].id):
+			res = append(res, a[i])
+			i++
+		case i == len(a) || a[i].id > b[j].id:
+			res = append(res, b[j])
+			j++
+		default:
+			res = append(res, a[i])
+			i++
+			j++
+		}
+	}
+	return res
+}
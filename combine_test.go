@@ -0,0 +1,76 @@
+package memstore
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIntersectInvalidIndex(t *testing.T) {
+	ms := queryTestStore()
+
+	if res := ms.Intersect("notID", On("id", All())); res != nil {
+		t.Error("Intersecting ordered by unspecified index didn't fail")
+	}
+
+	if res := ms.Intersect("id", On("notID", All())); res != nil {
+		t.Error("Intersecting range on unspecified index didn't fail")
+	}
+}
+
+func TestIntersect(t *testing.T) {
+	ms := queryTestStore()
+
+	res := ms.Intersect("importance",
+		On("id", NewRange(Inclusive(TestStruct{id: 2}), Inclusive(TestStruct{id: 8}))),
+		On("importance", NewRange(InclusiveKey(3), InclusiveKey(5))),
+	)
+
+	expected := []TestStruct{{8, 3.2, "u"}, {3, 5, "z"}}
+	if !reflect.DeepEqual(toTestStructs(res), expected) {
+		t.Errorf("Intersecting ranges failed, result=%v expected=%v", res, expected)
+	}
+}
+
+func TestIntersectEmpty(t *testing.T) {
+	ms := queryTestStore()
+
+	res := ms.Intersect("id",
+		On("id", NewRange(Unbounded(), Exclusive(TestStruct{id: 3}))),
+		On("id", NewRange(Inclusive(TestStruct{id: 3}), Unbounded())),
+	)
+
+	if len(res) != 0 {
+		t.Errorf("Intersecting disjoint ranges should be empty, result=%v", res)
+	}
+}
+
+func TestUnion(t *testing.T) {
+	ms := queryTestStore()
+
+	res := ms.Union("id",
+		On("id", NewRange(Unbounded(), Exclusive(TestStruct{id: 3}))),
+		On("name", NewRange(Inclusive(TestStruct{name: "y"}), Unbounded())),
+	)
+
+	expected := idSortedData()[:3]
+	if !reflect.DeepEqual(toTestStructs(res), expected) {
+		t.Errorf("Union of ranges failed, result=%v expected=%v", res, expected)
+	}
+}
+
+func TestUnionAfterIndexUpdate(t *testing.T) {
+	ms := queryTestStore()
+
+	// Identity is kept when indexed fields change
+	ms.UpdateWithIndexes(TestStruct{id: 9}, "id", dataModifierFuncWithIndex)
+
+	res := ms.Union("id",
+		On("id", NewRange(Unbounded(), Exclusive(TestStruct{id: 0}))),
+		On("name", NewRange(Inclusive(TestStruct{name: "v"}), Inclusive(TestStruct{name: "v"}))),
+	)
+
+	expected := []TestStruct{{-1, 3.1, "v"}}
+	if !reflect.DeepEqual(toTestStructs(res), expected) {
+		t.Errorf("Union after index update failed, result=%v expected=%v", res, expected)
+	}
+}
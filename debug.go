@@ -0,0 +1,230 @@
+package memstore
+
+import (
+	"fmt"
+	"github.com/mngharbi/GoLLRB/llrb"
+	"log"
+	"sync"
+)
+
+/*
+	Rule of the strict weak ordering contract that Less must follow
+*/
+type ContractRule string
+
+const (
+	Irreflexivity ContractRule = "irreflexivity"
+	Asymmetry     ContractRule = "asymmetry"
+	Transitivity  ContractRule = "transitivity"
+	UnknownIndex  ContractRule = "unknown index"
+)
+
+/*
+	Violation of the comparator contract found in debug mode
+
+	Items are the offending items, in the order they were compared
+*/
+type ComparatorViolation struct {
+	Index string
+	Rule  ContractRule
+	Items []Item
+}
+
+func (v ComparatorViolation) Error() string {
+	if len(v.Items) == 0 {
+		return fmt.Sprintf("memstore: %v %q", v.Rule, v.Index)
+	}
+	return fmt.Sprintf("memstore: comparator for index %q violates %v with %+v", v.Index, v.Rule, v.Items)
+}
+
+/*
+	Configuration of debug mode
+*/
+type DebugOptions struct {
+	// Check one write out of SampleEvery (every write if zero)
+	SampleEvery int
+
+	// Number of recently written items compared with every checked item (8 if zero)
+	Recent int
+
+	// Called with every violation found, panics with the violation if nil
+	OnViolation func(ComparatorViolation)
+}
+
+// Handler logging violations instead of panicking
+func LogViolations(logger *log.Logger) func(ComparatorViolation) {
+	return func(v ComparatorViolation) {
+		logger.Println(v.Error())
+	}
+}
+
+/*
+	Debug mode: sample written items and check that comparators are strict weak orderings
+	against recently written items and their neighbors in every tree
+
+	Index names unknown to the store are reported as well, instead of silently ignored
+*/
+func WithDebug(options DebugOptions) Option {
+	if options.SampleEvery <= 0 {
+		options.SampleEvery = 1
+	}
+	if options.Recent <= 0 {
+		options.Recent = 8
+	}
+	return func(ms *Memstore) {
+		ms.debug = &debugState{options: options}
+	}
+}
+
+// Name never used as an index, used to check that comparators reject unknown indexes
+const unknownIndexProbe = "\x00memstore-unknown-index"
+
+type debugState struct {
+	options DebugOptions
+
+	// Writes seen so far, and ring of recently checked items
+	m      sync.Mutex
+	writes int
+	recent []Item
+	next   int
+}
+
+func (d *debugState) report(v ComparatorViolation) {
+	if d.options.OnViolation == nil {
+		panic(v)
+	}
+	d.options.OnViolation(v)
+}
+
+// Decide whether to check a written item, returns items to compare it with
+func (d *debugState) sample(x Item) ([]Item, bool) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	d.writes++
+	if d.writes%d.options.SampleEvery != 0 {
+		return nil, false
+	}
+
+	samples := make([]Item, len(d.recent))
+	copy(samples, d.recent)
+
+	if len(d.recent) < d.options.Recent {
+		d.recent = append(d.recent, x)
+	} else {
+		d.recent[d.next] = x
+		d.next = (d.next + 1) % len(d.recent)
+	}
+
+	return samples, true
+}
+
+// Get tree of an index, reporting unknown indexes in debug mode
+func (ms *Memstore) lookupTree(index string) OrderedIndex {
+	tree := ms.indexTree[index]
+	if tree == nil && ms.debug != nil {
+		ms.debug.report(ComparatorViolation{Index: index, Rule: UnknownIndex})
+	}
+	return tree
+}
+
+// Check comparator contract on an item just written by op (called without holding the lock)
+func (ms *Memstore) debugCheck(op *operation, x Item) {
+	if ms.debug == nil || x == nil {
+		return
+	}
+
+	recent, ok := ms.debug.sample(x)
+	if !ok {
+		return
+	}
+
+	var violations []ComparatorViolation
+
+	// Comparing with an unknown index should never succeed
+	if acceptsUnknownIndex(x) {
+		violations = append(violations, ComparatorViolation{Index: unknownIndexProbe, Rule: UnknownIndex, Items: []Item{x}})
+	}
+
+	for _, index := range ms.indexes {
+		samples := append(ms.neighbors(op, x, index), recent...)
+		if v, found := checkContract(index, x, samples); found {
+			violations = append(violations, v)
+		}
+	}
+
+	for _, v := range violations {
+		ms.debug.report(v)
+	}
+}
+
+// A comparator that panics on an unknown index rejects it
+func acceptsUnknownIndex(x Item) (accepted bool) {
+	defer func() {
+		if recover() != nil {
+			accepted = false
+		}
+	}()
+	return x.Less(unknownIndexProbe, x)
+}
+
+// Items right before and after an item in the tree of an index
+func (ms *Memstore) neighbors(op *operation, x Item, index string) (res []Item) {
+	ix := makeInternalItem(x)
+	tree := ms.indexTree[index]
+
+	ms.rlock(op)
+
+	count := 0
+	tree.AscendGreaterOrEqual(ix, func(it llrb.Item) bool {
+		res = append(res, it.(*internalItem).value)
+		count++
+		return count < 2
+	})
+	count = 0
+	tree.DescendLessOrEqual(ix, func(it llrb.Item) bool {
+		res = append(res, it.(*internalItem).value)
+		count++
+		return count < 2
+	})
+
+	ms.runlock(op)
+
+	return res
+}
+
+// Check strict weak ordering rules between an item and samples, returns first violation found
+func checkContract(index string, x Item, samples []Item) (ComparatorViolation, bool) {
+	violation := func(rule ContractRule, items ...Item) (ComparatorViolation, bool) {
+		return ComparatorViolation{Index: index, Rule: rule, Items: items}, true
+	}
+
+	if x.Less(index, x) {
+		return violation(Irreflexivity, x)
+	}
+
+	for i, y := range samples {
+		if x.Less(index, y) && y.Less(index, x) {
+			return violation(Asymmetry, x, y)
+		}
+
+		for _, z := range samples[i+1:] {
+			// Check every ordering of the triple
+			for _, triple := range [][3]Item{{x, y, z}, {x, z, y}, {y, x, z}, {y, z, x}, {z, x, y}, {z, y, x}} {
+				a, b, c := triple[0], triple[1], triple[2]
+				if a.Less(index, b) && b.Less(index, c) && !a.Less(index, c) {
+					return violation(Transitivity, a, b, c)
+				}
+				if equivalent(index, a, b) && equivalent(index, b, c) && !equivalent(index, a, c) {
+					return violation(Transitivity, a, b, c)
+				}
+			}
+		}
+	}
+
+	return ComparatorViolation{}, false
+}
+
+func equivalent(index string, a, b Item) bool {
+	return !a.Less(index, b) && !b.Less(index, a)
+}
@@ -0,0 +1,167 @@
+package memstore
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+// Comparator following the contract, rejecting unknown indexes
+type orderedStruct struct {
+	value int
+}
+
+func (os orderedStruct) Less(index string, than interface{}) bool {
+	switch index {
+	case "value":
+		return os.value < than.(orderedStruct).value
+	default:
+		panic("unknown index " + index)
+	}
+}
+
+// Comparator going in circles (0 < 1 < 2 < 0)
+type circularStruct struct {
+	value int
+}
+
+func (cs circularStruct) Less(index string, than interface{}) bool {
+	if index != "value" {
+		panic("unknown index " + index)
+	}
+	return (than.(circularStruct).value-cs.value+3)%3 == 1
+}
+
+// Comparator using less or equal
+type nonStrictStruct struct {
+	value int
+}
+
+func (ns nonStrictStruct) Less(index string, than interface{}) bool {
+	if index != "value" {
+		panic("unknown index " + index)
+	}
+	return ns.value <= than.(nonStrictStruct).value
+}
+
+func debugStore(indexes []string) (*Memstore, *[]ComparatorViolation) {
+	violations := &[]ComparatorViolation{}
+	ms := New(indexes, WithDebug(DebugOptions{
+		OnViolation: func(v ComparatorViolation) {
+			*violations = append(*violations, v)
+		},
+	}))
+	return ms, violations
+}
+
+func hasViolation(violations []ComparatorViolation, rule ContractRule) bool {
+	for _, v := range violations {
+		if v.Rule == rule {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDebugValidComparator(t *testing.T) {
+	ms, violations := debugStore([]string{"value"})
+
+	for n := 0; n < 50; n++ {
+		ms.Add(orderedStruct{value: n * 7 % 20})
+	}
+	ms.UpdateWithIndexes(orderedStruct{value: 3}, "value", func(x Item) (Item, bool) {
+		return orderedStruct{value: 100}, true
+	})
+
+	if len(*violations) != 0 {
+		t.Errorf("Valid comparator shouldn't report violations, violations=%v", *violations)
+	}
+}
+
+func TestDebugTransitivity(t *testing.T) {
+	ms, violations := debugStore([]string{"value"})
+
+	for n := 0; n < 3; n++ {
+		ms.Add(circularStruct{value: n})
+	}
+
+	if !hasViolation(*violations, Transitivity) {
+		t.Errorf("Circular comparator should violate transitivity, violations=%v", *violations)
+	}
+}
+
+func TestDebugIrreflexivity(t *testing.T) {
+	ms, violations := debugStore([]string{"value"})
+
+	ms.Add(nonStrictStruct{value: 1})
+
+	if !hasViolation(*violations, Irreflexivity) {
+		t.Errorf("Non strict comparator should violate irreflexivity, violations=%v", *violations)
+	}
+}
+
+func TestDebugUnknownIndex(t *testing.T) {
+	ms, violations := debugStore([]string{"id"})
+
+	// Test comparator accepts any index name
+	var vItem Item = TestStruct{id: 1}
+	ms.Add(vItem)
+	if !hasViolation(*violations, UnknownIndex) {
+		t.Error("Comparator accepting unknown index should be reported")
+	}
+
+	*violations = nil
+	ms.Get(vItem, "notID")
+	if len(*violations) != 1 || (*violations)[0].Rule != UnknownIndex || (*violations)[0].Index != "notID" {
+		t.Errorf("Using unknown index should be reported, violations=%v", *violations)
+	}
+}
+
+func TestDebugSampling(t *testing.T) {
+	violations := 0
+	ms := New([]string{"value"}, WithDebug(DebugOptions{
+		SampleEvery: 2,
+		OnViolation: func(v ComparatorViolation) {
+			violations++
+		},
+	}))
+
+	for n := 0; n < 10; n++ {
+		ms.Add(nonStrictStruct{value: n})
+	}
+
+	if violations != 5 {
+		t.Errorf("Every other write should be checked, violations=%v", violations)
+	}
+}
+
+func TestDebugPanicsByDefault(t *testing.T) {
+	ms := New([]string{"value"}, WithDebug(DebugOptions{}))
+
+	defer func() {
+		if _, ok := recover().(ComparatorViolation); !ok {
+			t.Error("Violation should panic without handler")
+		}
+
+		// Store must still be usable after panic
+		if ms.Len() != 1 {
+			t.Error("Store should be unlocked after violation")
+		}
+	}()
+
+	ms.Add(nonStrictStruct{value: 1})
+}
+
+func TestDebugLogViolations(t *testing.T) {
+	var buffer bytes.Buffer
+	ms := New([]string{"value"}, WithDebug(DebugOptions{
+		OnViolation: LogViolations(log.New(&buffer, "", 0)),
+	}))
+
+	ms.Add(nonStrictStruct{value: 1})
+
+	if !strings.Contains(buffer.String(), string(Irreflexivity)) {
+		t.Errorf("Violation should be logged, log=%v", buffer.String())
+	}
+}
@@ -0,0 +1,312 @@
+package memstore
+
+import (
+	"reflect"
+	"sort"
+)
+
+/*
+	Kind of difference between two versions of a store
+*/
+type DiffKind int
+
+const (
+	// Item only in the second version
+	DiffAdded DiffKind = iota
+
+	// Item only in the first version
+	DiffRemoved
+
+	// Item with the same key in both versions, but a different value
+	DiffChanged
+)
+
+func (kind DiffKind) String() string {
+	switch kind {
+	case DiffAdded:
+		return "added"
+	case DiffRemoved:
+		return "removed"
+	case DiffChanged:
+		return "changed"
+	default:
+		return "unknown"
+	}
+}
+
+/*
+	Item added, removed or changed, Before being nil if added and After being nil if removed
+*/
+type Difference struct {
+	Kind   DiffKind
+	Before Item
+	After  Item
+}
+
+/*
+	Store or snapshot compared by Diff
+*/
+type Diffable interface {
+	// Items ordered by an index, and the lineage of their identities and versions
+	diffItems(index string) ([]SnapshotItem, uint64, error)
+
+	// View sharing structure with others, if ordered by index
+	viewOf(index string) (View, bool)
+}
+
+func (ms *Memstore) diffItems(index string) ([]SnapshotItem, uint64, error) {
+	op := ms.begin(opDiff, index)
+	defer ms.end(op)
+
+	tree := ms.lookupTree(index)
+	if tree == nil {
+		return nil, 0, ErrUnknownIndex
+	}
+
+	ms.rlock(op)
+	defer ms.runlock(op)
+
+	items := make([]SnapshotItem, 0, tree.Len())
+	ascendRange(tree, index, All(), func(ii *internalItem) bool {
+		items = append(items, SnapshotItem{ID: ii.id, Version: ii.version, Item: ii.value})
+		return true
+	})
+	op.addItems(len(items))
+
+	return items, ms.lineage, nil
+}
+
+func (ms *Memstore) viewOf(index string) (View, bool) {
+	if ms.shared == nil || index != ms.indexes[0] {
+		return View{}, false
+	}
+
+	op := ms.begin(opDiff, index)
+	defer ms.end(op)
+
+	ms.rlock(op)
+	defer ms.runlock(op)
+
+	return ms.view(), true
+}
+
+func (s Snapshot) diffItems(index string) ([]SnapshotItem, uint64, error) {
+	if index == s.Index {
+		return s.Items, s.Lineage, nil
+	}
+
+	// Snapshots are in order of the primary index only
+	items := append([]SnapshotItem(nil), s.Items...)
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Item.Less(index, items[j].Item)
+	})
+	return items, s.Lineage, nil
+}
+
+func (s Snapshot) viewOf(index string) (View, bool) {
+	return View{}, false
+}
+
+func (v View) diffItems(index string) ([]SnapshotItem, uint64, error) {
+	snapshot := Snapshot{Index: v.index, Lineage: v.lineage, Items: make([]SnapshotItem, 0, v.Len())}
+	v.root.walk(func(item SnapshotItem) bool {
+		snapshot.Items = append(snapshot.Items, item)
+		return true
+	})
+	return snapshot.diffItems(index)
+}
+
+func (v View) viewOf(index string) (View, bool) {
+	return v, index == v.index
+}
+
+/*
+	Walk differences between two stores or snapshots in order of an index, until yield returns false
+
+	Items are matched by their key in the index, which should be unique (typically the primary index).
+	Items of the same store, its snapshots or followers that kept their identity and version since
+	are known to be identical without being compared, others are compared with reflect.DeepEqual.
+
+	Views and stores keeping them (see WithViews) are compared in their primary index without copying anything,
+	skipping subtrees both sides share: diffing two views of a store walks O(d log n) nodes for d differences.
+	Otherwise every item of both sides is walked: a store is copied under a read lock, and a snapshot or view
+	is copied and sorted again for indexes other than its primary one.
+*/
+func Diff(a, b Diffable, index string, yield func(Difference) bool) error {
+	if before, ok := a.viewOf(index); ok {
+		if after, ok := b.viewOf(index); ok {
+			diffViews(before, after, index, yield)
+			return nil
+		}
+	}
+
+	before, lineageBefore, err := a.diffItems(index)
+	if err != nil {
+		return err
+	}
+	after, lineageAfter, err := b.diffItems(index)
+	if err != nil {
+		return err
+	}
+	sameLineage := lineageBefore == lineageAfter
+
+	i, j := 0, 0
+	for i < len(before) || j < len(after) {
+		var diff Difference
+		switch {
+		case j == len(after) || (i < len(before) && before[i].Item.Less(index, after[j].Item)):
+			diff = Difference{Kind: DiffRemoved, Before: before[i].Item}
+			i++
+		case i == len(before) || after[j].Item.Less(index, before[i].Item):
+			diff = Difference{Kind: DiffAdded, After: after[j].Item}
+			j++
+		default:
+			x, y := before[i], after[j]
+			i++
+			j++
+			if sameLineage && x.ID == y.ID && x.Version == y.Version {
+				continue
+			}
+			if reflect.DeepEqual(x.Item, y.Item) {
+				continue
+			}
+			diff = Difference{Kind: DiffChanged, Before: x.Item, After: y.Item}
+		}
+
+		if !yield(diff) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+/*
+	Position within a view: subtrees yet to walk whole, and items of nodes yet to walk on their own
+
+	The next items are those of the last entry.
+*/
+type viewCursor []cursorEntry
+
+type cursorEntry struct {
+	node *sharedNode
+
+	// Whether the whole subtree is pending, or only the item of the node
+	whole bool
+}
+
+func newViewCursor(root *sharedNode) viewCursor {
+	var c viewCursor
+	c.push(root)
+	return c
+}
+
+func (c *viewCursor) push(n *sharedNode) {
+	if n != nil {
+		*c = append(*c, cursorEntry{node: n, whole: true})
+	}
+}
+
+func (c viewCursor) next() cursorEntry {
+	if len(c) == 0 {
+		return cursorEntry{}
+	}
+	return c[len(c)-1]
+}
+
+func (c *viewCursor) pop() {
+	*c = (*c)[:len(*c)-1]
+}
+
+// Replace the subtree walked next with its left subtree, the item of its root and its right subtree
+func (c *viewCursor) expand() {
+	n := c.next().node
+	c.pop()
+	c.push(n.right)
+	*c = append(*c, cursorEntry{node: n})
+	c.push(n.left)
+}
+
+func (e cursorEntry) first() Item {
+	if e.whole {
+		return e.node.first
+	}
+	return e.node.item.Item
+}
+
+func (e cursorEntry) last() Item {
+	if e.whole {
+		return e.node.last
+	}
+	return e.node.item.Item
+}
+
+// Run yield on items of the entry in order, until it returns false
+func (e cursorEntry) walk(yield func(SnapshotItem) bool) bool {
+	if e.whole {
+		return e.node.walk(yield)
+	}
+	return yield(e.node.item)
+}
+
+/*
+	Walk differences between two views in order of their primary index, until yield returns false
+
+	Subtrees are walked down only as long as the other side has items within them, and skipped when
+	both sides share them.
+*/
+func diffViews(a, b View, index string, yield func(Difference) bool) {
+	sameLineage := a.lineage == b.lineage
+	removed := func(item SnapshotItem) bool {
+		return yield(Difference{Kind: DiffRemoved, Before: item.Item})
+	}
+	added := func(item SnapshotItem) bool {
+		return yield(Difference{Kind: DiffAdded, After: item.Item})
+	}
+
+	before, after := newViewCursor(a.root), newViewCursor(b.root)
+	for len(before) > 0 || len(after) > 0 {
+		x, y := before.next(), after.next()
+		switch {
+		case x.whole && y.whole && x.node == y.node:
+			// Subtree shared by both sides
+			before.pop()
+			after.pop()
+		case y.node == nil || (x.node != nil && x.first().Less(index, y.first())):
+			// Before goes first, removed unless after has items within it
+			if y.node != nil && x.whole && !x.last().Less(index, y.first()) {
+				before.expand()
+				continue
+			}
+			before.pop()
+			if !x.walk(removed) {
+				return
+			}
+		case x.node == nil || y.first().Less(index, x.first()):
+			// After goes first, added unless before has items within it
+			if x.node != nil && y.whole && !y.last().Less(index, x.first()) {
+				after.expand()
+				continue
+			}
+			after.pop()
+			if !y.walk(added) {
+				return
+			}
+		case x.whole && (!y.whole || x.node.size >= y.node.size):
+			// Same key first on both sides, walked down to its item (larger subtree first, to meet shared ones)
+			before.expand()
+		case y.whole:
+			after.expand()
+		default:
+			before.pop()
+			after.pop()
+			i, j := x.node.item, y.node.item
+			if (sameLineage && i.ID == j.ID && i.Version == j.Version) || reflect.DeepEqual(i.Item, j.Item) {
+				continue
+			}
+			if !yield(Difference{Kind: DiffChanged, Before: i.Item, After: j.Item}) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package memstore
+
+import (
+	"reflect"
+	"testing"
+)
+
+func collectDiff(t *testing.T, a, b Diffable, index string) []Difference {
+	var res []Difference
+	if err := Diff(a, b, index, func(diff Difference) bool {
+		res = append(res, diff)
+		return true
+	}); err != nil {
+		t.Fatalf("Diff failed, err=%v", err)
+	}
+	return res
+}
+
+func TestDiff(t *testing.T) {
+	ms := New([]string{"id", "name"})
+	for _, x := range testData() {
+		ms.Add(x)
+	}
+	before := ms.Snapshot()
+
+	ms.Delete(TestStruct{id: 2}, "id")
+	ms.UpdateData(TestStruct{id: 3}, "id", func(x Item) (Item, bool) {
+		return TestStruct{3, 9, "z"}, true
+	})
+	ms.Add(TestStruct{100, 1, "a"})
+
+	// Writing the same value again isn't a difference
+	ms.UpdateData(TestStruct{id: 4}, "id", func(x Item) (Item, bool) {
+		return x, true
+	})
+
+	expected := []Difference{
+		{DiffRemoved, TestStruct{2, 2, "y"}, nil},
+		{DiffChanged, TestStruct{3, 5, "z"}, TestStruct{3, 9, "z"}},
+		{DiffAdded, nil, TestStruct{100, 1, "a"}},
+	}
+	if res := collectDiff(t, before, ms, "id"); !reflect.DeepEqual(res, expected) {
+		t.Errorf("Diff between snapshot and store is wrong, res=%v", res)
+	}
+	if res := collectDiff(t, before, ms.Snapshot(), "id"); !reflect.DeepEqual(res, expected) {
+		t.Errorf("Diff between snapshots is wrong, res=%v", res)
+	}
+
+	// Other way around
+	if res := collectDiff(t, ms, before, "id"); len(res) != 3 || res[0].Kind != DiffAdded || res[1].Before != (TestStruct{3, 9, "z"}) || res[2].Kind != DiffRemoved {
+		t.Errorf("Reversed diff is wrong, res=%v", res)
+	}
+
+	// Snapshots are ordered by other indexes as needed
+	if res := collectDiff(t, before, ms, "name"); len(res) != 3 || res[0].After != (TestStruct{100, 1, "a"}) || res[1].Before != (TestStruct{2, 2, "y"}) {
+		t.Errorf("Diff by another index is wrong, res=%v", res)
+	}
+
+	if res := collectDiff(t, ms, ms, "id"); len(res) != 0 {
+		t.Errorf("Store shouldn't differ from itself, res=%v", res)
+	}
+
+	stopped := 0
+	Diff(before, ms, "id", func(Difference) bool {
+		stopped++
+		return false
+	})
+	if stopped != 1 {
+		t.Error("Diff should stop once yield returns false")
+	}
+
+	if err := Diff(before, ms, "notIndex", func(Difference) bool { return true }); err != ErrUnknownIndex {
+		t.Errorf("Diff by unknown index should fail, err=%v", err)
+	}
+
+	// Reading a store is an operation like others
+	calls := []string{}
+	hooks := &recordingHooks{name: "hooks", calls: &calls}
+	hooked := New([]string{"id"}, WithHooks(hooks))
+	hooked.Add(TestStruct{1, 1, "x"})
+	collectDiff(t, before, hooked, "id")
+	if last := hooks.operations[len(hooks.operations)-1]; last.Name != "Diff" || last.Index != "id" || last.Items != 1 {
+		t.Errorf("Diff should be seen by hooks, operation=%v", last)
+	}
+}
+
+/*
+	Item never deeply equal to another, as it holds a function
+*/
+type opaqueItem struct {
+	id  int
+	run func()
+}
+
+func (oi opaqueItem) Less(index string, than interface{}) bool {
+	return oi.id < than.(opaqueItem).id
+}
+
+func TestDiffLineage(t *testing.T) {
+	leader := New([]string{"id"}, WithOpLog(10))
+	for id := 0; id < 5; id++ {
+		leader.Add(opaqueItem{id, func() {}})
+	}
+	before := leader.Snapshot()
+	leader.Add(opaqueItem{5, func() {}})
+
+	// Items that kept their identity and version aren't compared
+	if res := collectDiff(t, before, leader, "id"); len(res) != 1 || res[0].Kind != DiffAdded {
+		t.Errorf("Unchanged items should be skipped, res=%v", res)
+	}
+
+	follower := New([]string{"id"}, AsFollower())
+	follower.ApplySnapshot(before)
+	if res := collectDiff(t, follower, before, "id"); len(res) != 0 {
+		t.Errorf("Follower shares identities and versions of its leader, res=%v", res)
+	}
+
+	// Stores of another lineage have their items compared
+	other := New([]string{"id"})
+	for _, item := range before.Items {
+		other.Add(item.Item)
+	}
+	if res := collectDiff(t, before, other, "id"); len(res) != 5 || res[0].Kind != DiffChanged {
+		t.Errorf("Items of another lineage should be compared, res=%v", res)
+	}
+
+	// Stores writing on their own after a snapshot of the same store diverge
+	a := New([]string{"id"})
+	a.Add(TestStruct{1, 1, "x"})
+	b := New([]string{"id"})
+	b.ApplySnapshot(a.Snapshot())
+	for ms, name := range map[*Memstore]string{a: "fromA", b: "fromB"} {
+		ms.UpdateWithIndexes(TestStruct{id: 1}, "id", func(Item) (Item, bool) {
+			return TestStruct{1, 1, name}, true
+		})
+	}
+	if res := collectDiff(t, a, b, "id"); len(res) != 1 || res[0].After != (TestStruct{1, 1, "fromB"}) {
+		t.Errorf("Stores diverging after a snapshot should be compared, res=%v", res)
+	}
+
+	// So do followers once promoted
+	promoted := New([]string{"id"}, AsFollower())
+	promoted.ApplySnapshot(a.Snapshot())
+	promoted.Promote()
+	promoted.UpdateWithIndexes(TestStruct{id: 1}, "id", func(Item) (Item, bool) {
+		return TestStruct{1, 1, "promoted"}, true
+	})
+	a.UpdateWithIndexes(TestStruct{id: 1}, "id", func(Item) (Item, bool) {
+		return TestStruct{1, 1, "leader"}, true
+	})
+	if res := collectDiff(t, a, promoted, "id"); len(res) != 1 || res[0].After != (TestStruct{1, 1, "promoted"}) {
+		t.Errorf("Promoted follower should be compared, res=%v", res)
+	}
+}
+
+/*
+	Item counting comparisons made by trees and Diff
+*/
+type countedItem struct {
+	id          int
+	value       int
+	comparisons *int
+}
+
+func (ci countedItem) Less(index string, than interface{}) bool {
+	*ci.comparisons++
+	other := than.(countedItem)
+	if index == "reversed" {
+		return ci.id > other.id
+	}
+	return ci.id < other.id
+}
+
+func TestDiffViews(t *testing.T) {
+	if _, err := New([]string{"id"}).View(); err != ErrNoViews {
+		t.Errorf("Views should only be taken when kept, err=%v", err)
+	}
+
+	comparisons := 0
+	ms := New([]string{"id", "reversed"}, WithViews())
+	for id := 0; id < 10000; id++ {
+		ms.Add(countedItem{id, 0, &comparisons})
+	}
+	before, err := ms.View()
+	if err != nil || before.Len() != 10000 {
+		t.Fatalf("View failed, err=%v len=%v", err, before.Len())
+	}
+
+	ms.Delete(countedItem{id: 10, comparisons: &comparisons}, "id")
+	ms.UpdateData(countedItem{id: 5000, comparisons: &comparisons}, "id", func(x Item) (Item, bool) {
+		return countedItem{5000, 1, &comparisons}, true
+	})
+	ms.Add(countedItem{20000, 0, &comparisons})
+	after, _ := ms.View()
+
+	// Views don't change with the store
+	if before.Len() != 10000 || after.Len() != 10000 || after.Seq() != before.Seq()+3 {
+		t.Errorf("Views should keep the store as it was, lens=%v,%v", before.Len(), after.Len())
+	}
+
+	expected := []Difference{
+		{DiffRemoved, countedItem{10, 0, &comparisons}, nil},
+		{DiffChanged, countedItem{5000, 0, &comparisons}, countedItem{5000, 1, &comparisons}},
+		{DiffAdded, nil, countedItem{20000, 0, &comparisons}},
+	}
+
+	// Subtrees both views share are skipped
+	comparisons = 0
+	if res := collectDiff(t, before, after, "id"); !reflect.DeepEqual(res, expected) {
+		t.Errorf("Diff between views is wrong, res=%v", res)
+	}
+	if comparisons > 500 {
+		t.Errorf("Diff between views should skip shared subtrees, comparisons=%v", comparisons)
+	}
+
+	comparisons = 0
+	if res := collectDiff(t, before, ms, "id"); !reflect.DeepEqual(res, expected) {
+		t.Errorf("Diff between view and store is wrong, res=%v", res)
+	}
+	if comparisons > 500 {
+		t.Errorf("Diff between view and store should skip shared subtrees, comparisons=%v", comparisons)
+	}
+
+	// Views are compared with snapshots, and by other indexes, item by item
+	snapshot := ms.Snapshot()
+	if res := collectDiff(t, before, snapshot, "id"); !reflect.DeepEqual(res, expected) {
+		t.Errorf("Diff between view and snapshot is wrong, res=%v", res)
+	}
+	if res := collectDiff(t, before, after, "reversed"); len(res) != 3 || res[0].Kind != DiffAdded || res[2].Kind != DiffRemoved {
+		t.Errorf("Diff between views by another index is wrong, res=%v", res)
+	}
+
+	// Views of other stores share nothing, but are compared all the same
+	other := New([]string{"id", "reversed"}, WithViews())
+	for id := 0; id < 10000; id++ {
+		other.Add(countedItem{id, 0, &comparisons})
+	}
+	if res := collectDiff(t, other, before, "id"); len(res) != 0 {
+		t.Errorf("Views of different stores with the same items shouldn't differ, res=%v", res)
+	}
+
+	// Views follow snapshots applied
+	other.ApplySnapshot(snapshot)
+	if res := collectDiff(t, ms, other, "id"); len(res) != 0 {
+		t.Errorf("Views should follow snapshots applied, res=%v", res)
+	}
+}
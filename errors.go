@@ -0,0 +1,21 @@
+package memstore
+
+import (
+	"errors"
+)
+
+var (
+	ErrUnknownIndex = errors.New("memstore: unknown index")
+	ErrNotKeyed     = errors.New("memstore: items don't expose raw keys")
+	ErrKeyType      = errors.New("memstore: key bound doesn't compare with keys of items")
+	ErrNotFound     = errors.New("memstore: item not found")
+	ErrNotModified  = errors.New("memstore: update rejected by modify function")
+	ErrIndexChanged = errors.New("memstore: update changes indexed fields")
+	ErrConflict     = errors.New("memstore: item version changed")
+	ErrDuplicate    = errors.New("memstore: item conflicts with another one on a unique index")
+	ErrReadOnly     = errors.New("memstore: store is a read-only follower")
+	ErrNoOpLog      = errors.New("memstore: store keeps no operation log")
+	ErrNoViews      = errors.New("memstore: store keeps no views")
+	ErrLogTruncated = errors.New("memstore: mutations were dropped from operation log")
+	ErrOutOfSync    = errors.New("memstore: mutation doesn't follow the state of the store")
+)
@@ -0,0 +1,363 @@
+package memstore
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+)
+
+// Mean radius of the Earth, in meters
+const earthRadius = 6371008.8
+
+/*
+	Location in degrees, latitude within [-90, 90] and longitude within [-180, 180]
+*/
+type Point struct {
+	Lat float64
+	Lon float64
+}
+
+func (p Point) valid() bool {
+	return p.Lat >= -90 && p.Lat <= 90 && p.Lon >= -180 && p.Lon <= 180
+}
+
+/*
+	Area between two corners, Min having the smallest latitude and longitude
+
+	Boxes don't cross the antimeridian: query both sides of it separately.
+*/
+type Box struct {
+	Min Point
+	Max Point
+}
+
+/*
+	Geospatial index of items located by a point, kept in an R-tree
+
+	Location tells where an item is, if it has a location. Items located outside of valid coordinates aren't indexed.
+*/
+type GeoIndex struct {
+	Name     string
+	Location func(Item) (Point, bool)
+}
+
+// Item found by a geospatial query, and its distance in meters
+type GeoHit struct {
+	Item     Item
+	Distance float64
+}
+
+type geoIndex struct {
+	config GeoIndex
+	tree   *rtree
+
+	// Location of every item indexed, to find it on removal
+	locations map[uint64]Point
+}
+
+func newGeoIndex(config GeoIndex) *geoIndex {
+	return &geoIndex{
+		config:    config,
+		tree:      newRTree(),
+		locations: map[uint64]Point{},
+	}
+}
+
+// Index location of an item, replacing the one it had (expects a write lock)
+func (gi *geoIndex) put(ii *internalItem) {
+	gi.remove(ii.id)
+	p, ok := gi.config.Location(ii.value)
+	if !ok || !p.valid() {
+		return
+	}
+	gi.tree.insert(ii, p)
+	gi.locations[ii.id] = p
+}
+
+// Forget location of an item (expects a write lock)
+func (gi *geoIndex) remove(id uint64) {
+	p, ok := gi.locations[id]
+	if !ok {
+		return
+	}
+	gi.tree.remove(id, p)
+	delete(gi.locations, id)
+}
+
+func radians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
+
+// Great-circle distance between two points, in meters (haversine formula)
+func Distance(a, b Point) float64 {
+	dLat := radians(b.Lat - a.Lat)
+	dLon := radians(b.Lon - a.Lon)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(radians(a.Lat))*math.Cos(radians(b.Lat))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+func clamp(x, min, max float64) float64 {
+	return math.Max(min, math.Min(max, x))
+}
+
+/*
+	Shortest distance from a point to any point of a box, in meters
+
+	Within the longitudes of the box, the closest point is on the same meridian. Otherwise it is on an edge meridian:
+	at the latitude of the edge closest to the point (clamped to the box) if the point is less than 90° of longitude away,
+	at one of its corners in any case.
+*/
+func boxDistance(p Point, b Box) float64 {
+	if p.Lon >= b.Min.Lon && p.Lon <= b.Max.Lon {
+		return Distance(p, Point{clamp(p.Lat, b.Min.Lat, b.Max.Lat), p.Lon})
+	}
+
+	best := math.Inf(1)
+	for _, lon := range []float64{b.Min.Lon, b.Max.Lon} {
+		best = math.Min(best, Distance(p, Point{b.Min.Lat, lon}))
+		best = math.Min(best, Distance(p, Point{b.Max.Lat, lon}))
+		if dLon := radians(lon - p.Lon); math.Cos(dLon) > 0 {
+			lat := math.Atan(math.Tan(radians(p.Lat))/math.Cos(dLon)) * 180 / math.Pi
+			best = math.Min(best, Distance(p, Point{clamp(lat, b.Min.Lat, b.Max.Lat), lon}))
+		}
+	}
+	return best
+}
+
+/*
+	Box holding every point within a distance of center
+
+	Points of the circle reach longitudes furthest from the center where asin(sin(radius) / cos(latitude)) tells.
+*/
+func radiusBox(center Point, meters float64) Box {
+	angle := meters / earthRadius
+	dLat := angle * 180 / math.Pi
+	box := Box{Min: Point{center.Lat - dLat, -180}, Max: Point{center.Lat + dLat, 180}}
+
+	// Around poles and across the antimeridian, every longitude may be close enough
+	if box.Min.Lat > -90 && box.Max.Lat < 90 {
+		dLon := math.Asin(math.Sin(angle)/math.Cos(radians(center.Lat))) * 180 / math.Pi
+		if center.Lon-dLon >= -180 && center.Lon+dLon <= 180 {
+			box.Min.Lon, box.Max.Lon = center.Lon-dLon, center.Lon+dLon
+		}
+	}
+	return box
+}
+
+/*
+	Maintain a geospatial index of items, kept in sync with every write
+
+	Fails if a geospatial index with the same name exists
+*/
+func (ms *Memstore) AddGeoIndex(config GeoIndex) bool {
+	op := ms.begin(opAddGeoIndex, config.Name)
+	defer ms.end(op)
+
+	ms.lock(op)
+	defer ms.unlock(op)
+
+	if _, ok := ms.geo[config.Name]; ok {
+		return false
+	}
+
+	gi := newGeoIndex(config)
+	ascendRange(ms.trees[0], ms.indexes[0], All(), func(ii *internalItem) bool {
+		gi.put(ii)
+		return true
+	})
+	op.addItems(gi.tree.size)
+
+	if ms.geo == nil {
+		ms.geo = map[string]*geoIndex{}
+	}
+	ms.geo[config.Name] = gi
+
+	return true
+}
+
+// Geospatial index by name, expects at least a read lock
+func (ms *Memstore) lookupGeo(index string) (*geoIndex, error) {
+	gi, ok := ms.geo[index]
+	if !ok {
+		return nil, ErrUnknownIndex
+	}
+	return gi, nil
+}
+
+/*
+	Items of a geospatial index located within a box (edges included), in order of the primary index
+
+	Fails with ErrUnknownIndex if there is no geospatial index with that name.
+*/
+func (ms *Memstore) WithinBox(index string, box Box) ([]Item, error) {
+	op := ms.begin(opWithinBox, index)
+	defer ms.end(op)
+
+	ms.rlock(op)
+	defer ms.runlock(op)
+
+	gi, err := ms.lookupGeo(index)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []Item
+	gi.tree.search(gi.tree.root, box, func(ii *internalItem, p Point) bool {
+		res = append(res, ii.value)
+		return true
+	})
+
+	primary := ms.indexes[0]
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Less(primary, res[j])
+	})
+	op.addItems(len(res))
+
+	return res, nil
+}
+
+/*
+	Items of a geospatial index within a distance in meters of a point, closest first
+
+	Fails with ErrUnknownIndex if there is no geospatial index with that name.
+*/
+func (ms *Memstore) WithinRadius(index string, center Point, meters float64) ([]GeoHit, error) {
+	op := ms.begin(opWithinRadius, index)
+	defer ms.end(op)
+
+	ms.rlock(op)
+	defer ms.runlock(op)
+
+	gi, err := ms.lookupGeo(index)
+	if err != nil {
+		return nil, err
+	}
+
+	var hits []GeoHit
+	var ids []uint64
+	gi.tree.search(gi.tree.root, radiusBox(center, meters), func(ii *internalItem, p Point) bool {
+		if d := Distance(center, p); d <= meters {
+			hits = append(hits, GeoHit{Item: ii.value, Distance: d})
+			ids = append(ids, ii.id)
+		}
+		return true
+	})
+	sort.Sort(geoHitsByDistance{hits, ids})
+	op.addItems(len(hits))
+
+	return hits, nil
+}
+
+// Hits by distance, then identity
+type geoHitsByDistance struct {
+	hits []GeoHit
+	ids  []uint64
+}
+
+func (h geoHitsByDistance) Len() int {
+	return len(h.hits)
+}
+
+func (h geoHitsByDistance) Less(i, j int) bool {
+	if h.hits[i].Distance != h.hits[j].Distance {
+		return h.hits[i].Distance < h.hits[j].Distance
+	}
+	return h.ids[i] < h.ids[j]
+}
+
+func (h geoHitsByDistance) Swap(i, j int) {
+	h.hits[i], h.hits[j] = h.hits[j], h.hits[i]
+	h.ids[i], h.ids[j] = h.ids[j], h.ids[i]
+}
+
+// Entry of an R-tree to visit, by shortest possible distance
+type geoCandidate struct {
+	entry    rtreeEntry
+	distance float64
+}
+
+type geoQueue []geoCandidate
+
+func (q geoQueue) Len() int {
+	return len(q)
+}
+
+func (q geoQueue) Less(i, j int) bool {
+	if q[i].distance != q[j].distance {
+		return q[i].distance < q[j].distance
+	}
+	// Items before nodes at the same distance, then by identity
+	if (q[i].entry.ii == nil) != (q[j].entry.ii == nil) {
+		return q[i].entry.ii != nil
+	}
+	return q[i].entry.ii != nil && q[i].entry.ii.id < q[j].entry.ii.id
+}
+
+func (q geoQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+func (q *geoQueue) Push(x interface{}) {
+	*q = append(*q, x.(geoCandidate))
+}
+
+func (q *geoQueue) Pop() interface{} {
+	old := *q
+	x := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return x
+}
+
+/*
+	The k items of a geospatial index closest to a point, closest first
+
+	Visits nodes of the R-tree by distance, so that only those that may hold one of the k items are read.
+	Fails with ErrUnknownIndex if there is no geospatial index with that name.
+*/
+func (ms *Memstore) Nearest(index string, p Point, k int) ([]GeoHit, error) {
+	op := ms.begin(opNearest, index)
+	defer ms.end(op)
+
+	ms.rlock(op)
+	defer ms.runlock(op)
+
+	gi, err := ms.lookupGeo(index)
+	if err != nil {
+		return nil, err
+	}
+
+	var hits []GeoHit
+	queue := &geoQueue{{entry: rtreeEntry{child: gi.tree.root}}}
+	for queue.Len() > 0 && len(hits) < k {
+		next := heap.Pop(queue).(geoCandidate)
+		if next.entry.ii != nil {
+			hits = append(hits, GeoHit{Item: next.entry.ii.value, Distance: next.distance})
+			continue
+		}
+
+		node := next.entry.child
+		for _, e := range node.entries {
+			candidate := geoCandidate{entry: e}
+			if node.leaf {
+				candidate.distance = Distance(p, e.box.Min)
+			} else {
+				candidate.distance = boxDistance(p, e.box)
+			}
+			heap.Push(queue, candidate)
+		}
+	}
+	op.addItems(len(hits))
+
+	return hits, nil
+}
+
+// Keep geospatial indexes in sync with a put or delete (expects a write lock)
+func (ms *Memstore) indexGeo(kind MutationKind, ii *internalItem) {
+	for _, gi := range ms.geo {
+		if kind == MutationDelete {
+			gi.remove(ii.id)
+		} else {
+			gi.put(ii)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package memstore
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+type vehicle struct {
+	id       int
+	location Point
+	located  bool
+}
+
+func (v vehicle) Less(index string, than interface{}) bool {
+	return v.id < than.(vehicle).id
+}
+
+func vehicleGeo() GeoIndex {
+	return GeoIndex{
+		Name: "location",
+		Location: func(x Item) (Point, bool) {
+			v := x.(vehicle)
+			return v.location, v.located
+		},
+	}
+}
+
+func randomPoint(rng *rand.Rand) Point {
+	switch rng.Intn(4) {
+	case 0:
+		// Anywhere, including near poles and the antimeridian
+		return Point{rng.Float64()*180 - 90, rng.Float64()*360 - 180}
+	default:
+		// Around a city
+		return Point{48.85 + rng.Float64()*0.2 - 0.1, 2.35 + rng.Float64()*0.2 - 0.1}
+	}
+}
+
+// Check that every node but the root is filled enough, covered by its box and at the depth of other leaves
+func checkRTree(t *testing.T, tree *rtree) {
+	leafDepth := -1
+	items := 0
+	var walk func(node *rtreeNode, depth int)
+	walk = func(node *rtreeNode, depth int) {
+		if node != tree.root && (len(node.entries) < rtreeMinEntries || len(node.entries) > rtreeMaxEntries) {
+			t.Fatalf("Node has %v entries", len(node.entries))
+		}
+		if node.leaf {
+			if leafDepth >= 0 && depth != leafDepth {
+				t.Fatalf("Leaves at depths %v and %v", leafDepth, depth)
+			}
+			leafDepth = depth
+			items += len(node.entries)
+			return
+		}
+		for _, e := range node.entries {
+			if e.child.parent != node || e.box != e.child.box() {
+				t.Fatal("Entry doesn't match its child")
+			}
+			walk(e.child, depth+1)
+		}
+	}
+	walk(tree.root, 0)
+	if items != tree.size {
+		t.Fatalf("Tree holds %v items, size=%v", items, tree.size)
+	}
+}
+
+// Brute force answers from every vehicle
+type vehicleFleet map[int]vehicle
+
+func (fleet vehicleFleet) withinBox(box Box) []Item {
+	var res []Item
+	for _, v := range fleet {
+		if v.located && box.contains(v.location) {
+			res = append(res, v)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].(vehicle).id < res[j].(vehicle).id
+	})
+	return res
+}
+
+func (fleet vehicleFleet) byDistance(p Point) []GeoHit {
+	var res []GeoHit
+	for _, v := range fleet {
+		if v.located {
+			res = append(res, GeoHit{v, Distance(p, v.location)})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Distance < res[j].Distance
+	})
+	return res
+}
+
+func checkGeoQueries(t *testing.T, ms *Memstore, fleet vehicleFleet, rng *rand.Rand) {
+	for i := 0; i < 20; i++ {
+		a, b := randomPoint(rng), randomPoint(rng)
+		box := Box{Point{math.Min(a.Lat, b.Lat), math.Min(a.Lon, b.Lon)}, Point{math.Max(a.Lat, b.Lat), math.Max(a.Lon, b.Lon)}}
+		found, err := ms.WithinBox("location", box)
+		if err != nil {
+			t.Fatalf("WithinBox failed, err=%v", err)
+		}
+		if expected := fleet.withinBox(box); !reflect.DeepEqual(found, expected) {
+			t.Fatalf("WithinBox %v is wrong, expected %v items, found %v", box, len(expected), len(found))
+		}
+
+		center := randomPoint(rng)
+		all := fleet.byDistance(center)
+		k := rng.Intn(20)
+		nearest, _ := ms.Nearest("location", center, k)
+		if k > len(all) {
+			k = len(all)
+		}
+		if len(nearest) != k {
+			t.Fatalf("Nearest should find %v items, found %v", k, len(nearest))
+		}
+		for j, hit := range nearest {
+			if math.Abs(hit.Distance-all[j].Distance) > 1e-6 || Distance(center, hit.Item.(vehicle).location) != hit.Distance {
+				t.Fatalf("Nearest %v to %v is wrong, expected=%v found=%v", j, center, all[j], hit)
+			}
+		}
+
+		meters := rng.Float64() * 20000
+		if rng.Intn(4) == 0 {
+			meters *= 500
+		}
+		within, _ := ms.WithinRadius("location", center, meters)
+		expected := 0
+		for expected < len(all) && all[expected].Distance <= meters {
+			expected++
+		}
+		if len(within) != expected {
+			t.Fatalf("WithinRadius %v of %v should find %v items, found %v", meters, center, expected, len(within))
+		}
+		for j := 1; j < len(within); j++ {
+			if within[j].Distance < within[j-1].Distance {
+				t.Fatal("WithinRadius should find closest items first")
+			}
+		}
+	}
+}
+
+func TestGeoQueries(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	ms := New([]string{"id"})
+	fleet := vehicleFleet{}
+	for id := 0; id < 1500; id++ {
+		v := vehicle{id, randomPoint(rng), id%10 != 0}
+		ms.Add(v)
+		fleet[id] = v
+	}
+	if !ms.AddGeoIndex(vehicleGeo()) || ms.AddGeoIndex(vehicleGeo()) {
+		t.Fatal("Geospatial index should be added once")
+	}
+	checkRTree(t, ms.geo["location"].tree)
+	checkGeoQueries(t, ms, fleet, rng)
+
+	if _, err := ms.Nearest("unknown", Point{}, 1); err != ErrUnknownIndex {
+		t.Errorf("Query of unknown index should fail, err=%v", err)
+	}
+}
+
+func TestGeoIndexSync(t *testing.T) {
+	rng := rand.New(rand.NewSource(5))
+	ms := New([]string{"id"})
+	ms.AddGeoIndex(vehicleGeo())
+	fleet := vehicleFleet{}
+
+	for step := 0; step < 6000; step++ {
+		id := rng.Intn(800)
+		switch rng.Intn(5) {
+		case 0, 1:
+			if _, ok := fleet[id]; !ok {
+				v := vehicle{id, randomPoint(rng), rng.Intn(8) != 0}
+				ms.Add(v)
+				fleet[id] = v
+			}
+		case 2:
+			// Vehicles move in place
+			moved := vehicle{id, randomPoint(rng), true}
+			if ms.UpdateData(vehicle{id: id}, "id", func(Item) (Item, bool) { return moved, true }) != nil {
+				fleet[id] = moved
+			}
+		case 3:
+			ms.Delete(vehicle{id: id}, "id")
+			delete(fleet, id)
+		default:
+			// Or get another identity
+			other := rng.Intn(800)
+			if _, taken := fleet[other]; taken {
+				continue
+			}
+			if old, ok := fleet[id]; ok {
+				ms.UpdateWithIndexes(old, "id", func(Item) (Item, bool) {
+					return vehicle{other, old.location, old.located}, true
+				})
+				delete(fleet, id)
+				fleet[other] = vehicle{other, old.location, old.located}
+			}
+		}
+	}
+
+	checkRTree(t, ms.geo["location"].tree)
+	checkGeoQueries(t, ms, fleet, rng)
+
+	// Followers index items of snapshots
+	follower := New([]string{"id"}, AsFollower())
+	follower.AddGeoIndex(vehicleGeo())
+	follower.ApplySnapshot(ms.Snapshot())
+	checkGeoQueries(t, follower, fleet, rng)
+}
+
+func TestGeoIndexReplace(t *testing.T) {
+	ms := New([]string{"id"})
+	ms.AddGeoIndex(vehicleGeo())
+	paris, tokyo := Point{48.85, 2.35}, Point{35.68, 139.69}
+	ms.Add(vehicle{1, paris, true})
+	ms.Add(vehicle{1, tokyo, true})
+
+	if found, _ := ms.WithinBox("location", Box{Point{48, 2}, Point{49, 3}}); len(found) != 0 {
+		t.Errorf("Replaced item should be forgotten, found=%v", found)
+	}
+	if hits, _ := ms.WithinRadius("location", paris, 20000e3); len(hits) != 1 || hits[0].Item != (vehicle{1, tokyo, true}) {
+		t.Errorf("Only the item replacing it should be found, hits=%v", hits)
+	}
+
+	ms.Delete(vehicle{id: 1}, "id")
+	if hits, _ := ms.Nearest("location", paris, 5); len(hits) != 0 || ms.geo["location"].tree.size != 0 {
+		t.Errorf("Deleted item should be forgotten, hits=%v", hits)
+	}
+}
+
+func TestBoxDistance(t *testing.T) {
+	rng := rand.New(rand.NewSource(9))
+	for i := 0; i < 300; i++ {
+		a, b := randomPoint(rng), randomPoint(rng)
+		box := Box{Point{math.Min(a.Lat, b.Lat), math.Min(a.Lon, b.Lon)}, Point{math.Max(a.Lat, b.Lat), math.Max(a.Lon, b.Lon)}}
+		p := randomPoint(rng)
+		bound := boxDistance(p, box)
+
+		if box.contains(p) {
+			if bound != 0 {
+				t.Fatalf("Distance from %v to %v holding it should be 0, bound=%v", p, box, bound)
+			}
+			continue
+		}
+
+		// Never more than the distance to a point of the box, and within a cell of the closest one sampled
+		const cells = 50
+		dLat, dLon := (box.Max.Lat-box.Min.Lat)/cells, (box.Max.Lon-box.Min.Lon)/cells
+		closest := math.Inf(1)
+		for lat := 0; lat <= cells; lat++ {
+			for lon := 0; lon <= cells; lon++ {
+				closest = math.Min(closest, Distance(p, Point{box.Min.Lat + dLat*float64(lat), box.Min.Lon + dLon*float64(lon)}))
+			}
+		}
+		if bound > closest+1e-6 || closest-bound > Distance(Point{}, Point{dLat, dLon})+1e-6 {
+			t.Fatalf("Distance from %v to %v is wrong, bound=%v closest sampled=%v", p, box, bound, closest)
+		}
+	}
+}
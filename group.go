@@ -0,0 +1,76 @@
+package memstore
+
+import (
+	"reflect"
+)
+
+/*
+	Aggregate of a group of contiguous items sharing a key
+*/
+type Group struct {
+	Key   interface{}
+	Value interface{}
+}
+
+// Check whether two group keys are equal, comparing raw keys by value when possible
+func keysEqual(a, b interface{}) bool {
+	if comparison, ok := compareKeys(a, b); ok {
+		return comparison == 0
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+/*
+	Walk an index once, grouping contiguous items with equal keys and aggregating every group
+
+	Groups come out in index order, limit is the maximum number of groups (zero means no limit)
+*/
+func (ms *Memstore) GroupBy(index string, key func(Item) interface{}, agg Aggregate, limit int) (res []Group) {
+	op := ms.begin(opGroupBy, index)
+	defer ms.end(op)
+
+	// Get corresponding tree
+	tree := ms.lookupTree(index)
+	if tree == nil {
+		return nil
+	}
+
+	var current *Group
+	var value interface{}
+
+	ms.rlock(op)
+
+	ascendRange(tree, index, All(), func(ii *internalItem) bool {
+		x := ii.value
+		k := key(x)
+
+		// Close current group when key changes
+		if current != nil && !keysEqual(current.Key, k) {
+			current.Value = agg.result(value)
+			res = append(res, *current)
+			current = nil
+		}
+
+		// Stop before opening a group past the limit
+		if current == nil {
+			if limit > 0 && len(res) == limit {
+				return false
+			}
+			current = &Group{Key: k}
+			value = agg.Identity
+		}
+
+		value = agg.Combine(value, agg.Extract(x))
+		op.addItems(1)
+		return true
+	})
+
+	ms.runlock(op)
+
+	if current != nil {
+		current.Value = agg.result(value)
+		res = append(res, *current)
+	}
+
+	return res
+}
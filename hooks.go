@@ -0,0 +1,65 @@
+package memstore
+
+import (
+	"time"
+)
+
+/*
+	Public operation on a store, as seen by hooks
+
+	Items, duration and lock timings are final once the operation is over
+*/
+type Operation struct {
+	// Name of the method called, and index it was called with (if any)
+	Name  string
+	Index string
+
+	// Number of items read or written
+	Items int
+
+	Start    time.Time
+	Duration time.Duration
+
+	// Time spent waiting for and holding the store lock
+	LockWait time.Duration
+	LockHold time.Duration
+}
+
+/*
+	Hooks called around every public operation
+
+	Before runs before the operation starts, and what it returns is given back to After
+	once the operation is over (e.g. to end a tracing span)
+*/
+type Hooks interface {
+	Before(op *Operation) interface{}
+	After(op *Operation, state interface{})
+}
+
+/*
+	Hooks made of functions, either of which can be nil
+*/
+type HookFuncs struct {
+	BeforeFunc func(op *Operation) interface{}
+	AfterFunc  func(op *Operation, state interface{})
+}
+
+func (hf HookFuncs) Before(op *Operation) interface{} {
+	if hf.BeforeFunc == nil {
+		return nil
+	}
+	return hf.BeforeFunc(op)
+}
+
+func (hf HookFuncs) After(op *Operation, state interface{}) {
+	if hf.AfterFunc != nil {
+		hf.AfterFunc(op, state)
+	}
+}
+
+// Call hooks around every public operation, Before in order given and After in reverse order
+func WithHooks(hooks ...Hooks) Option {
+	return func(ms *Memstore) {
+		ms.hooks = append(ms.hooks, hooks...)
+	}
+}
@@ -0,0 +1,106 @@
+package memstore
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Hooks recording operations, and the order hooks were called in
+type recordingHooks struct {
+	name       string
+	calls      *[]string
+	operations []Operation
+}
+
+func (rh *recordingHooks) Before(op *Operation) interface{} {
+	*rh.calls = append(*rh.calls, "before "+rh.name+" "+op.Name)
+	return rh.name
+}
+
+func (rh *recordingHooks) After(op *Operation, state interface{}) {
+	*rh.calls = append(*rh.calls, "after "+state.(string)+" "+op.Name)
+	rh.operations = append(rh.operations, *op)
+}
+
+func TestHooksOrder(t *testing.T) {
+	calls := []string{}
+	first := &recordingHooks{name: "first", calls: &calls}
+	second := &recordingHooks{name: "second", calls: &calls}
+
+	ms := New([]string{"id"}, WithHooks(first, second))
+	var vItem Item = TestStruct{id: 1}
+	ms.Add(vItem)
+
+	expected := []string{"before first Add", "before second Add", "after second Add", "after first Add"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Hooks called in wrong order, calls=%v", calls)
+	}
+}
+
+func TestHooksOperations(t *testing.T) {
+	calls := []string{}
+	hooks := &recordingHooks{name: "hooks", calls: &calls}
+
+	ms := New([]string{"id", "importance"}, WithHooks(hooks))
+	for _, v := range shuffeledTestData() {
+		var vItem Item = v
+		ms.Add(vItem)
+	}
+
+	var from, to Item = TestStruct{id: 2}, TestStruct{id: 7}
+	ms.GetRange(from, to, "id", func(item Item) bool {
+		return true
+	})
+	ms.Delete(TestStruct{importance: 5}, "importance")
+	ms.Get(TestStruct{id: 100}, "id")
+	ms.UpdateData(TestStruct{id: 1}, "id", dataModifierFunc)
+
+	operations := hooks.operations[len(testData()):]
+	expected := []struct {
+		name  string
+		index string
+		items int
+	}{
+		{opGetRange, "id", 3},
+		{opDelete, "importance", 1},
+		{opGet, "id", 0},
+		{opUpdate, "id", 1},
+	}
+
+	if len(operations) != len(expected) {
+		t.Fatalf("Hooks should see every operation, operations=%+v", operations)
+	}
+	for i, op := range operations {
+		if op.Name != expected[i].name || op.Index != expected[i].index || op.Items != expected[i].items {
+			t.Errorf("Operation reported to hooks is wrong, operation=%+v expected=%+v", op, expected[i])
+		}
+		if op.Duration <= 0 || op.LockHold <= 0 || op.LockHold > op.Duration {
+			t.Errorf("Operation timings reported to hooks are wrong, operation=%+v", op)
+		}
+	}
+}
+
+func TestHookFuncs(t *testing.T) {
+	spans := map[int]bool{}
+	next := 0
+
+	ms := New([]string{"id"}, WithHooks(HookFuncs{
+		BeforeFunc: func(op *Operation) interface{} {
+			next++
+			spans[next] = true
+			return next
+		},
+		AfterFunc: func(op *Operation, state interface{}) {
+			delete(spans, state.(int))
+		},
+	}, HookFuncs{}))
+
+	var vItem Item = TestStruct{id: 1}
+	ms.Add(vItem)
+	ms.Get(vItem, "id")
+	ms.Query().Where("id", Equal(1)).Run()
+
+	if next != 3 || len(spans) != 0 {
+		t.Errorf("Every span should be ended, started=%v open=%v", next, spans)
+	}
+}
@@ -0,0 +1,167 @@
+package memstore
+
+import (
+	"fmt"
+	"github.com/mngharbi/GoLLRB/llrb"
+)
+
+/*
+	Ordered collection holding the internal items of one index
+
+	Items are ordered by their Less under the index, and equivalent items replace each other.
+	Implementations don't need to handle concurrent writes, the store lock guards them.
+*/
+type OrderedIndex interface {
+	Len() int
+	Get(key llrb.Item) llrb.Item
+	Min() llrb.Item
+	Max() llrb.Item
+
+	// Insert item, returns the equivalent item it replaced (if any)
+	ReplaceOrInsert(item llrb.Item) llrb.Item
+
+	// Delete item equivalent to key, returns it (if any)
+	Delete(key llrb.Item) llrb.Item
+
+	AscendGreaterOrEqual(pivot llrb.Item, iterator llrb.ItemIterator)
+	DescendLessOrEqual(pivot llrb.Item, iterator llrb.ItemIterator)
+
+	// Remove every item
+	clear()
+
+	// Number of levels
+	height() int
+
+	// Check structural invariants of the backend (order and contents are checked by Verify)
+	check(ir *IndexReport)
+}
+
+/*
+	Data structure used for the tree of an index
+*/
+type Backend int
+
+const (
+	// Left-leaning red-black tree
+	LLRBBackend Backend = iota
+
+	// B-tree keeping items of a node contiguous, friendlier to caches on large stores
+	BTreeBackend
+
+	// Skip list, its bottom level linked both ways
+	SkipListBackend
+)
+
+func (b Backend) String() string {
+	switch b {
+	case LLRBBackend:
+		return "llrb"
+	case BTreeBackend:
+		return "btree"
+	case SkipListBackend:
+		return "skiplist"
+	default:
+		return fmt.Sprintf("Backend(%d)", int(b))
+	}
+}
+
+// Use a backend for some indexes, or all of them if none is given (LLRB by default)
+func WithBackend(backend Backend, indexes ...string) Option {
+	return func(ms *Memstore) {
+		if len(indexes) == 0 {
+			ms.defaultBackend = backend
+			return
+		}
+		if ms.backends == nil {
+			ms.backends = map[string]Backend{}
+		}
+		for _, index := range indexes {
+			ms.backends[index] = backend
+		}
+	}
+}
+
+// Backend used for an index
+func (ms *Memstore) backendOf(index string) Backend {
+	if backend, ok := ms.backends[index]; ok {
+		return backend
+	}
+	return ms.defaultBackend
+}
+
+func newOrderedIndex(backend Backend, index string) OrderedIndex {
+	switch backend {
+	case BTreeBackend:
+		return newBTreeIndex(index)
+	case SkipListBackend:
+		return newSkipListIndex(index)
+	default:
+		return &llrbIndex{LLRB: llrb.New(index), index: index}
+	}
+}
+
+/*
+	LLRB backend
+*/
+type llrbIndex struct {
+	*llrb.LLRB
+	index string
+}
+
+func (t *llrbIndex) clear() {
+	t.LLRB = llrb.New(t.index)
+}
+
+func (t *llrbIndex) height() int {
+	return llrbHeight(t.Root())
+}
+
+func llrbHeight(node *llrb.Node) int {
+	if node == nil {
+		return 0
+	}
+	left, right := llrbHeight(node.Left), llrbHeight(node.Right)
+	if left > right {
+		return left + 1
+	}
+	return right + 1
+}
+
+// Check left-leaning red-black invariants
+func (t *llrbIndex) check(ir *IndexReport) {
+	// Root link is black
+	root := t.Root()
+	if root != nil && !root.Black {
+		ir.addStructureViolation("root is red")
+	}
+	checkLLRBNode(root, ir)
+}
+
+func isRed(node *llrb.Node) bool {
+	return node != nil && !node.Black
+}
+
+// Check invariants of a subtree, returns its black height
+func checkLLRBNode(node *llrb.Node, ir *IndexReport) int {
+	if node == nil {
+		return 0
+	}
+
+	if isRed(node.Right) {
+		ir.addStructureViolation(fmt.Sprintf("right link of %+v is red", node.Item.(*internalItem).value))
+	}
+	if isRed(node) && isRed(node.Left) {
+		ir.addStructureViolation(fmt.Sprintf("%+v and its left child are both red", node.Item.(*internalItem).value))
+	}
+
+	left := checkLLRBNode(node.Left, ir)
+	right := checkLLRBNode(node.Right, ir)
+	if left != right {
+		ir.addStructureViolation(fmt.Sprintf("subtrees of %+v have different black heights", node.Item.(*internalItem).value))
+	}
+
+	if node.Black {
+		return left + 1
+	}
+	return left
+}
@@ -0,0 +1,265 @@
+package memstore
+
+import (
+	"github.com/mngharbi/GoLLRB/llrb"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+var backends = []Backend{LLRBBackend, BTreeBackend, SkipListBackend}
+
+func conformanceItem(id int, name string) llrb.Item {
+	var x Item = TestStruct{id: id, name: name}
+	return makeInternalItem(x)
+}
+
+func conformanceID(it llrb.Item) int {
+	if it == nil {
+		return -1
+	}
+	return it.(*internalItem).value.(TestStruct).id
+}
+
+// Check every method of an index against a sorted slice of ids
+func checkConformance(t *testing.T, backend Backend, tree OrderedIndex, ids []int) {
+	if tree.Len() != len(ids) {
+		t.Fatalf("%v: Len is wrong, len=%v expected=%v", backend, tree.Len(), len(ids))
+	}
+
+	var ir IndexReport
+	tree.check(&ir)
+	if !ir.OK() {
+		t.Fatalf("%v: Structure is broken, problems=%v", backend, ir.Problems)
+	}
+
+	if len(ids) == 0 {
+		if tree.Min() != nil || tree.Max() != nil {
+			t.Errorf("%v: Empty index should have no min or max", backend)
+		}
+		return
+	}
+	if conformanceID(tree.Min()) != ids[0] || conformanceID(tree.Max()) != ids[len(ids)-1] {
+		t.Errorf("%v: Min or max is wrong, min=%v max=%v", backend, conformanceID(tree.Min()), conformanceID(tree.Max()))
+	}
+
+	ascended := []int{}
+	tree.AscendGreaterOrEqual(makeSentinelItem(-1), func(it llrb.Item) bool {
+		ascended = append(ascended, conformanceID(it))
+		return true
+	})
+	if !isSameIntSlice(ascended, ids) {
+		t.Errorf("%v: Ascending is wrong, ascended=%v expected=%v", backend, ascended, ids)
+	}
+
+	descended := []int{}
+	tree.DescendLessOrEqual(makeSentinelItem(1), func(it llrb.Item) bool {
+		descended = append(descended, conformanceID(it))
+		return true
+	})
+	for i, j := 0, len(descended)-1; i < j; i, j = i+1, j-1 {
+		descended[i], descended[j] = descended[j], descended[i]
+	}
+	if !isSameIntSlice(descended, ids) {
+		t.Errorf("%v: Descending is wrong, descended=%v expected=%v", backend, descended, ids)
+	}
+
+	for _, pivot := range []int{ids[0] - 1, ids[len(ids)/2], ids[len(ids)/2] + 1, ids[len(ids)-1] + 1} {
+		first := sort.SearchInts(ids, pivot)
+		last := sort.SearchInts(ids, pivot+1) - 1
+
+		// Ascend a few items from pivot
+		ascended = []int{}
+		tree.AscendGreaterOrEqual(conformanceItem(pivot, ""), func(it llrb.Item) bool {
+			ascended = append(ascended, conformanceID(it))
+			return len(ascended) < 3
+		})
+		expected := []int{}
+		for i := first; i < len(ids) && len(expected) < 3; i++ {
+			expected = append(expected, ids[i])
+		}
+		if !isSameIntSlice(ascended, expected) {
+			t.Errorf("%v: Ascending from %v is wrong, ascended=%v expected=%v", backend, pivot, ascended, expected)
+		}
+
+		// Descend a few items from pivot
+		descended = []int{}
+		tree.DescendLessOrEqual(conformanceItem(pivot, ""), func(it llrb.Item) bool {
+			descended = append(descended, conformanceID(it))
+			return len(descended) < 3
+		})
+		expected = []int{}
+		for i := last; i >= 0 && len(expected) < 3; i-- {
+			expected = append(expected, ids[i])
+		}
+		if !isSameIntSlice(descended, expected) {
+			t.Errorf("%v: Descending from %v is wrong, descended=%v expected=%v", backend, pivot, descended, expected)
+		}
+	}
+}
+
+func isSameIntSlice(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestOrderedIndexConformance(t *testing.T) {
+	for _, backend := range backends {
+		tree := newOrderedIndex(backend, "id")
+		present := map[int]bool{}
+		r := rand.New(rand.NewSource(1))
+
+		// Enough items for B-trees three levels deep
+		for round := 0; round < 30000; round++ {
+			id := r.Intn(10000)
+			switch r.Intn(3) {
+			case 0, 1:
+				replaced := tree.ReplaceOrInsert(conformanceItem(id, "new"))
+				if (replaced != nil) != present[id] || (replaced != nil && conformanceID(replaced) != id) {
+					t.Fatalf("%v: Inserting %v replaced %v", backend, id, conformanceID(replaced))
+				}
+				present[id] = true
+			default:
+				deleted := tree.Delete(conformanceItem(id, ""))
+				if (deleted != nil) != present[id] || (deleted != nil && conformanceID(deleted) != id) {
+					t.Fatalf("%v: Deleting %v returned %v", backend, id, conformanceID(deleted))
+				}
+				delete(present, id)
+			}
+
+			found := tree.Get(conformanceItem(id, ""))
+			if (found != nil) != present[id] {
+				t.Fatalf("%v: Getting %v returned %v", backend, id, conformanceID(found))
+			}
+			if found != nil && found.(*internalItem).value.(TestStruct).name != "new" {
+				t.Fatalf("%v: Getting %v returned stale item", backend, id)
+			}
+
+			if round%3000 == 0 {
+				ids := []int{}
+				for id := range present {
+					ids = append(ids, id)
+				}
+				sort.Ints(ids)
+				checkConformance(t, backend, tree, ids)
+			}
+		}
+
+		// Empty index completely
+		for id := range present {
+			tree.Delete(conformanceItem(id, ""))
+		}
+		checkConformance(t, backend, tree, nil)
+
+		// Clear drops every item
+		for id := 0; id < 100; id++ {
+			tree.ReplaceOrInsert(conformanceItem(id, "new"))
+		}
+		if tree.height() == 0 {
+			t.Errorf("%v: Height of non-empty index should be positive", backend)
+		}
+		tree.clear()
+		checkConformance(t, backend, tree, nil)
+		tree.ReplaceOrInsert(conformanceItem(1, "new"))
+		checkConformance(t, backend, tree, []int{1})
+	}
+}
+
+func TestBackendStore(t *testing.T) {
+	for _, backend := range backends {
+		ms := New([]string{"id", "importance"}, WithBackend(backend))
+		for _, v := range shuffeledTestData() {
+			var vItem Item = v
+			ms.Add(vItem)
+		}
+
+		if ms.Len() != len(testData()) || ms.Min("importance").(TestStruct).id != 4 || ms.Max("id").(TestStruct).id != 9 {
+			t.Errorf("%v: Store has wrong contents", backend)
+		}
+
+		var from, to Item = TestStruct{importance: 2}, TestStruct{importance: 3.2}
+		res := getRangeBoundsResult(ms, NewRange(Inclusive(from), Exclusive(to)), "importance")
+		if !reflect.DeepEqual(res, []TestStruct{{2, 2, "y"}, {1, 3, "x"}, {9, 3.1, "v"}}) {
+			t.Errorf("%v: Range is wrong, res=%v", backend, res)
+		}
+
+		ms.Delete(TestStruct{id: 3}, "id")
+		ms.UpdateWithIndexes(TestStruct{id: 9}, "id", dataModifierFuncWithIndex)
+		if ms.Get(TestStruct{importance: 5}, "importance") != nil || ms.Min("id").(TestStruct).id != -1 {
+			t.Errorf("%v: Deleted item should be gone and updated one moved", backend)
+		}
+
+		if report := ms.Verify(); !report.OK() {
+			t.Errorf("%v: Store should verify, report=%+v", backend, report)
+		}
+		if !ms.Rebuild("importance") || !ms.Verify().OK() || ms.Len() != len(testData())-1 {
+			t.Errorf("%v: Rebuilt store should verify", backend)
+		}
+	}
+}
+
+func TestBackendPerIndex(t *testing.T) {
+	ms := New([]string{"id", "importance", "name"}, WithBackend(BTreeBackend), WithBackend(SkipListBackend, "name"))
+
+	if _, ok := ms.indexTree["id"].(*btreeIndex); !ok {
+		t.Errorf("Default backend should be used for index, tree=%T", ms.indexTree["id"])
+	}
+	if _, ok := ms.indexTree["name"].(*skipListIndex); !ok {
+		t.Errorf("Backend of index should be used, tree=%T", ms.indexTree["name"])
+	}
+
+	ms = New([]string{"id"})
+	if _, ok := ms.indexTree["id"].(*llrbIndex); !ok {
+		t.Errorf("LLRB should be used by default, tree=%T", ms.indexTree["id"])
+	}
+}
+
+func TestSkipListDescend(t *testing.T) {
+	comparisons := 0
+	tree := newOrderedIndex(SkipListBackend, "id")
+	for id := 0; id < 10000; id++ {
+		var x Item = countedItem{id, 0, &comparisons}
+		tree.ReplaceOrInsert(makeInternalItem(x))
+	}
+
+	// Finding the pivot takes O(log n) comparisons, stepping back none
+	comparisons = 0
+	var pivot Item = countedItem{9999, 0, &comparisons}
+	count := 0
+	tree.DescendLessOrEqual(makeInternalItem(pivot), func(llrb.Item) bool {
+		count++
+		return true
+	})
+	if count != 10000 || comparisons > 500 {
+		t.Errorf("Descending skip list failed, count=%v comparisons=%v", count, comparisons)
+	}
+}
+
+func TestVerifyBTree(t *testing.T) {
+	ms := New([]string{"id"}, WithBackend(BTreeBackend))
+	for id := 0; id < 200; id++ {
+		var vItem Item = TestStruct{id: id}
+		ms.Add(vItem)
+	}
+
+	// Take items out of a leaf behind the store's back
+	tree := ms.indexTree["id"].(*btreeIndex)
+	leaf := tree.root
+	for len(leaf.children) > 0 {
+		leaf = leaf.children[0]
+	}
+	leaf.items = leaf.items[:1]
+
+	ir := indexReport(ms.Verify(), "id")
+	if ir.StructureViolations == 0 {
+		t.Errorf("Verifying should report B-tree violations, report=%+v", ir)
+	}
+}
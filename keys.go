@@ -0,0 +1,125 @@
+package memstore
+
+import (
+	"bytes"
+	"reflect"
+	"time"
+)
+
+/*
+	Item exposing the raw value it's ordered by for every index
+
+	Ordering of keys must agree with Less for the same index
+	Required for anything built from raw values instead of items (queries, key bounds...)
+*/
+type KeyedItem interface {
+	Item
+	Key(index string) interface{}
+}
+
+// Get raw key of an item for an index, nil if item doesn't expose keys
+func keyOf(x Item, index string) interface{} {
+	keyed, ok := x.(KeyedItem)
+	if !ok {
+		return nil
+	}
+	return keyed.Key(index)
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+/*
+	Compare two raw keys
+
+	Supports numbers of any kind (compared by value), strings, byte slices and times
+	Second return value is false if keys can't be compared
+*/
+func compareKeys(a, b interface{}) (int, bool) {
+	if a == nil || b == nil {
+		return 0, false
+	}
+
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+
+	// Times
+	if va.Type() == timeType || vb.Type() == timeType {
+		if va.Type() != vb.Type() {
+			return 0, false
+		}
+		ta, tb := a.(time.Time), b.(time.Time)
+		switch {
+		case ta.Before(tb):
+			return -1, true
+		case ta.After(tb):
+			return 1, true
+		default:
+			return 0, true
+		}
+	}
+
+	switch {
+	case isString(va) && isString(vb):
+		return threeWay(va.String() < vb.String(), va.String() > vb.String()), true
+	case isBytes(va) && isBytes(vb):
+		return bytes.Compare(va.Bytes(), vb.Bytes()), true
+	case isInt(va) && isInt(vb):
+		return threeWay(va.Int() < vb.Int(), va.Int() > vb.Int()), true
+	case isUint(va) && isUint(vb):
+		return threeWay(va.Uint() < vb.Uint(), va.Uint() > vb.Uint()), true
+	case isNumber(va) && isNumber(vb):
+		return threeWay(toFloat(va) < toFloat(vb), toFloat(va) > toFloat(vb)), true
+	default:
+		return 0, false
+	}
+}
+
+// Turn results of strict comparisons into -1, 0 or 1
+func threeWay(less, greater bool) int {
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func isString(v reflect.Value) bool {
+	return v.Kind() == reflect.String
+}
+
+func isBytes(v reflect.Value) bool {
+	return v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8
+}
+
+func isInt(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	}
+	return false
+}
+
+func isUint(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return false
+}
+
+func isNumber(v reflect.Value) bool {
+	return isInt(v) || isUint(v) || v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64
+}
+
+func toFloat(v reflect.Value) float64 {
+	switch {
+	case isInt(v):
+		return float64(v.Int())
+	case isUint(v):
+		return float64(v.Uint())
+	default:
+		return v.Float()
+	}
+}
@@ -3,6 +3,8 @@ package memstore
 import (
 	"math/rand"
 	"reflect"
+	"runtime"
+	"sync"
 	"testing"
 )
 
@@ -26,6 +28,20 @@ func (ts TestStruct) Less(index string, than interface{}) bool {
 	}
 }
 
+// Raw keys for every index
+func (ts TestStruct) Key(index string) interface{} {
+	switch index {
+	case "id":
+		return ts.id
+	case "importance":
+		return ts.importance
+	case "name":
+		return ts.name
+	default:
+		return nil
+	}
+}
+
 func testData() []TestStruct {
 	return []TestStruct{
 		{1, 3, "x"},
@@ -122,6 +138,42 @@ func TestAddOrGetOneIndex(t *testing.T) {
 	}
 }
 
+func TestPutUnique(t *testing.T) {
+	ms := New([]string{"id", "name", "importance"}, WithUnique("name"))
+	for _, v := range testData() {
+		if err := ms.Put(v); err != nil {
+			t.Errorf("Putting new item failed. item=%+v err=%v", v, err)
+		}
+	}
+
+	// Replacing an item keeps its identity and moves it within trees
+	before := getFromTree(makeInternalItem(TestStruct{id: 1}), ms.trees[0])
+	if err := ms.Put(TestStruct{1, 7, "w"}); err != nil {
+		t.Errorf("Putting over an item failed. err=%v", err)
+	}
+	after := getFromTree(makeInternalItem(TestStruct{id: 1}), ms.trees[0])
+	if after.id != before.id || ms.Get(TestStruct{name: "x"}, "name") != nil || ms.Get(TestStruct{name: "w"}, "name") == nil {
+		t.Errorf("Putting over an item didn't replace it. before=%+v after=%+v", before, after)
+	}
+
+	// Taking the name of another item is rejected, whatever the write
+	if err := ms.Put(TestStruct{2, 2, "z"}); err != ErrDuplicate {
+		t.Errorf("Putting onto a unique key of another item should fail. err=%v", err)
+	}
+	ms.Add(TestStruct{5, 1, "z"})
+	if ms.Len() != len(testData()) || ms.Stats().RejectedWrites != 1 {
+		t.Errorf("Adding onto a unique key of another item should be left out. len=%v stats=%+v", ms.Len(), ms.Stats())
+	}
+	if found := ms.Get(TestStruct{name: "z"}, "name"); found.(TestStruct).id != 3 {
+		t.Errorf("Item holding a unique key was replaced. found=%+v", found)
+	}
+
+	follower := New([]string{"id"}, AsFollower())
+	if err := follower.Put(TestStruct{id: 1}); err != ErrReadOnly || follower.Len() != 0 {
+		t.Errorf("Putting to a follower should fail. err=%v", err)
+	}
+}
+
 /*
 	Delete
 */
@@ -435,6 +487,166 @@ func TestRangeEmptyMultipleIndex(t *testing.T) {
 	}
 }
 
+/*
+	GetRangeBounds
+*/
+
+func getRangeBoundsResult(ms *Memstore, r Range, index string) []TestStruct {
+	var res []TestStruct = make([]TestStruct, 0)
+
+	ms.GetRangeBounds(r, index, func(item Item) bool {
+		res = append(res, item.(TestStruct))
+		return true
+	})
+
+	return res
+}
+
+func TestGetRangeBoundsInvalidIndex(t *testing.T) {
+	data := shuffeledTestData()
+
+	ms := New([]string{"id"})
+	for _, v := range data {
+		var vItem Item = v
+		ms.Add(vItem)
+	}
+
+	res := getRangeBoundsResult(ms, All(), "notID")
+
+	if len(res) != 0 {
+		t.Error("Getting bounded range with unspecified index didn't fail")
+	}
+}
+
+func TestGetRangeBoundsInclusive(t *testing.T) {
+	data := shuffeledTestData()
+
+	ms := New([]string{"id", "importance"})
+	for _, v := range data {
+		var vItem Item = v
+		ms.Add(vItem)
+	}
+
+	r := NewRange(Inclusive(TestStruct{importance: 2}), Inclusive(TestStruct{importance: 3.2}))
+	res := getRangeBoundsResult(ms, r, "importance")
+	expected := importanceSortedData()[1:5]
+
+	if !reflect.DeepEqual(res, expected) {
+		t.Errorf("Get inclusive range failed, result = %v\n expected = %v\n", res, expected)
+	}
+}
+
+func TestGetRangeBoundsExclusive(t *testing.T) {
+	data := shuffeledTestData()
+
+	ms := New([]string{"id", "importance"})
+	for _, v := range data {
+		var vItem Item = v
+		ms.Add(vItem)
+	}
+
+	r := NewRange(Exclusive(TestStruct{importance: 2}), Exclusive(TestStruct{importance: 3.2}))
+	res := getRangeBoundsResult(ms, r, "importance")
+	expected := importanceSortedData()[2:4]
+
+	if !reflect.DeepEqual(res, expected) {
+		t.Errorf("Get exclusive range failed, result = %v\n expected = %v\n", res, expected)
+	}
+}
+
+func TestGetRangeBoundsUnbounded(t *testing.T) {
+	data := shuffeledTestData()
+
+	ms := New([]string{"id"})
+	for _, v := range data {
+		var vItem Item = v
+		ms.Add(vItem)
+	}
+
+	fromResult := getRangeBoundsResult(ms, NewRange(Inclusive(TestStruct{id: 3}), Unbounded()), "id")
+	if !reflect.DeepEqual(fromResult, idSortedData()[2:]) {
+		t.Errorf("Get range unbounded above failed, result = %v", fromResult)
+	}
+
+	toResult := getRangeBoundsResult(ms, NewRange(Unbounded(), Exclusive(TestStruct{id: 3})), "id")
+	if !reflect.DeepEqual(toResult, idSortedData()[:2]) {
+		t.Errorf("Get range unbounded below failed, result = %v", toResult)
+	}
+
+	allResult := getRangeBoundsResult(ms, All(), "id")
+	if !reflect.DeepEqual(allResult, idSortedData()) {
+		t.Errorf("Get range unbounded on both sides failed, result = %v", allResult)
+	}
+}
+
+func TestGetRangeBoundsEmpty(t *testing.T) {
+	data := shuffeledTestData()
+
+	ms := New([]string{"id"})
+	for _, v := range data {
+		var vItem Item = v
+		ms.Add(vItem)
+	}
+
+	r := NewRange(Exclusive(TestStruct{id: 3}), Exclusive(TestStruct{id: 4}))
+	res := getRangeBoundsResult(ms, r, "id")
+
+	if len(res) != 0 {
+		t.Error("Get range between adjacent exclusive bounds should be empty")
+	}
+}
+
+func TestGetRangeBoundsKeys(t *testing.T) {
+	ms := New([]string{"id"})
+	for _, v := range shuffeledTestData() {
+		var vItem Item = v
+		ms.Add(vItem)
+	}
+
+	var res []Item
+	err := ms.GetRangeBounds(NewRange(InclusiveKey(2), ExclusiveKey(int64(5))), "id", func(x Item) bool {
+		res = append(res, x)
+		return true
+	})
+	var expected []Item
+	for _, x := range idSortedData() {
+		if x.id >= 2 && x.id < 5 {
+			expected = append(expected, x)
+		}
+	}
+	if err != nil || !reflect.DeepEqual(res, expected) {
+		t.Errorf("Get range between keys failed, result = %v, err = %v", res, err)
+	}
+
+	// Keys of another kind don't silently match every item
+	all := func(Item) bool {
+		t.Error("Range with a key of another kind shouldn't match anything")
+		return true
+	}
+	if err := ms.GetRangeBounds(NewRange(InclusiveKey("2"), Unbounded()), "id", all); err != ErrKeyType {
+		t.Errorf("Key of another kind should fail, err=%v", err)
+	}
+	if ms.Aggregate("id", InclusiveKey("2"), Unbounded(), Count()) != nil {
+		t.Error("Aggregate with a key of another kind should fail")
+	}
+	if ms.Intersect("id", On("id", NewRange(Unbounded(), ExclusiveKey(nil)))) != nil {
+		t.Error("Intersection with a key of another kind should fail")
+	}
+	if _, err := ms.Query().Where("id", Between("a", "b")).Run(); err != ErrKeyType {
+		t.Errorf("Query with a key of another kind should fail, err=%v", err)
+	}
+
+	// Nor do keys of items that have none
+	plain := New([]string{"id0"})
+	plain.Add(BenchStruct{id0: 1})
+	if err := plain.GetRangeBounds(NewRange(InclusiveKey(0), Unbounded()), "id0", all); err != ErrNotKeyed {
+		t.Errorf("Key bounds on items without keys should fail, err=%v", err)
+	}
+	if err := ms.GetRangeBounds(All(), "notID", all); err != ErrUnknownIndex {
+		t.Errorf("Unknown index should fail, err=%v", err)
+	}
+}
+
 /*
 	Max
 */
@@ -647,6 +859,121 @@ func TestUpdateData(t *testing.T) {
 	}
 }
 
+func TestUpdateDataIndexChange(t *testing.T) {
+	data := shuffeledTestData()
+
+	ms := New([]string{"id", "importance"})
+	for _, v := range data {
+		var vItem Item = v
+		ms.Add(vItem)
+	}
+
+	var searchedRecord Item = TestStruct{id: 1}
+	result := ms.UpdateData(searchedRecord, "id", func(i Item) (Item, bool) {
+		itemCopy := i.(TestStruct)
+		itemCopy.importance = 100
+		return itemCopy, true
+	})
+
+	if result != nil {
+		t.Error("Update changing indexed field should fail")
+	}
+
+	var searchedRecordByImportance Item = TestStruct{importance: 3}
+	if ms.Get(searchedRecordByImportance, "importance") == nil {
+		t.Error("Update changing indexed field should leave item untouched")
+	}
+}
+
+func importanceModifierFunc(i Item) (Item, bool) {
+	itemCopy := i.(TestStruct)
+	itemCopy.importance = 100
+	return itemCopy, true
+}
+
+func TestUpdateErrors(t *testing.T) {
+	data := shuffeledTestData()
+
+	ms := New([]string{"id", "importance"})
+	for _, v := range data {
+		var vItem Item = v
+		ms.Add(vItem)
+	}
+
+	if _, err := ms.Update(TestStruct{id: 1}, "notID", dataModifierFunc); err != ErrUnknownIndex {
+		t.Errorf("Update with unspecified index should fail, err=%v", err)
+	}
+
+	if _, err := ms.Update(TestStruct{id: 100}, "id", dataModifierFunc); err != ErrNotFound {
+		t.Errorf("Update of inexistent record should fail, err=%v", err)
+	}
+
+	if _, err := ms.Update(TestStruct{id: 9}, "id", dataModifierFunc); err != ErrNotModified {
+		t.Errorf("Update rejected by modify function should fail, err=%v", err)
+	}
+
+	if _, err := ms.Update(TestStruct{id: 1}, "id", importanceModifierFunc); err != ErrIndexChanged {
+		t.Errorf("Update changing indexed field should fail, err=%v", err)
+	}
+
+	if result, err := ms.Update(TestStruct{id: 1}, "id", dataModifierFunc); err != nil || result.(TestStruct).name != "changed" {
+		t.Errorf("Update failed when it should succeed, err=%v", err)
+	}
+}
+
+func TestUpdateRepositionOnIndexChange(t *testing.T) {
+	data := shuffeledTestData()
+
+	ms := New([]string{"id", "importance"}, WithIndexChangePolicy(RepositionOnIndexChange))
+	for _, v := range data {
+		var vItem Item = v
+		ms.Add(vItem)
+	}
+
+	var searchedRecord Item = TestStruct{id: 1}
+	result := ms.UpdateData(searchedRecord, "id", importanceModifierFunc)
+
+	if result == nil || result.(TestStruct).importance != 100 {
+		t.Error("Update changing indexed field should succeed when repositioning")
+		return
+	}
+
+	var searchedRecordByImportance Item = TestStruct{importance: 100}
+	if ms.Get(searchedRecordByImportance, "importance") == nil ||
+		ms.Get(TestStruct{importance: 3}, "importance") != nil ||
+		ms.Max("importance").(TestStruct).id != 1 ||
+		ms.Len() != len(data) {
+		t.Error("Update changing indexed field should readjust tables")
+	}
+}
+
+func TestUpdateDataConcurrent(t *testing.T) {
+	ms := New([]string{"id"})
+	var vItem Item = TestStruct{id: 1}
+	ms.Add(vItem)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 20; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 100; n++ {
+				ms.UpdateData(vItem, "id", func(i Item) (Item, bool) {
+					itemCopy := i.(TestStruct)
+					itemCopy.importance++
+					return itemCopy, true
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	result := ms.Get(vItem, "id").(TestStruct)
+	if result.importance != 2000 {
+		t.Errorf("Concurrent updates were lost, importance=%v", result.importance)
+	}
+}
+
 /*
 	Apply Data
 */
@@ -818,6 +1145,107 @@ func TestApplyDataSubset(t *testing.T) {
 	}
 }
 
+// Item counting updates, replaced by a copy on every update
+type counterItem struct {
+	id    int
+	count int
+}
+
+func (ci *counterItem) Less(index string, than interface{}) bool {
+	return ci.id < than.(*counterItem).id
+}
+
+func TestApplyDataConcurrent(t *testing.T) {
+	ms := New([]string{"id"})
+	ms.Add(&counterItem{id: 1})
+
+	increment := func(i Item) (Item, bool) {
+		return &counterItem{id: 1, count: i.(*counterItem).count + 1}, true
+	}
+	read := func(i Item) bool {
+		return i.(*counterItem).count >= 0
+	}
+
+	// Applied functions only read, next to updates changing the same item
+	var wg sync.WaitGroup
+	for g := 0; g < 20; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 100; n++ {
+				ms.UpdateData(&counterItem{id: 1}, "id", increment)
+				ms.ApplyData(&counterItem{id: 1}, "id", read)
+				ms.ApplyDataSubset([]Item{&counterItem{id: 1}}, "id", read)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if count := ms.Get(&counterItem{id: 1}, "id").(*counterItem).count; count != 2000 {
+		t.Errorf("Concurrent updates were lost, count=%v", count)
+	}
+}
+
+/*
+	Compare and swap
+*/
+
+func TestCompareAndSwap(t *testing.T) {
+	data := shuffeledTestData()
+
+	ms := New([]string{"id", "importance"})
+	for _, v := range data {
+		var vItem Item = v
+		ms.Add(vItem)
+	}
+
+	var old, new Item = TestStruct{1, 3, "x"}, TestStruct{1, 3, "changed"}
+
+	if ms.CompareAndSwap(TestStruct{1, 3, "outdated"}, new, "id") {
+		t.Error("Compare and swap should fail if stored item differs")
+	}
+
+	if !ms.CompareAndSwap(old, new, "id") {
+		t.Error("Compare and swap failed when it should succeed")
+	}
+
+	if ms.CompareAndSwap(old, new, "id") {
+		t.Error("Compare and swap should fail once item was swapped")
+	}
+
+	var searchedRecordByImportance Item = TestStruct{importance: 3}
+	if ms.Get(searchedRecordByImportance, "importance").(TestStruct).name != "changed" {
+		t.Error("Compare and swap did not propagate to other index trees")
+	}
+
+	if ms.CompareAndSwap(TestStruct{id: 100}, new, "id") || ms.CompareAndSwap(old, new, "notID") {
+		t.Error("Compare and swap should fail if record or index don't exist")
+	}
+}
+
+func TestCompareAndSwapIndexChange(t *testing.T) {
+	data := shuffeledTestData()
+
+	ms := New([]string{"id", "importance"})
+	for _, v := range data {
+		var vItem Item = v
+		ms.Add(vItem)
+	}
+
+	var old, new Item = TestStruct{9, 3.1, "v"}, TestStruct{-1, 10, "v"}
+
+	if !ms.CompareAndSwap(old, new, "importance") {
+		t.Error("Compare and swap changing indexes failed when it should succeed")
+	}
+
+	if ms.Len() != len(data) ||
+		!reflect.DeepEqual(ms.Min("id"), new) ||
+		!reflect.DeepEqual(ms.Max("importance"), new) ||
+		ms.Get(old, "importance") != nil {
+		t.Error("Compare and swap changing indexes should readjust tables")
+	}
+}
+
 /*
 	Benchmarks
 */
@@ -865,6 +1293,7 @@ func makeRandBenchStruct() *BenchStruct {
 }
 
 func BenchmarkOneIndexInsert(b *testing.B) {
+	b.ReportAllocs()
 	ms := New([]string{"id0"})
 
 	for n := 0; n < b.N; n++ {
@@ -874,6 +1303,7 @@ func BenchmarkOneIndexInsert(b *testing.B) {
 }
 
 func BenchmarkSevenIndexInsert(b *testing.B) {
+	b.ReportAllocs()
 	ms := New([]string{"id0", "id1", "id2", "id3", "id4", "id5", "id6"})
 
 	for n := 0; n < b.N; n++ {
@@ -882,15 +1312,34 @@ func BenchmarkSevenIndexInsert(b *testing.B) {
 	}
 }
 
+// Heap kept per item stored, value and tree nodes included
+func BenchmarkOneIndexFootprint(b *testing.B) {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	ms := New([]string{"id0"})
+	for n := 0; n < b.N; n++ {
+		ms.Add(BenchStruct{id0: n})
+	}
+
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	b.ReportMetric(float64(int64(after.HeapAlloc)-int64(before.HeapAlloc))/float64(b.N), "B/item")
+	runtime.KeepAlive(ms)
+}
+
 func BenchmarkOneIndexFind(b *testing.B) {
 	ms := New([]string{"id0"})
 
-	record := makeRandBenchStruct()
+	// Look up the last record added
+	var record *BenchStruct
 	for n := 0; n < 100000; n++ {
-		ms.Add(*record)
 		record = makeRandBenchStruct()
+		ms.Add(*record)
 	}
 
+	b.ReportAllocs()
 	b.ResetTimer()
 
 	var res Item
@@ -903,12 +1352,14 @@ func BenchmarkOneIndexFind(b *testing.B) {
 func BenchmarkSevenIndexFind(b *testing.B) {
 	ms := New([]string{"id0", "id1", "id2", "id3", "id4", "id5", "id6"})
 
-	record := makeRandBenchStruct()
+	// Look up the last record added
+	var record *BenchStruct
 	for n := 0; n < 100000; n++ {
-		ms.Add(*record)
 		record = makeRandBenchStruct()
+		ms.Add(*record)
 	}
 
+	b.ReportAllocs()
 	b.ResetTimer()
 
 	var res Item
@@ -0,0 +1,277 @@
+package memstore
+
+import (
+	"github.com/mngharbi/GoLLRB/llrb"
+	"time"
+)
+
+/*
+	Hybrid logical clock reading, ordered by wall clock, then logical counter, then origin
+
+	Readings of a clock always increase, even if the wall clock goes back, and are above every reading it observed.
+*/
+type Timestamp struct {
+	// Nanoseconds since the Unix epoch
+	Wall    int64
+	Logical uint32
+	Origin  string
+}
+
+func (t Timestamp) Less(than Timestamp) bool {
+	switch {
+	case t.Wall != than.Wall:
+		return t.Wall < than.Wall
+	case t.Logical != than.Logical:
+		return t.Logical < than.Logical
+	default:
+		return t.Origin < than.Origin
+	}
+}
+
+type hybridClock struct {
+	origin string
+	last   Timestamp
+
+	// Source of wall clock readings
+	wall func() int64
+}
+
+func (c *hybridClock) now() Timestamp {
+	if wall := c.wall(); wall > c.last.Wall {
+		c.last = Timestamp{Wall: wall}
+	} else {
+		c.last.Logical++
+	}
+	c.last.Origin = c.origin
+	return c.last
+}
+
+// Move clock past a reading of another replica
+func (c *hybridClock) observe(t Timestamp) {
+	if t.Wall > c.last.Wall || (t.Wall == c.last.Wall && t.Logical > c.last.Logical) {
+		c.last.Wall = t.Wall
+		c.last.Logical = t.Logical
+	}
+}
+
+/*
+	Last write of a primary key: the item written, or deleted if it's a tombstone
+*/
+type MergeEntry struct {
+	Item    Item
+	Stamp   Timestamp
+	Deleted bool
+}
+
+/*
+	Entries of a replica changed after a position, and the position to ask for later changes
+*/
+type MergeState struct {
+	Seq     uint64
+	Entries []MergeEntry
+}
+
+// Entry kept in a tree ordered like the primary index
+type mergeEntry struct {
+	MergeEntry
+
+	// Position of the last change to the entry
+	changed uint64
+}
+
+func (e *mergeEntry) Less(index string, than llrb.Item) bool {
+	return e.Item.Less(index, than.(*mergeEntry).Item)
+}
+
+/*
+	Last write of every primary key, including deletes
+*/
+type mergeState struct {
+	clock   hybridClock
+	entries OrderedIndex
+
+	// Number of changes to entries
+	changes uint64
+
+	// Remote write being applied, if any
+	incoming *MergeEntry
+}
+
+/*
+	Make the store mergeable with other replicas (see Merge), writes being stamped with a clock of a replica
+
+	Each replica needs its own origin. Primary keys identify items across replicas and the last write of a key wins,
+	deletes leaving tombstones until pruned (see PruneTombstones).
+*/
+func WithMerge(origin string) Option {
+	return func(ms *Memstore) {
+		ms.merge = &mergeState{
+			clock: hybridClock{
+				origin: origin,
+				wall: func() int64 {
+					return time.Now().UnixNano()
+				},
+			},
+		}
+	}
+}
+
+// Create tree of entries once the primary index is known
+func (state *mergeState) init(primary string) {
+	state.entries = newOrderedIndex(LLRBBackend, primary)
+}
+
+// Keep last write of a primary key, stamped now unless it's a remote one kept as it is (expects a write lock)
+func (state *mergeState) stamp(x Item, deleted bool) {
+	state.changes++
+
+	entry := &mergeEntry{changed: state.changes}
+	if state.incoming != nil {
+		entry.MergeEntry = *state.incoming
+	} else {
+		entry.MergeEntry = MergeEntry{Item: x, Stamp: state.clock.now(), Deleted: deleted}
+	}
+	state.entries.ReplaceOrInsert(entry)
+}
+
+// Forget every entry, keeping the position so that those other replicas were given still hold
+func (state *mergeState) reset() {
+	state.entries.clear()
+}
+
+func (state *mergeState) ascend(iterator func(*mergeEntry) bool) {
+	min := state.entries.Min()
+	if min == nil {
+		return
+	}
+	state.entries.AscendGreaterOrEqual(min, func(it llrb.Item) bool {
+		return iterator(it.(*mergeEntry))
+	})
+}
+
+func (state *mergeState) find(x Item) *mergeEntry {
+	found := state.entries.Get(&mergeEntry{MergeEntry: MergeEntry{Item: x}})
+	if found == nil {
+		return nil
+	}
+	return found.(*mergeEntry)
+}
+
+/*
+	Entries changed after a position (0 for all of them), in primary key order
+
+	Walks every entry. Changes merged from other replicas are included, so that deltas propagate.
+*/
+func (ms *Memstore) Delta(since uint64) (delta MergeState) {
+	op := ms.begin(opDelta, "")
+	defer ms.end(op)
+
+	if ms.merge == nil {
+		return delta
+	}
+
+	ms.rlock(op)
+	defer ms.runlock(op)
+
+	delta.Seq = ms.merge.changes
+	ms.merge.ascend(func(entry *mergeEntry) bool {
+		if entry.changed > since {
+			delta.Entries = append(delta.Entries, entry.MergeEntry)
+		}
+		return true
+	})
+	op.addItems(len(delta.Entries))
+
+	return delta
+}
+
+/*
+	Merge entries of another replica, keeping the last write of every primary key
+
+	Merging is commutative, associative and idempotent: replicas that merged the same writes hold the same items.
+	Returns the number of entries that won, none for followers.
+*/
+func (ms *Memstore) ApplyDelta(delta MergeState) (merged int) {
+	op := ms.begin(opApplyDelta, "")
+	defer ms.end(op)
+
+	if ms.merge == nil || ms.rejectsWrites() {
+		return 0
+	}
+
+	primary := ms.indexes[0]
+
+	ms.lock(op)
+	defer ms.unlock(op)
+
+	for i := range delta.Entries {
+		remote := &delta.Entries[i]
+		ms.merge.clock.observe(remote.Stamp)
+
+		if local := ms.merge.find(remote.Item); local != nil && !local.Stamp.Less(remote.Stamp) {
+			continue
+		}
+
+		ms.merge.incoming = remote
+		ix := makeProbe(remote.Item)
+		existing := getFromTree(ix, ms.trees[0])
+		switch {
+		case remote.Deleted && existing != nil:
+			ms.deleteEverywhere(ix, primary)
+		case remote.Deleted:
+			ms.merge.stamp(remote.Item, true)
+		case existing == nil:
+			ii := &internalItem{value: remote.Item}
+			ms.identify(ii)
+			ms.insert(ii)
+		case ms.indexesChanged(existing.value, remote.Item):
+			ms.reposition(existing, remote.Item)
+		default:
+			existing.value = remote.Item
+			ms.refresh(existing)
+		}
+		releaseProbe(ix)
+		ms.merge.incoming = nil
+
+		merged++
+	}
+	op.addItems(merged)
+
+	return merged
+}
+
+// Merge every entry of another replica (see ApplyDelta)
+func (ms *Memstore) Merge(other *Memstore) int {
+	return ms.ApplyDelta(other.Delta(0))
+}
+
+/*
+	Forget tombstones of deletes older than a time, returns the number forgotten
+
+	Every replica should have merged those deletes, as merging a replica that didn't brings the items back.
+*/
+func (ms *Memstore) PruneTombstones(before time.Time) int {
+	op := ms.begin(opPruneTombstones, "")
+	defer ms.end(op)
+
+	if ms.merge == nil {
+		return 0
+	}
+
+	ms.lock(op)
+	defer ms.unlock(op)
+
+	var pruned []*mergeEntry
+	ms.merge.ascend(func(entry *mergeEntry) bool {
+		if entry.Deleted && entry.Stamp.Wall < before.UnixNano() {
+			pruned = append(pruned, entry)
+		}
+		return true
+	})
+	for _, entry := range pruned {
+		ms.merge.entries.Delete(entry)
+	}
+	op.addItems(len(pruned))
+
+	return len(pruned)
+}
@@ -0,0 +1,242 @@
+package memstore
+
+import (
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Replica whose wall clock only moves when told to
+func testReplica(origin string, wall *int64) *Memstore {
+	ms := New([]string{"id", "name"}, WithMerge(origin))
+	ms.merge.clock.wall = func() int64 {
+		return *wall
+	}
+	return ms
+}
+
+// Items of a replica in every index
+func replicaItems(ms *Memstore) [][]Item {
+	var res [][]Item
+	for _, index := range []string{"id", "name"} {
+		var items []Item
+		ms.GetRangeBounds(All(), index, func(x Item) bool {
+			items = append(items, x)
+			return true
+		})
+		res = append(res, items)
+	}
+	return res
+}
+
+func TestTimestampOrder(t *testing.T) {
+	ordered := []Timestamp{{1, 5, "b"}, {2, 0, "b"}, {2, 1, "a"}, {2, 1, "b"}, {3, 0, ""}}
+	for i, a := range ordered {
+		for j, b := range ordered {
+			if a.Less(b) != (i < j) {
+				t.Errorf("Comparing %v and %v is wrong", a, b)
+			}
+		}
+	}
+
+	// Readings increase even if the wall clock doesn't, and follow observed ones
+	var wall int64 = 10
+	c := hybridClock{origin: "a", wall: func() int64 { return wall }}
+	first := c.now()
+	second := c.now()
+	c.observe(Timestamp{20, 3, "b"})
+	third := c.now()
+	wall = 30
+	fourth := c.now()
+	if first != (Timestamp{10, 0, "a"}) || second != (Timestamp{10, 1, "a"}) || third != (Timestamp{20, 4, "a"}) || fourth != (Timestamp{30, 0, "a"}) {
+		t.Errorf("Clock readings are wrong, readings=%v %v %v %v", first, second, third, fourth)
+	}
+}
+
+func TestMergeLastWriterWins(t *testing.T) {
+	var wall int64 = 1
+	a := testReplica("a", &wall)
+	b := testReplica("b", &wall)
+
+	a.Add(TestStruct{1, 1, "first"})
+	a.Add(TestStruct{2, 1, "kept"})
+	b.Merge(a)
+
+	// Concurrent writes of the same key at the same time are ordered by origin
+	a.UpdateData(TestStruct{id: 1}, "id", func(x Item) (Item, bool) {
+		return TestStruct{1, 1, "from a"}, true
+	})
+	b.UpdateWithIndexes(TestStruct{id: 1}, "id", func(x Item) (Item, bool) {
+		return TestStruct{1, 1, "from b"}, true
+	})
+
+	// Delete wins over older writes
+	wall = 2
+	b.Delete(TestStruct{id: 2}, "id")
+	wall = 3
+	a.Add(TestStruct{3, 1, "new"})
+
+	if merged := a.Merge(b); merged != 2 {
+		t.Errorf("Merge should take the write and delete of b, merged=%v", merged)
+	}
+	b.Merge(a)
+
+	expected := [][]Item{
+		{TestStruct{1, 1, "from b"}, TestStruct{3, 1, "new"}},
+		{TestStruct{1, 1, "from b"}, TestStruct{3, 1, "new"}},
+	}
+	if !reflect.DeepEqual(replicaItems(a), expected) || !reflect.DeepEqual(replicaItems(b), expected) {
+		t.Errorf("Replicas should converge, a=%v b=%v", replicaItems(a), replicaItems(b))
+	}
+
+	// Merging again changes nothing
+	if a.Merge(b) != 0 || b.Merge(a) != 0 {
+		t.Error("Merging should be idempotent")
+	}
+
+	// Write after a delete brings item back
+	wall = 4
+	b.Add(TestStruct{2, 1, "back"})
+	a.Merge(b)
+	if a.Get(TestStruct{id: 2}, "id") == nil {
+		t.Error("Item written after delete should be merged")
+	}
+
+	// Followers only change through their leader
+	follower := New([]string{"id", "name"}, WithMerge("f"), AsFollower())
+	if follower.Merge(a) != 0 || follower.Len() != 0 {
+		t.Error("Follower shouldn't merge other replicas")
+	}
+}
+
+func TestMergeKeyChange(t *testing.T) {
+	var wall int64 = 1
+	a := testReplica("a", &wall)
+	b := testReplica("b", &wall)
+
+	a.Add(TestStruct{1, 1, "x"})
+	b.Merge(a)
+
+	// Moving item to another primary key deletes the previous one
+	wall = 2
+	a.UpdateWithIndexes(TestStruct{id: 1}, "id", func(x Item) (Item, bool) {
+		return TestStruct{5, 1, "x"}, true
+	})
+	b.Merge(a)
+	if b.Len() != 1 || b.Get(TestStruct{id: 5}, "id") == nil {
+		t.Errorf("Key change should be merged, items=%v", replicaItems(b))
+	}
+}
+
+func TestMergeConvergence(t *testing.T) {
+	var wall int64
+	replicas := []*Memstore{testReplica("a", &wall), testReplica("b", &wall), testReplica("c", &wall)}
+	positions := make([]map[int]uint64, len(replicas))
+	for i := range positions {
+		positions[i] = map[int]uint64{}
+	}
+
+	rng := rand.New(rand.NewSource(7))
+	for step := 0; step < 3000; step++ {
+		if rng.Intn(4) == 0 {
+			wall++
+		}
+		ms := replicas[rng.Intn(len(replicas))]
+		id := rng.Intn(50)
+		switch rng.Intn(5) {
+		case 0, 1:
+			ms.Delete(TestStruct{id: id}, "id")
+			ms.Add(TestStruct{id, float32(step), "v" + strconv.Itoa(step)})
+		case 2:
+			ms.Delete(TestStruct{id: id}, "id")
+		case 3:
+			ms.UpdateData(TestStruct{id: id}, "id", func(x Item) (Item, bool) {
+				updated := x.(TestStruct)
+				updated.importance++
+				return updated, true
+			})
+		default:
+			// Exchange deltas since last exchange between a pair of replicas
+			to := rng.Intn(len(replicas))
+			from := rng.Intn(len(replicas))
+			delta := replicas[from].Delta(positions[to][from])
+			replicas[to].ApplyDelta(delta)
+			positions[to][from] = delta.Seq
+		}
+	}
+
+	// Full merges in any order give the same items everywhere
+	replicas[0].Merge(replicas[1])
+	replicas[2].Merge(replicas[0])
+	replicas[1].Merge(replicas[2])
+	replicas[0].Merge(replicas[2])
+
+	expected := replicaItems(replicas[0])
+	for i, ms := range replicas {
+		if !reflect.DeepEqual(replicaItems(ms), expected) {
+			t.Errorf("Replica %v didn't converge", i)
+		}
+		if !reflect.DeepEqual(ms.Delta(0).Entries, replicas[0].Delta(0).Entries) {
+			t.Errorf("Replica %v has different entries", i)
+		}
+		if report := ms.Verify(); !report.OK() {
+			t.Errorf("Replica %v is inconsistent, report=%v", i, report)
+		}
+	}
+}
+
+func TestDeltaAndTombstones(t *testing.T) {
+	var wall int64 = int64(time.Hour)
+	ms := testReplica("a", &wall)
+	for id := 0; id < 5; id++ {
+		ms.Add(TestStruct{id, 1, "x"})
+	}
+	position := ms.Delta(0).Seq
+
+	wall += int64(time.Minute)
+	ms.Delete(TestStruct{id: 1}, "id")
+	ms.Add(TestStruct{7, 1, "x"})
+
+	delta := ms.Delta(position)
+	if len(delta.Entries) != 2 || !delta.Entries[0].Deleted || delta.Entries[1].Item != (TestStruct{7, 1, "x"}) {
+		t.Errorf("Delta should only hold later changes, delta=%v", delta)
+	}
+
+	if pruned := ms.PruneTombstones(time.Unix(0, wall)); pruned != 0 {
+		t.Errorf("Recent tombstones shouldn't be pruned, pruned=%v", pruned)
+	}
+	if pruned := ms.PruneTombstones(time.Unix(0, wall+1)); pruned != 1 || len(ms.Delta(0).Entries) != 5 {
+		t.Errorf("Old tombstones should be pruned, pruned=%v", pruned)
+	}
+
+	// Stores that aren't mergeable have nothing to exchange
+	plain := New([]string{"id"})
+	plain.Add(TestStruct{1, 1, "x"})
+	if len(plain.Delta(0).Entries) != 0 || plain.Merge(ms) != 0 || plain.Len() != 1 {
+		t.Error("Store without merge mode shouldn't merge")
+	}
+}
+
+func TestApplySnapshotForgetsMerge(t *testing.T) {
+	var wall int64 = 1
+	a := testReplica("a", &wall)
+	a.Add(TestStruct{1, 1, "x"})
+	a.Delete(TestStruct{id: 1}, "id")
+	seq := a.Delta(0).Seq
+
+	leader := New([]string{"id", "name"})
+	leader.Add(TestStruct{2, 2, "y"})
+	a.ApplySnapshot(leader.Snapshot())
+
+	// Tombstone of an item the snapshot replaced doesn't reach other replicas
+	if delta := a.Delta(0); len(delta.Entries) != 0 || delta.Seq != seq {
+		t.Errorf("Applying a snapshot should forget last writes, delta=%+v", delta)
+	}
+
+	a.Add(TestStruct{3, 3, "z"})
+	if delta := a.Delta(seq); len(delta.Entries) != 1 || delta.Entries[0].Item != (TestStruct{3, 3, "z"}) {
+		t.Errorf("Writes after a snapshot should be merged, delta=%+v", delta)
+	}
+}
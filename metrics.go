@@ -0,0 +1,379 @@
+package memstore
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Names of instrumented operations
+const (
+	opAdd               = "Add"
+	opAddOrGet          = "AddOrGet"
+	opPut               = "Put"
+	opDelete            = "Delete"
+	opGet               = "Get"
+	opGetRange          = "GetRange"
+	opLen               = "Len"
+	opMax               = "Max"
+	opMin               = "Min"
+	opUpdate            = "UpdateData"
+	opApplyData         = "ApplyData"
+	opUpdateWithIndexes = "UpdateWithIndexes"
+	opApplyDataSubset   = "ApplyDataSubset"
+	opCompareAndSwap    = "CompareAndSwap"
+	opQuery             = "Query"
+	opIntersect         = "Intersect"
+	opUnion             = "Union"
+	opAddAggregate      = "AddAggregate"
+	opAggregate         = "Aggregate"
+	opGroupBy           = "GroupBy"
+	opVerify            = "Verify"
+	opRebuild           = "Rebuild"
+	opGetWithVersion    = "GetWithVersion"
+	opUpdateIfVersion   = "UpdateIfVersion"
+	opDeleteIfVersion   = "DeleteIfVersion"
+	opSnapshot          = "Snapshot"
+	opApplySnapshot     = "ApplySnapshot"
+	opApplyMutations    = "ApplyMutations"
+	opDelta             = "Delta"
+	opApplyDelta        = "ApplyDelta"
+	opPruneTombstones   = "PruneTombstones"
+	opPromote           = "Promote"
+	opDiff              = "Diff"
+	opView              = "View"
+	opAddTextIndex      = "AddTextIndex"
+	opSearch            = "Search"
+	opAddTrigramIndex   = "AddTrigramIndex"
+	opContains          = "Contains"
+	opRegexp            = "Regexp"
+	opAddGeoIndex       = "AddGeoIndex"
+	opWithinBox         = "WithinBox"
+	opWithinRadius      = "WithinRadius"
+	opNearest           = "Nearest"
+)
+
+var operationNames = []string{
+	opAdd, opAddOrGet, opPut, opDelete, opGet, opGetRange, opLen, opMax, opMin,
+	opUpdate, opApplyData, opUpdateWithIndexes, opApplyDataSubset, opCompareAndSwap,
+	opQuery, opIntersect, opUnion, opAddAggregate, opAggregate, opGroupBy,
+	opVerify, opRebuild, opGetWithVersion, opUpdateIfVersion, opDeleteIfVersion,
+	opSnapshot, opApplySnapshot, opApplyMutations, opDelta, opApplyDelta, opPruneTombstones,
+	opPromote, opDiff, opView, opAddTextIndex, opSearch, opAddTrigramIndex, opContains, opRegexp,
+	opAddGeoIndex, opWithinBox, opWithinRadius, opNearest,
+}
+
+// Upper bounds of latency buckets, in seconds
+var latencyBuckets = []float64{
+	1e-6, 5e-6, 1e-5, 5e-5, 1e-4, 5e-4, 1e-3, 5e-3, 1e-2, 5e-2, 0.1, 0.5, 1, 5, 10,
+}
+
+/*
+	Public operation in progress, only tracked when store is instrumented, logs slow operations or has hooks
+*/
+type operation struct {
+	Operation
+
+	// State returned by every hook before the operation
+	hookStates []interface{}
+
+	// Lock taken (write if it was ever taken for writing), and when it was last acquired
+	write    bool
+	locked   bool
+	lockedAt time.Time
+}
+
+func (ms *Memstore) instrumented() bool {
+	return ms.metrics != nil || ms.slowLog != nil || len(ms.hooks) > 0
+}
+
+// Start tracking an operation, nil if store isn't instrumented
+func (ms *Memstore) begin(name string, index string) *operation {
+	if !ms.instrumented() {
+		return nil
+	}
+
+	op := &operation{
+		Operation: Operation{
+			Name:  name,
+			Index: index,
+			Start: time.Now(),
+		},
+	}
+
+	if len(ms.hooks) > 0 {
+		op.hookStates = make([]interface{}, len(ms.hooks))
+		for i, hooks := range ms.hooks {
+			op.hookStates[i] = hooks.Before(&op.Operation)
+		}
+	}
+
+	return op
+}
+
+// Finish tracking an operation
+func (ms *Memstore) end(op *operation) {
+	if op == nil {
+		return
+	}
+
+	op.Duration = time.Since(op.Start)
+
+	if ms.metrics != nil {
+		ms.metrics.record(op)
+	}
+	if ms.slowLog != nil {
+		ms.slowLog.observe(op)
+	}
+
+	// Run hooks in reverse order, like nested middlewares
+	for i := len(ms.hooks) - 1; i >= 0; i-- {
+		ms.hooks[i].After(&op.Operation, op.hookStates[i])
+	}
+}
+
+// Count items read or written by an operation
+func (op *operation) addItems(n int) {
+	if op != nil {
+		op.Items += n
+	}
+}
+
+func (ms *Memstore) lock(op *operation) {
+	if op == nil {
+		ms.m.Lock()
+		return
+	}
+	requested := time.Now()
+	ms.m.Lock()
+	op.acquired(requested, true)
+}
+
+func (ms *Memstore) unlock(op *operation) {
+	if op != nil {
+		op.released()
+	}
+	ms.m.Unlock()
+}
+
+func (ms *Memstore) rlock(op *operation) {
+	if op == nil {
+		ms.m.RLock()
+		return
+	}
+	requested := time.Now()
+	ms.m.RLock()
+	op.acquired(requested, false)
+}
+
+func (ms *Memstore) runlock(op *operation) {
+	if op != nil {
+		op.released()
+	}
+	ms.m.RUnlock()
+}
+
+func (op *operation) acquired(requested time.Time, write bool) {
+	op.lockedAt = time.Now()
+	op.locked = true
+	op.write = op.write || write
+	op.LockWait += op.lockedAt.Sub(requested)
+}
+
+func (op *operation) released() {
+	op.LockHold += time.Since(op.lockedAt)
+}
+
+/*
+	Distribution of durations
+
+	Counts[i] is the number of durations up to Bounds[i] (in seconds) and above the previous bound,
+	the last count being for durations above every bound
+*/
+type Histogram struct {
+	Bounds []float64
+	Counts []uint64
+	Count  uint64
+	Sum    float64
+}
+
+/*
+	Metrics of a public operation
+*/
+type OperationStats struct {
+	Count   uint64
+	Latency Histogram
+}
+
+/*
+	Time spent waiting for and holding the store lock
+*/
+type LockStats struct {
+	Wait Histogram
+	Hold Histogram
+}
+
+/*
+	Size and height of the tree of an index
+*/
+type IndexStats struct {
+	Index  string
+	Size   int
+	Height int
+}
+
+/*
+	Snapshot of store metrics
+
+	Operation and lock metrics are only gathered with WithMetrics
+*/
+type Stats struct {
+	Items      int
+	Sequence   uint64
+	Indexes    []IndexStats
+	Operations map[string]OperationStats
+	ReadLock   LockStats
+	WriteLock  LockStats
+
+	// Writes rejected as a follower, and items Add left out for taking a key of another one in a unique index
+	RejectedWrites uint64
+
+	// Items evicted by another one added with their primary key (see Add)
+	Evictions uint64
+
+	// Mutations forgotten by the op log to make room for newer ones, followers that missed them needing a snapshot
+	ExpiredMutations uint64
+}
+
+// Histogram updated without locking
+type histogram struct {
+	counts []uint64
+	count  uint64
+	// Sum in nanoseconds
+	sum uint64
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]uint64, len(latencyBuckets)+1)}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	seconds := d.Seconds()
+	bucket := len(latencyBuckets)
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			bucket = i
+			break
+		}
+	}
+	atomic.AddUint64(&h.counts[bucket], 1)
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddUint64(&h.sum, uint64(d.Nanoseconds()))
+}
+
+func (h *histogram) snapshot() Histogram {
+	res := Histogram{
+		Bounds: append([]float64(nil), latencyBuckets...),
+		Counts: make([]uint64, len(h.counts)),
+		Count:  atomic.LoadUint64(&h.count),
+		Sum:    time.Duration(atomic.LoadUint64(&h.sum)).Seconds(),
+	}
+	for i := range h.counts {
+		res.Counts[i] = atomic.LoadUint64(&h.counts[i])
+	}
+	return res
+}
+
+type lockMetrics struct {
+	wait *histogram
+	hold *histogram
+}
+
+func newLockMetrics() lockMetrics {
+	return lockMetrics{wait: newHistogram(), hold: newHistogram()}
+}
+
+func (lm lockMetrics) snapshot() LockStats {
+	return LockStats{Wait: lm.wait.snapshot(), Hold: lm.hold.snapshot()}
+}
+
+/*
+	Instrumentation of a store
+
+	Map of operations is built once, so recording only needs atomic operations
+*/
+type metrics struct {
+	operations map[string]*histogram
+	readLock   lockMetrics
+	writeLock  lockMetrics
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		operations: map[string]*histogram{},
+		readLock:   newLockMetrics(),
+		writeLock:  newLockMetrics(),
+	}
+	for _, name := range operationNames {
+		m.operations[name] = newHistogram()
+	}
+	return m
+}
+
+func (m *metrics) record(op *operation) {
+	m.operations[op.Name].observe(op.Duration)
+
+	if !op.locked {
+		return
+	}
+	lm := m.readLock
+	if op.write {
+		lm = m.writeLock
+	}
+	lm.wait.observe(op.LockWait)
+	lm.hold.observe(op.LockHold)
+}
+
+// Instrument operations and lock usage of the store (see Stats)
+func WithMetrics() Option {
+	return func(ms *Memstore) {
+		ms.metrics = newMetrics()
+	}
+}
+
+// Get store metrics, computing tree heights walks every tree
+func (ms *Memstore) Stats() (stats Stats) {
+	stats.Sequence = ms.Sequence()
+	stats.RejectedWrites = atomic.LoadUint64(&ms.rejectedWrites)
+	stats.Evictions = atomic.LoadUint64(&ms.evictions)
+	if ms.opLog != nil {
+		ms.opLog.m.Lock()
+		stats.ExpiredMutations = ms.opLog.expired
+		ms.opLog.m.Unlock()
+	}
+
+	ms.m.RLock()
+
+	stats.Items = ms.trees[0].Len()
+	for _, index := range ms.indexes {
+		tree := ms.indexTree[index]
+		stats.Indexes = append(stats.Indexes, IndexStats{
+			Index:  index,
+			Size:   tree.Len(),
+			Height: tree.height(),
+		})
+	}
+
+	ms.m.RUnlock()
+
+	if ms.metrics != nil {
+		stats.Operations = map[string]OperationStats{}
+		for name, h := range ms.metrics.operations {
+			latency := h.snapshot()
+			stats.Operations[name] = OperationStats{Count: latency.Count, Latency: latency}
+		}
+		stats.ReadLock = ms.metrics.readLock.snapshot()
+		stats.WriteLock = ms.metrics.writeLock.snapshot()
+	}
+
+	return stats
+}
@@ -0,0 +1,107 @@
+/*
+	Prometheus adapter for memstore metrics
+*/
+
+package metrics
+
+import (
+	"github.com/mngharbi/memstore"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+/*
+	Collector exposing Stats of a store to Prometheus
+
+	Operation and lock metrics need the store to be created WithMetrics
+*/
+type Collector struct {
+	ms *memstore.Memstore
+
+	items             *prometheus.Desc
+	sequence          *prometheus.Desc
+	rejectedWrites    *prometheus.Desc
+	evictions         *prometheus.Desc
+	expiredMutations  *prometheus.Desc
+	indexSize         *prometheus.Desc
+	indexHeight       *prometheus.Desc
+	operations        *prometheus.Desc
+	operationDuration *prometheus.Desc
+	lockWait          *prometheus.Desc
+	lockHold          *prometheus.Desc
+}
+
+func NewCollector(ms *memstore.Memstore, namespace string, constLabels prometheus.Labels) *Collector {
+	desc := func(name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "memstore", name), help, labels, constLabels)
+	}
+
+	return &Collector{
+		ms:                ms,
+		items:             desc("items", "Number of items in the store."),
+		sequence:          desc("sequence", "Global sequence number, incremented by every mutation."),
+		rejectedWrites:    desc("rejected_writes_total", "Number of writes rejected as a follower, or for taking a unique key of another item."),
+		evictions:         desc("evictions_total", "Number of items evicted by an add of another item with their primary key."),
+		expiredMutations:  desc("expired_mutations_total", "Number of mutations forgotten by the op log to make room for newer ones."),
+		indexSize:         desc("index_size", "Number of items in the tree of an index.", "index"),
+		indexHeight:       desc("index_height", "Height of the tree of an index.", "index"),
+		operations:        desc("operations_total", "Number of public operations.", "operation"),
+		operationDuration: desc("operation_duration_seconds", "Duration of public operations.", "operation"),
+		lockWait:          desc("lock_wait_seconds", "Time spent waiting for the store lock.", "mode"),
+		lockHold:          desc("lock_hold_seconds", "Time spent holding the store lock.", "mode"),
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.items
+	ch <- c.sequence
+	ch <- c.rejectedWrites
+	ch <- c.evictions
+	ch <- c.expiredMutations
+	ch <- c.indexSize
+	ch <- c.indexHeight
+	ch <- c.operations
+	ch <- c.operationDuration
+	ch <- c.lockWait
+	ch <- c.lockHold
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.ms.Stats()
+
+	ch <- prometheus.MustNewConstMetric(c.items, prometheus.GaugeValue, float64(stats.Items))
+	ch <- prometheus.MustNewConstMetric(c.sequence, prometheus.CounterValue, float64(stats.Sequence))
+	ch <- prometheus.MustNewConstMetric(c.rejectedWrites, prometheus.CounterValue, float64(stats.RejectedWrites))
+	ch <- prometheus.MustNewConstMetric(c.evictions, prometheus.CounterValue, float64(stats.Evictions))
+	ch <- prometheus.MustNewConstMetric(c.expiredMutations, prometheus.CounterValue, float64(stats.ExpiredMutations))
+
+	for _, index := range stats.Indexes {
+		ch <- prometheus.MustNewConstMetric(c.indexSize, prometheus.GaugeValue, float64(index.Size), index.Index)
+		ch <- prometheus.MustNewConstMetric(c.indexHeight, prometheus.GaugeValue, float64(index.Height), index.Index)
+	}
+
+	// Only available if store is instrumented
+	if stats.Operations == nil {
+		return
+	}
+
+	for name, operation := range stats.Operations {
+		ch <- prometheus.MustNewConstMetric(c.operations, prometheus.CounterValue, float64(operation.Count), name)
+		ch <- constHistogram(c.operationDuration, operation.Latency, name)
+	}
+
+	ch <- constHistogram(c.lockWait, stats.ReadLock.Wait, "read")
+	ch <- constHistogram(c.lockHold, stats.ReadLock.Hold, "read")
+	ch <- constHistogram(c.lockWait, stats.WriteLock.Wait, "write")
+	ch <- constHistogram(c.lockHold, stats.WriteLock.Hold, "write")
+}
+
+// Prometheus histograms have cumulative buckets
+func constHistogram(desc *prometheus.Desc, h memstore.Histogram, labelValues ...string) prometheus.Metric {
+	buckets := map[float64]uint64{}
+	var cumulative uint64
+	for i, bound := range h.Bounds {
+		cumulative += h.Counts[i]
+		buckets[bound] = cumulative
+	}
+	return prometheus.MustNewConstHistogram(desc, h.Count, h.Sum, buckets, labelValues...)
+}
@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"github.com/mngharbi/memstore"
+	"github.com/prometheus/client_golang/prometheus"
+	"testing"
+)
+
+type testItem struct {
+	id int
+}
+
+func (ti testItem) Less(index string, than interface{}) bool {
+	return ti.id < than.(testItem).id
+}
+
+func collect(c prometheus.Collector) (descs map[*prometheus.Desc]bool, metrics []prometheus.Metric) {
+	descCh := make(chan *prometheus.Desc, 100)
+	c.Describe(descCh)
+	close(descCh)
+	descs = map[*prometheus.Desc]bool{}
+	for desc := range descCh {
+		descs[desc] = true
+	}
+
+	metricCh := make(chan prometheus.Metric, 1000)
+	c.Collect(metricCh)
+	close(metricCh)
+	for metric := range metricCh {
+		metrics = append(metrics, metric)
+	}
+
+	return descs, metrics
+}
+
+func TestCollectorUninstrumented(t *testing.T) {
+	ms := memstore.New([]string{"id"})
+	ms.Add(testItem{1})
+
+	descs, metrics := collect(NewCollector(ms, "test", nil))
+
+	// Items, sequence, rejected writes, evictions, expired mutations, size and height of one index
+	if len(descs) != 11 || len(metrics) != 7 {
+		t.Errorf("Collecting uninstrumented store failed, descs=%v metrics=%v", len(descs), len(metrics))
+	}
+}
+
+func TestCollectorInstrumented(t *testing.T) {
+	ms := memstore.New([]string{"id"}, memstore.WithMetrics())
+	ms.Add(testItem{1})
+	ms.Get(testItem{1}, "id")
+
+	var c prometheus.Collector = NewCollector(ms, "test", prometheus.Labels{"store": "test"})
+	descs, metrics := collect(c)
+
+	operations := len(ms.Stats().Operations)
+	if len(metrics) != 7+2*operations+4 {
+		t.Errorf("Collecting instrumented store failed, metrics=%v", len(metrics))
+	}
+
+	for _, metric := range metrics {
+		if !descs[metric.Desc()] {
+			t.Errorf("Collected metric wasn't described, desc=%v", metric.Desc())
+		}
+	}
+
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(c); err != nil {
+		t.Errorf("Registering collector failed, err=%v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package memstore
+
+import (
+	"testing"
+)
+
+func TestStatsUninstrumented(t *testing.T) {
+	ms := New([]string{"id", "importance"})
+	for _, v := range shuffeledTestData() {
+		var vItem Item = v
+		ms.Add(vItem)
+	}
+
+	stats := ms.Stats()
+	if stats.Items != len(testData()) || stats.Sequence != ms.Sequence() || stats.Operations != nil {
+		t.Errorf("Stats of uninstrumented store failed, stats=%+v", stats)
+	}
+
+	for _, index := range stats.Indexes {
+		if index.Size != len(testData()) || index.Height < 3 || index.Height > 6 {
+			t.Errorf("Stats of index failed, stats=%+v", index)
+		}
+	}
+}
+
+func TestStatsInstrumented(t *testing.T) {
+	ms := New([]string{"id", "importance"}, WithMetrics())
+	for _, v := range shuffeledTestData() {
+		var vItem Item = v
+		ms.Add(vItem)
+	}
+	var searchedRecord Item = TestStruct{id: 3}
+	ms.Get(searchedRecord, "id")
+	ms.Get(searchedRecord, "notID")
+	ms.Query().Where("id", AtLeast(2)).Run()
+
+	stats := ms.Stats()
+
+	if stats.Operations[opAdd].Count != uint64(len(testData())) ||
+		stats.Operations[opGet].Count != 2 ||
+		stats.Operations[opQuery].Count != 1 ||
+		stats.Operations[opDelete].Count != 0 {
+		t.Errorf("Operation counts failed, stats=%+v", stats.Operations)
+	}
+
+	// Get with unknown index doesn't take the lock
+	if stats.WriteLock.Hold.Count != uint64(len(testData())) || stats.ReadLock.Wait.Count != 2 {
+		t.Errorf("Lock metrics failed, read=%+v write=%+v", stats.ReadLock, stats.WriteLock)
+	}
+
+	latency := stats.Operations[opAdd].Latency
+	var total uint64
+	for _, count := range latency.Counts {
+		total += count
+	}
+	if total != latency.Count || len(latency.Counts) != len(latency.Bounds)+1 || latency.Sum <= 0 {
+		t.Errorf("Latency histogram failed, histogram=%+v", latency)
+	}
+}
+
+func TestStatsEvictions(t *testing.T) {
+	ms := New([]string{"id"}, WithOpLog(4))
+	for _, v := range testData() {
+		ms.Add(v)
+	}
+
+	// Adding an item with the primary key of another evicts it, its delete and put filling the log
+	ms.Add(TestStruct{1, 7, "w"})
+
+	stats := ms.Stats()
+	if stats.Evictions != 1 || stats.ExpiredMutations != uint64(len(testData())+2-4) {
+		t.Errorf("Eviction and expiry counts failed, stats=%+v", stats)
+	}
+}
+
+func TestStatsDebugChecks(t *testing.T) {
+	// Test items accept unknown indexes, which is beside the point here
+	ms := New([]string{"id", "importance"}, WithMetrics(), WithDebug(DebugOptions{OnViolation: func(ComparatorViolation) {}}))
+	ms.Add(TestStruct{1, 3, "x"})
+
+	// Neighbors read for debug checks count in the lock metrics of the write
+	stats := ms.Stats()
+	if stats.WriteLock.Hold.Count != 1 || stats.ReadLock.Hold.Count != 0 {
+		t.Errorf("Lock metrics of a checked write failed, read=%+v write=%+v", stats.ReadLock, stats.WriteLock)
+	}
+}
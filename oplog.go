@@ -0,0 +1,201 @@
+package memstore
+
+import (
+	"sync"
+)
+
+/*
+	Kind of change made by a mutation
+*/
+type MutationKind int
+
+const (
+	// Item added, or changed in place or within trees
+	MutationPut MutationKind = iota
+
+	// Item deleted
+	MutationDelete
+)
+
+func (kind MutationKind) String() string {
+	switch kind {
+	case MutationPut:
+		return "put"
+	case MutationDelete:
+		return "delete"
+	default:
+		return "unknown"
+	}
+}
+
+/*
+	Change of a single item, as shipped to followers
+
+	Every mutation moves the sequence number of the store by one, so mutations of a store are numbered without gaps.
+	Items are identified by ID rather than by index, so that a put applies to the right item whatever changed.
+*/
+type Mutation struct {
+	Seq  uint64
+	Kind MutationKind
+	ID   uint64
+
+	// Item after the change, nil for deletes
+	Item Item
+}
+
+/*
+	Last mutations of a store, kept in a ring
+*/
+type opLog struct {
+	m sync.Mutex
+
+	ring []Mutation
+
+	// Position of the next mutation in ring, and number of mutations in it
+	next  int
+	count int
+
+	// Sequence number of the last mutation (or of the store when it was reset)
+	last uint64
+
+	// Number of mutations forgotten to make room for newer ones
+	expired uint64
+
+	// Closed on the next mutation, if someone waits for one
+	changed chan struct{}
+}
+
+/*
+	Keep the last capacity mutations so that followers can catch up (see Mutations and ServeFollower)
+
+	Followers further behind are sent a snapshot instead.
+*/
+func WithOpLog(capacity int) Option {
+	return func(ms *Memstore) {
+		if capacity < 1 {
+			capacity = 1
+		}
+		ms.opLog = &opLog{ring: make([]Mutation, capacity)}
+	}
+}
+
+func (l *opLog) append(m Mutation) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	l.ring[l.next] = m
+	l.next = (l.next + 1) % len(l.ring)
+	if l.count < len(l.ring) {
+		l.count++
+	} else {
+		l.expired++
+	}
+	l.last = m.Seq
+	l.notify()
+}
+
+// Forget every mutation, the store being at sequence number seq
+func (l *opLog) reset(seq uint64) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	for i := range l.ring {
+		l.ring[i] = Mutation{}
+	}
+	l.next = 0
+	l.count = 0
+	l.last = seq
+	l.notify()
+}
+
+// Wake up waiters (expects lock)
+func (l *opLog) notify() {
+	if l.changed != nil {
+		close(l.changed)
+		l.changed = nil
+	}
+}
+
+/*
+	Up to max mutations following sequence number seq
+
+	If there are none yet, also returns a channel closed on the next mutation.
+	Fails with ErrLogTruncated if mutations following seq were forgotten or seq is ahead of the log.
+*/
+func (l *opLog) since(seq uint64, max int) ([]Mutation, <-chan struct{}, error) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	first := l.last - uint64(l.count) + 1
+	if seq > l.last || seq+1 < first {
+		return nil, nil, ErrLogTruncated
+	}
+
+	if seq == l.last {
+		if l.changed == nil {
+			l.changed = make(chan struct{})
+		}
+		return nil, l.changed, nil
+	}
+
+	behind := int(l.last - seq)
+	n := behind
+	if n > max {
+		n = max
+	}
+	start := (l.next - behind + len(l.ring)) % len(l.ring)
+
+	res := make([]Mutation, n)
+	for i := range res {
+		res[i] = l.ring[(start+i)%len(l.ring)]
+	}
+	return res, nil, nil
+}
+
+/*
+	Up to max mutations following sequence number seq, in order
+
+	Requires an operation log (see WithOpLog), fails with ErrLogTruncated if some of them were forgotten
+*/
+func (ms *Memstore) Mutations(seq uint64, max int) ([]Mutation, error) {
+	if ms.opLog == nil {
+		return nil, ErrNoOpLog
+	}
+	res, _, err := ms.opLog.since(seq, max)
+	return res, err
+}
+
+/*
+	Log a mutation and keep what follows items in sync (expects a write lock):
+	items by identity when replicating, text, trigram and geospatial indexes, and last writes when mergeable
+*/
+func (ms *Memstore) record(kind MutationKind, ii *internalItem, seq uint64) {
+	// Identities and versions of a store writing on its own no longer match those of its leader
+	if ms.inherited && !ms.IsFollower() {
+		ms.fork()
+	}
+
+	ms.indexText(kind, ii)
+	ms.indexTrigrams(kind, ii)
+	ms.indexGeo(kind, ii)
+
+	if ms.byID != nil {
+		if kind == MutationDelete {
+			delete(ms.byID, ii.id)
+		} else {
+			ms.byID[ii.id] = ii
+		}
+	}
+
+	if ms.merge != nil {
+		ms.merge.stamp(ii.value, kind == MutationDelete)
+	}
+
+	if ms.opLog != nil {
+		m := Mutation{Seq: seq, Kind: kind, ID: ii.id}
+		if kind == MutationPut {
+			m.Item = ii.value
+		}
+		ms.opLog.append(m)
+	}
+}
@@ -0,0 +1,37 @@
+package memstore
+
+/*
+	Option configuring a store at creation
+*/
+type Option func(*Memstore)
+
+/*
+	What UpdateData does when the modify function changes indexed fields
+*/
+type IndexChangePolicy int
+
+const (
+	// Reject the update, leaving the item untouched
+	RejectOnIndexChange IndexChangePolicy = iota
+
+	// Move the item within every tree, like UpdateWithIndexes
+	RepositionOnIndexChange
+)
+
+func WithIndexChangePolicy(policy IndexChangePolicy) Option {
+	return func(ms *Memstore) {
+		ms.indexChangePolicy = policy
+	}
+}
+
+/*
+	Keep at most one item per key in secondary indexes, like in the primary index
+
+	Writes giving an item the key of another one in any of them are rejected (see Put).
+	Less must order items by key alone in those indexes, so that items with the same key compare equal.
+*/
+func WithUnique(indexes ...string) Option {
+	return func(ms *Memstore) {
+		ms.unique = append(ms.unique, indexes...)
+	}
+}
@@ -0,0 +1,205 @@
+package memstore
+
+import (
+	"sort"
+)
+
+/*
+	Condition on the raw key of an index
+
+	Made of the range of keys it accepts, used to scan the index,
+	and an optional check on keys within that range
+*/
+type Predicate struct {
+	bounds Range
+	match  func(key interface{}) bool
+}
+
+func Equal(key interface{}) Predicate {
+	return Predicate{bounds: NewRange(InclusiveKey(key), InclusiveKey(key))}
+}
+
+// Keys between from and to, both included
+func Between(from, to interface{}) Predicate {
+	return Predicate{bounds: NewRange(InclusiveKey(from), InclusiveKey(to))}
+}
+
+func GreaterThan(key interface{}) Predicate {
+	return Predicate{bounds: NewRange(ExclusiveKey(key), Unbounded())}
+}
+
+func AtLeast(key interface{}) Predicate {
+	return Predicate{bounds: NewRange(InclusiveKey(key), Unbounded())}
+}
+
+func LessThan(key interface{}) Predicate {
+	return Predicate{bounds: NewRange(Unbounded(), ExclusiveKey(key))}
+}
+
+func AtMost(key interface{}) Predicate {
+	return Predicate{bounds: NewRange(Unbounded(), InclusiveKey(key))}
+}
+
+// String keys starting with prefix
+func Prefix(prefix string) Predicate {
+	upper := Unbounded()
+	if end, ok := prefixEnd(prefix); ok {
+		upper = ExclusiveKey(end)
+	}
+	return Predicate{bounds: NewRange(InclusiveKey(prefix), upper)}
+}
+
+// Keys accepted by an arbitrary function (can't narrow down the scan)
+func Matching(match func(key interface{}) bool) Predicate {
+	return Predicate{bounds: All(), match: match}
+}
+
+// Smallest string greater than every string starting with prefix
+func prefixEnd(prefix string) (string, bool) {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1]), true
+		}
+	}
+	return "", false
+}
+
+// Check predicate against an item
+func (p Predicate) matches(index string, x Item) bool {
+	if !p.bounds.contains(index, x) {
+		return false
+	}
+	return p.match == nil || p.match(keyOf(x, index))
+}
+
+type filter struct {
+	index     string
+	predicate Predicate
+}
+
+/*
+	Query over multiple indexes
+
+	Built by chaining Where, OrderBy and Limit, then executed with Run
+*/
+type Query struct {
+	ms      *Memstore
+	filters []filter
+	order   string
+	limit   int
+}
+
+// Plan chosen for a query: index scanned and range of the scan
+type queryPlan struct {
+	index  string
+	bounds Range
+}
+
+func (ms *Memstore) Query() *Query {
+	return &Query{ms: ms}
+}
+
+func (q *Query) Where(index string, p Predicate) *Query {
+	q.filters = append(q.filters, filter{index: index, predicate: p})
+	return q
+}
+
+func (q *Query) OrderBy(index string) *Query {
+	q.order = index
+	return q
+}
+
+// Maximum number of results, zero means no limit
+func (q *Query) Limit(n int) *Query {
+	q.limit = n
+	return q
+}
+
+func (q *Query) Run() (res []Item, err error) {
+	op := q.ms.begin(opQuery, "")
+	defer q.ms.end(op)
+
+	q.ms.rlock(op)
+
+	plan, err := q.plan()
+	if err != nil {
+		q.ms.runlock(op)
+		return nil, err
+	}
+
+	// Results come out ordered if we scan the index we order by
+	ordered := q.order == "" || q.order == plan.index
+
+	// Scan chosen index and check every filter
+	ascendRange(q.ms.indexTree[plan.index], plan.index, plan.bounds, func(ii *internalItem) bool {
+		x := ii.value
+		for _, f := range q.filters {
+			if !f.predicate.matches(f.index, x) {
+				return true
+			}
+		}
+		res = append(res, x)
+		return !ordered || q.limit <= 0 || len(res) < q.limit
+	})
+
+	q.ms.runlock(op)
+
+	// Sort and limit results if scan order wasn't the one requested
+	if !ordered {
+		sort.SliceStable(res, func(i, j int) bool {
+			return res[i].Less(q.order, res[j])
+		})
+	}
+	if q.limit > 0 && len(res) > q.limit {
+		res = res[:q.limit]
+	}
+	op.addItems(len(res))
+
+	return res, nil
+}
+
+// Pick the index to scan, the one with fewest items estimated within its predicate (expects a read lock)
+func (q *Query) plan() (queryPlan, error) {
+	// Check every index used
+	if q.order != "" && q.ms.lookupTree(q.order) == nil {
+		return queryPlan{}, ErrUnknownIndex
+	}
+	for _, f := range q.filters {
+		if q.ms.lookupTree(f.index) == nil {
+			return queryPlan{}, ErrUnknownIndex
+		}
+	}
+
+	// Without filters, scan everything in the order requested
+	if len(q.filters) == 0 {
+		index := q.order
+		if index == "" {
+			index = q.ms.indexes[0]
+		}
+		return queryPlan{index: index, bounds: All()}, nil
+	}
+
+	var best queryPlan
+	bestEstimate := -1
+	for _, f := range q.filters {
+		st := q.ms.indexStatistics(f.index)
+		if !st.keyed {
+			return queryPlan{}, ErrNotKeyed
+		}
+		if err := f.predicate.bounds.check(q.ms.indexTree[f.index], f.index); err != nil {
+			return queryPlan{}, err
+		}
+
+		// Break ties in favor of the index we order by
+		estimate := st.estimate(f.index, f.predicate.bounds)
+		if bestEstimate < 0 || estimate < bestEstimate ||
+			(estimate == bestEstimate && f.index == q.order) {
+			best = queryPlan{index: f.index, bounds: f.predicate.bounds}
+			bestEstimate = estimate
+		}
+	}
+
+	return best, nil
+}
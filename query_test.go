@@ -0,0 +1,122 @@
+package memstore
+
+import (
+	"reflect"
+	"testing"
+)
+
+func queryTestStore() *Memstore {
+	ms := New([]string{"id", "importance", "name"})
+	for _, v := range shuffeledTestData() {
+		var vItem Item = v
+		ms.Add(vItem)
+	}
+	return ms
+}
+
+func toTestStructs(items []Item) []TestStruct {
+	res := make([]TestStruct, 0, len(items))
+	for _, it := range items {
+		res = append(res, it.(TestStruct))
+	}
+	return res
+}
+
+func TestQueryInvalidIndex(t *testing.T) {
+	ms := queryTestStore()
+
+	if _, err := ms.Query().Where("notID", Equal(1)).Run(); err != ErrUnknownIndex {
+		t.Errorf("Query filtering on unspecified index should fail, err=%v", err)
+	}
+
+	if _, err := ms.Query().OrderBy("notID").Run(); err != ErrUnknownIndex {
+		t.Errorf("Query ordering by unspecified index should fail, err=%v", err)
+	}
+}
+
+func TestQueryNotKeyed(t *testing.T) {
+	ms := New([]string{"id0"})
+	ms.Add(*makeRandBenchStruct())
+
+	if _, err := ms.Query().Where("id0", AtLeast(0)).Run(); err != ErrNotKeyed {
+		t.Errorf("Query on items without raw keys should fail, err=%v", err)
+	}
+}
+
+func TestQueryMultiplePredicates(t *testing.T) {
+	ms := queryTestStore()
+
+	res, err := ms.Query().
+		Where("importance", Between(2, 4)).
+		Where("name", Prefix("v")).
+		Run()
+
+	expected := []TestStruct{{9, 3.1, "v"}}
+	if err != nil || !reflect.DeepEqual(toTestStructs(res), expected) {
+		t.Errorf("Query with multiple predicates failed, result=%v err=%v", res, err)
+	}
+}
+
+func TestQueryOrderByLimit(t *testing.T) {
+	ms := queryTestStore()
+
+	res, err := ms.Query().
+		Where("importance", Between(2, 4)).
+		OrderBy("id").
+		Limit(3).
+		Run()
+
+	expected := []TestStruct{{1, 3, "x"}, {2, 2, "y"}, {8, 3.2, "u"}}
+	if err != nil || !reflect.DeepEqual(toTestStructs(res), expected) {
+		t.Errorf("Query with order and limit failed, result=%v err=%v", res, err)
+	}
+}
+
+func TestQueryNoFilter(t *testing.T) {
+	ms := queryTestStore()
+
+	res, err := ms.Query().OrderBy("importance").Limit(2).Run()
+
+	expected := importanceSortedData()[:2]
+	if err != nil || !reflect.DeepEqual(toTestStructs(res), expected) {
+		t.Errorf("Query without filter failed, result=%v err=%v", res, err)
+	}
+}
+
+func TestQueryBounds(t *testing.T) {
+	ms := queryTestStore()
+
+	greater, _ := ms.Query().Where("id", GreaterThan(3)).Run()
+	if !reflect.DeepEqual(toTestStructs(greater), idSortedData()[3:]) {
+		t.Errorf("Query with exclusive lower bound failed, result=%v", greater)
+	}
+
+	atMost, _ := ms.Query().Where("id", AtMost(3)).Run()
+	if !reflect.DeepEqual(toTestStructs(atMost), idSortedData()[:3]) {
+		t.Errorf("Query with inclusive upper bound failed, result=%v", atMost)
+	}
+
+	matching, _ := ms.Query().Where("name", Matching(func(key interface{}) bool {
+		return key.(string) > "w"
+	})).OrderBy("id").Run()
+	expected := []TestStruct{{1, 3, "x"}, {2, 2, "y"}, {3, 5, "z"}}
+	if !reflect.DeepEqual(toTestStructs(matching), expected) {
+		t.Errorf("Query with arbitrary predicate failed, result=%v", matching)
+	}
+}
+
+func TestQueryPlanSelectivity(t *testing.T) {
+	ms := queryTestStore()
+
+	q := ms.Query().
+		Where("importance", Between(2, 4)).
+		Where("name", Prefix("v"))
+
+	ms.m.RLock()
+	plan, err := q.plan()
+	ms.m.RUnlock()
+
+	if err != nil || plan.index != "name" {
+		t.Errorf("Query should scan most selective index, scanned=%v err=%v", plan.index, err)
+	}
+}
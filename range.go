@@ -0,0 +1,168 @@
+package memstore
+
+import (
+	"github.com/mngharbi/GoLLRB/llrb"
+)
+
+type boundKind int
+
+const (
+	unbounded boundKind = iota
+	inclusive
+	exclusive
+)
+
+/*
+	One side of a range over an index
+
+	A bound either includes its item, excludes it, or is open-ended
+*/
+type Bound struct {
+	kind boundKind
+	item Item
+
+	// Raw key used instead of item for bounds built from keys
+	key   interface{}
+	keyed bool
+}
+
+func Inclusive(x Item) Bound {
+	return Bound{kind: inclusive, item: x}
+}
+
+func Exclusive(x Item) Bound {
+	return Bound{kind: exclusive, item: x}
+}
+
+func Unbounded() Bound {
+	return Bound{kind: unbounded}
+}
+
+// Bounds on raw keys, only usable with items implementing KeyedItem
+func InclusiveKey(key interface{}) Bound {
+	return Bound{kind: inclusive, key: key, keyed: true}
+}
+
+func ExclusiveKey(key interface{}) Bound {
+	return Bound{kind: exclusive, key: key, keyed: true}
+}
+
+/*
+	Range over an index, made of a lower and an upper bound
+*/
+type Range struct {
+	From Bound
+	To   Bound
+}
+
+func NewRange(from, to Bound) Range {
+	return Range{From: from, To: to}
+}
+
+// Range covering every item of an index
+func All() Range {
+	return Range{From: Unbounded(), To: Unbounded()}
+}
+
+// Make internal node to start ascending from
+func (b Bound) lowerPivot() llrb.Item {
+	if b.kind == unbounded {
+		return makeSentinelItem(-1)
+	}
+	if b.keyed {
+		return makeKeyItem(b.key)
+	}
+	return makeInternalItem(b.item)
+}
+
+/*
+	Check that key bounds compare with keys of items of a tree (expects at least a read lock)
+
+	Keys are checked against the first item, items of an index being expected to expose keys of the same kind.
+	Fails with ErrNotKeyed if items don't expose keys, ErrKeyType if keys don't compare with a bound.
+*/
+func (r Range) check(tree OrderedIndex, index string) error {
+	min := tree.Min()
+	if min == nil {
+		return nil
+	}
+	key := keyOf(min.(*internalItem).value, index)
+	for _, b := range []Bound{r.From, r.To} {
+		if !b.keyed || b.kind == unbounded {
+			continue
+		}
+		if key == nil {
+			return ErrNotKeyed
+		}
+		if _, ok := compareKeys(b.key, key); !ok {
+			return ErrKeyType
+		}
+	}
+	return nil
+}
+
+// Check whether bound value is strictly less than item
+func (b Bound) before(index string, x Item) bool {
+	if b.keyed {
+		comparison, _ := compareKeys(b.key, keyOf(x, index))
+		return comparison < 0
+	}
+	return b.item.Less(index, x)
+}
+
+// Check whether item is strictly less than bound value
+func (b Bound) after(index string, x Item) bool {
+	if b.keyed {
+		comparison, _ := compareKeys(keyOf(x, index), b.key)
+		return comparison < 0
+	}
+	return x.Less(index, b.item)
+}
+
+// Check whether item is above a lower bound
+func (b Bound) admitsFrom(index string, x Item) bool {
+	switch b.kind {
+	case inclusive:
+		return !b.after(index, x)
+	case exclusive:
+		return b.before(index, x)
+	default:
+		return true
+	}
+}
+
+// Check whether item is below an upper bound
+func (b Bound) admitsTo(index string, x Item) bool {
+	switch b.kind {
+	case inclusive:
+		return !b.before(index, x)
+	case exclusive:
+		return b.after(index, x)
+	default:
+		return true
+	}
+}
+
+// Check whether item is within range
+func (r Range) contains(index string, x Item) bool {
+	return r.From.admitsFrom(index, x) && r.To.admitsTo(index, x)
+}
+
+// Iterate over internal items of a tree within a range in ascending order
+func ascendRange(tree OrderedIndex, index string, r Range, iterator func(*internalItem) bool) {
+	tree.AscendGreaterOrEqual(r.From.lowerPivot(), func(it llrb.Item) bool {
+		ii := it.(*internalItem)
+
+		// Skip items equal to an exclusive lower bound
+		if !r.From.admitsFrom(index, ii.value) {
+			return true
+		}
+
+		// Stop once past the upper bound
+		if !r.To.admitsTo(index, ii.value) {
+			return false
+		}
+
+		return iterator(ii)
+	})
+}
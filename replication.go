@@ -0,0 +1,402 @@
+package memstore
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/gob"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// Most mutations sent to a follower at once
+const replicationBatch = 1024
+
+/*
+	Items of a store at a sequence number, with their identity and version, in order of the primary index
+*/
+type Snapshot struct {
+	Seq   uint64
+	Items []SnapshotItem
+
+	// Primary index
+	Index string
+
+	// Lineage of the store (see Diff)
+	Lineage uint64
+}
+
+type SnapshotItem struct {
+	ID      uint64
+	Version uint64
+	Item    Item
+}
+
+/*
+	Make the store a follower, rejecting writes: those returning errors fail with ErrReadOnly,
+	others (Add, AddOrGet, Delete, UpdateWithIndexes, CompareAndSwap, ApplyDelta) write nothing and say so
+	as if no item was found. Every rejected write is counted (see Stats).
+
+	The store only changes by applying snapshots and mutations of its leader (see Follow), until promoted.
+*/
+func AsFollower() Option {
+	return func(ms *Memstore) {
+		ms.readOnly = 1
+	}
+}
+
+/*
+	Accept writes again, typically once the leader is gone
+
+	Store starts a lineage of its own, as its writes diverge from those of its former leader and other followers.
+*/
+func (ms *Memstore) Promote() {
+	op := ms.begin(opPromote, "")
+	defer ms.end(op)
+
+	ms.lock(op)
+	defer ms.unlock(op)
+
+	ms.fork()
+	atomic.StoreInt32(&ms.readOnly, 0)
+}
+
+func (ms *Memstore) IsFollower() bool {
+	return atomic.LoadInt32(&ms.readOnly) != 0
+}
+
+// Whether a write is rejected as the store is a follower, counting those rejected
+func (ms *Memstore) rejectsWrites() bool {
+	if !ms.IsFollower() {
+		return false
+	}
+	atomic.AddUint64(&ms.rejectedWrites, 1)
+	return true
+}
+
+/*
+	Random lineage of a new store, shared by its followers
+
+	Identities and versions of items only mean the same thing within a lineage.
+*/
+func newLineage() uint64 {
+	var b [8]byte
+	rand.Read(b[:])
+	return binary.LittleEndian.Uint64(b[:])
+}
+
+func (ms *Memstore) currentLineage() uint64 {
+	ms.m.RLock()
+	defer ms.m.RUnlock()
+	return ms.lineage
+}
+
+// Start a new lineage (expects a write lock)
+func (ms *Memstore) fork() {
+	ms.lineage = newLineage()
+	ms.inherited = false
+}
+
+// Items of the store, in order of the primary index
+func (ms *Memstore) Snapshot() (snapshot Snapshot) {
+	op := ms.begin(opSnapshot, "")
+	defer ms.end(op)
+
+	ms.rlock(op)
+	defer ms.runlock(op)
+
+	snapshot.Seq = ms.Sequence()
+	snapshot.Index = ms.indexes[0]
+	snapshot.Lineage = ms.lineage
+	snapshot.Items = make([]SnapshotItem, 0, ms.trees[0].Len())
+	ascendRange(ms.trees[0], ms.indexes[0], All(), func(ii *internalItem) bool {
+		snapshot.Items = append(snapshot.Items, SnapshotItem{ID: ii.id, Version: ii.version, Item: ii.value})
+		return true
+	})
+	op.addItems(len(snapshot.Items))
+
+	return snapshot
+}
+
+/*
+	Replace every item with those of a snapshot of the leader
+
+	Identities, versions, lineage and the sequence number are those of the leader, so that its next mutations apply.
+	A store that isn't a follower starts a lineage of its own on its next write.
+	Last writes kept for merging (see WithMerge) are forgotten, as they were those of the items replaced.
+*/
+func (ms *Memstore) ApplySnapshot(snapshot Snapshot) {
+	op := ms.begin(opApplySnapshot, "")
+	defer ms.end(op)
+
+	ms.lock(op)
+	defer ms.unlock(op)
+
+	for _, tree := range ms.trees {
+		tree.clear()
+	}
+	for index, augs := range ms.augmented {
+		for i, aug := range augs {
+			ms.augmented[index][i] = newAugmentedTree(index, aug.agg)
+		}
+	}
+	for name, ti := range ms.text {
+		ms.text[name] = newTextIndex(ti.config)
+	}
+	for name, gi := range ms.trigrams {
+		ms.trigrams[name] = newTrigramIndex(gi.config)
+	}
+	for name, gi := range ms.geo {
+		ms.geo[name] = newGeoIndex(gi.config)
+	}
+	if ms.shared != nil {
+		ms.shared.root = nil
+	}
+	if ms.merge != nil {
+		ms.merge.reset()
+	}
+
+	ms.byID = make(map[uint64]*internalItem, len(snapshot.Items))
+	ms.lastID = 0
+	for _, item := range snapshot.Items {
+		ii := &internalItem{value: item.Item, id: item.ID, version: item.Version}
+		for index, tree := range ms.indexTree {
+			replaced := tree.ReplaceOrInsert(ii)
+			for _, aug := range ms.augmented[index] {
+				if replaced != nil {
+					aug.remove(replaced.(*internalItem))
+				}
+				aug.insert(ii)
+			}
+		}
+		ms.byID[ii.id] = ii
+		if ms.shared != nil {
+			ms.shared.put(ii)
+		}
+		ms.indexText(MutationPut, ii)
+		ms.indexTrigrams(MutationPut, ii)
+		ms.indexGeo(MutationPut, ii)
+		if ii.id > ms.lastID {
+			ms.lastID = ii.id
+		}
+	}
+	op.addItems(len(snapshot.Items))
+
+	// Followers of this store now need a snapshot as well
+	atomic.StoreUint64(&ms.sequence, snapshot.Seq)
+	ms.lineage = snapshot.Lineage
+	ms.inherited = true
+	if ms.opLog != nil {
+		ms.opLog.reset(snapshot.Seq)
+	}
+}
+
+/*
+	Apply mutations of the leader in order, under a single lock
+
+	Mutations already applied are skipped, fails with ErrOutOfSync if one is missing or unknown to the store
+	(store should then be given a snapshot)
+*/
+func (ms *Memstore) ApplyMutations(mutations []Mutation) error {
+	op := ms.begin(opApplyMutations, "")
+	defer ms.end(op)
+
+	ms.lock(op)
+	defer ms.unlock(op)
+
+	// Index items by identity on first use, kept up to date from then on
+	if ms.byID == nil {
+		ms.byID = map[uint64]*internalItem{}
+		for index, tree := range ms.indexTree {
+			ascendRange(tree, index, All(), func(ii *internalItem) bool {
+				ms.byID[ii.id] = ii
+				return true
+			})
+		}
+	}
+
+	for _, m := range mutations {
+		seq := atomic.LoadUint64(&ms.sequence)
+		if m.Seq <= seq {
+			continue
+		}
+		if m.Seq != seq+1 {
+			return ErrOutOfSync
+		}
+
+		ii, found := ms.byID[m.ID]
+		switch {
+		case m.Kind == MutationPut && !found:
+			ii = &internalItem{value: m.Item, id: m.ID}
+			if ii.id > ms.lastID {
+				ms.lastID = ii.id
+			}
+			ms.insert(ii)
+		case m.Kind == MutationPut && ms.indexesChanged(ii.value, m.Item):
+			ms.reposition(ii, m.Item)
+		case m.Kind == MutationPut:
+			ii.value = m.Item
+			ms.refresh(ii)
+		case m.Kind == MutationDelete && found:
+			for index := range ms.indexTree {
+				ms.delete(ii, index)
+			}
+			ms.record(MutationDelete, ii, atomic.AddUint64(&ms.sequence, 1))
+		default:
+			return ErrOutOfSync
+		}
+		op.addItems(1)
+	}
+
+	return nil
+}
+
+/*
+	Request of a follower, giving the sequence number it's at and the lineage it's in
+*/
+type followRequest struct {
+	Seq     uint64
+	Lineage uint64
+}
+
+/*
+	Message to a follower: a snapshot to start from, or mutations following the last message
+*/
+type replicationMessage struct {
+	Snapshot  *Snapshot
+	Mutations []Mutation
+}
+
+/*
+	Serve every follower connecting to a listener (see ServeFollower)
+
+	Returns once the listener fails, closing connections to followers
+*/
+func (ms *Memstore) ServeFollowers(l net.Listener) error {
+	if ms.opLog == nil {
+		return ErrNoOpLog
+	}
+
+	var m sync.Mutex
+	conns := map[net.Conn]bool{}
+	defer func() {
+		m.Lock()
+		defer m.Unlock()
+		for conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		m.Lock()
+		conns[conn] = true
+		m.Unlock()
+
+		go func() {
+			ms.ServeFollower(conn)
+			m.Lock()
+			delete(conns, conn)
+			m.Unlock()
+		}()
+	}
+}
+
+/*
+	Ship mutations to a follower until it disconnects
+
+	A follower of this lineage still covered by the operation log catches up from where it is,
+	others are sent a snapshot first.
+	Items are sent with encoding/gob, so their concrete types must be registered with gob.Register.
+*/
+func (ms *Memstore) ServeFollower(conn net.Conn) error {
+	defer conn.Close()
+
+	if ms.opLog == nil {
+		return ErrNoOpLog
+	}
+
+	var req followRequest
+	if err := gob.NewDecoder(conn).Decode(&req); err != nil {
+		return err
+	}
+
+	// Followers send nothing more, reading only tells when they are gone
+	gone := make(chan struct{})
+	go func() {
+		io.Copy(ioutil.Discard, conn)
+		close(gone)
+	}()
+
+	w := bufio.NewWriter(conn)
+	enc := gob.NewEncoder(w)
+	send := func(msg replicationMessage) error {
+		if err := enc.Encode(msg); err != nil {
+			return err
+		}
+		return w.Flush()
+	}
+
+	// Identities of another lineage mean other items, so its followers start over
+	seq := req.Seq
+	stale := req.Lineage != ms.currentLineage()
+	for {
+		mutations, changed, err := ms.opLog.since(seq, replicationBatch)
+		switch {
+		case stale || err == ErrLogTruncated:
+			snapshot := ms.Snapshot()
+			if err := send(replicationMessage{Snapshot: &snapshot}); err != nil {
+				return err
+			}
+			seq = snapshot.Seq
+			stale = false
+		case len(mutations) == 0:
+			select {
+			case <-changed:
+			case <-gone:
+				return nil
+			}
+		default:
+			if err := send(replicationMessage{Mutations: mutations}); err != nil {
+				return err
+			}
+			seq = mutations[len(mutations)-1].Seq
+		}
+	}
+}
+
+/*
+	Apply snapshots and mutations of a leader (see ServeFollower) until the connection fails
+
+	Following again after a failure catches up from where the store is.
+	Store should be a follower (see AsFollower), as its own writes put it out of sync.
+*/
+func (ms *Memstore) Follow(conn net.Conn) error {
+	defer conn.Close()
+
+	req := followRequest{Seq: ms.Sequence(), Lineage: ms.currentLineage()}
+	if err := gob.NewEncoder(conn).Encode(req); err != nil {
+		return err
+	}
+
+	dec := gob.NewDecoder(bufio.NewReader(conn))
+	for {
+		var msg replicationMessage
+		if err := dec.Decode(&msg); err != nil {
+			return err
+		}
+		if msg.Snapshot != nil {
+			ms.ApplySnapshot(*msg.Snapshot)
+		}
+		if err := ms.ApplyMutations(msg.Mutations); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package memstore
+
+import (
+	"encoding/gob"
+	"net"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+/*
+	Item with exported fields, so that it can be sent to followers
+*/
+type replicatedItem struct {
+	ID   int
+	Name string
+}
+
+func (ri replicatedItem) Less(index string, than interface{}) bool {
+	other := than.(replicatedItem)
+	if index == "name" && ri.Name != other.Name {
+		return ri.Name < other.Name
+	}
+	return ri.ID < other.ID
+}
+
+func init() {
+	gob.Register(replicatedItem{})
+}
+
+// Items of every index with their versions, to compare stores
+func replicaState(ms *Memstore) map[string][]interface{} {
+	res := map[string][]interface{}{}
+	for _, index := range []string{"id", "name"} {
+		ms.GetRangeBounds(All(), index, func(x Item) bool {
+			_, version := ms.GetWithVersion(x, "id")
+			res[index] = append(res[index], x, version)
+			return true
+		})
+	}
+	return res
+}
+
+func writeReplicated(ms *Memstore, from, to int) {
+	for id := from; id < to; id++ {
+		ms.Add(replicatedItem{id, string(rune('a' + id%26))})
+	}
+	for id := from; id < to; id += 3 {
+		ms.Delete(replicatedItem{ID: id}, "id")
+	}
+	for id := from + 1; id < to; id += 3 {
+		ms.UpdateWithIndexes(replicatedItem{ID: id}, "id", func(x Item) (Item, bool) {
+			return replicatedItem{id, "z" + x.(replicatedItem).Name}, true
+		})
+	}
+}
+
+func TestOpLog(t *testing.T) {
+	if _, err := New([]string{"id"}).Mutations(0, 10); err != ErrNoOpLog {
+		t.Errorf("Store without log should say so, err=%v", err)
+	}
+
+	ms := New([]string{"id", "name"}, WithOpLog(4))
+	ms.Add(replicatedItem{1, "a"})
+	ms.Add(replicatedItem{2, "b"})
+	ms.UpdateData(replicatedItem{ID: 1}, "id", func(x Item) (Item, bool) {
+		return replicatedItem{1, "a"}, true
+	})
+	ms.Delete(replicatedItem{ID: 2}, "id")
+
+	mutations, err := ms.Mutations(0, 10)
+	expected := []Mutation{
+		{1, MutationPut, 1, replicatedItem{1, "a"}},
+		{2, MutationPut, 2, replicatedItem{2, "b"}},
+		{3, MutationPut, 1, replicatedItem{1, "a"}},
+		{4, MutationDelete, 2, nil},
+	}
+	if err != nil || !reflect.DeepEqual(mutations, expected) {
+		t.Errorf("Logged mutations are wrong, mutations=%v err=%v", mutations, err)
+	}
+	if mutations, _ := ms.Mutations(2, 1); len(mutations) != 1 || mutations[0].Seq != 3 {
+		t.Errorf("Mutations should start after sequence number, mutations=%v", mutations)
+	}
+	if mutations, err := ms.Mutations(4, 10); len(mutations) != 0 || err != nil {
+		t.Errorf("Store shouldn't have later mutations, mutations=%v err=%v", mutations, err)
+	}
+
+	// Oldest mutations are dropped
+	ms.Add(replicatedItem{3, "c"})
+	if _, err := ms.Mutations(0, 10); err != ErrLogTruncated {
+		t.Errorf("Dropped mutations should be reported, err=%v", err)
+	}
+	if mutations, err := ms.Mutations(1, 10); len(mutations) != 4 || mutations[3].Seq != 5 || err != nil {
+		t.Errorf("Last mutations are wrong, mutations=%v err=%v", mutations, err)
+	}
+	if _, err := ms.Mutations(6, 10); err != ErrLogTruncated {
+		t.Errorf("Position ahead of log should be reported, err=%v", err)
+	}
+}
+
+func TestApplyMutations(t *testing.T) {
+	leader := New([]string{"id", "name"}, WithOpLog(1000))
+	writeReplicated(leader, 0, 30)
+
+	follower := New([]string{"id", "name"}, AsFollower())
+	follower.ApplySnapshot(leader.Snapshot())
+
+	writeReplicated(leader, 30, 60)
+	writeReplicated(leader, 60, 90)
+	mutations, err := leader.Mutations(follower.Sequence(), 1000)
+	if err != nil {
+		t.Fatalf("Getting mutations failed, err=%v", err)
+	}
+
+	// Mutations already applied are skipped
+	if err := follower.ApplyMutations(mutations[:10]); err != nil {
+		t.Fatalf("Applying mutations failed, err=%v", err)
+	}
+	if err := follower.ApplyMutations(mutations); err != nil {
+		t.Fatalf("Applying mutations failed, err=%v", err)
+	}
+	if !reflect.DeepEqual(replicaState(leader), replicaState(follower)) || follower.Sequence() != leader.Sequence() {
+		t.Error("Follower should have the same items and versions as leader")
+	}
+
+	// Missing mutations are reported
+	writeReplicated(leader, 90, 100)
+	mutations, _ = leader.Mutations(follower.Sequence(), 1000)
+	if err := follower.ApplyMutations(mutations[1:]); err != ErrOutOfSync {
+		t.Errorf("Gap in mutations should be reported, err=%v", err)
+	}
+
+	// Followers only change through their leader until promoted
+	if _, err := follower.Update(replicatedItem{ID: 1}, "id", func(x Item) (Item, bool) { return x, true }); err != ErrReadOnly {
+		t.Errorf("Updating follower should fail, err=%v", err)
+	}
+	seq := follower.Sequence()
+	current := follower.Get(replicatedItem{ID: 1}, "id")
+	follower.Add(replicatedItem{100, "x"})
+	if follower.AddOrGet(replicatedItem{101, "x"}) != nil || follower.Delete(replicatedItem{ID: 1}, "id") != nil ||
+		follower.CompareAndSwap(current, replicatedItem{1, "x"}, "id") ||
+		follower.UpdateWithIndexes(replicatedItem{ID: 1}, "id", func(x Item) (Item, bool) { return x, true }) != nil {
+		t.Error("Writing to follower should do nothing")
+	}
+	if follower.Sequence() != seq || follower.Get(replicatedItem{ID: 100}, "id") != nil || current == nil {
+		t.Error("Follower shouldn't change through writes")
+	}
+	if rejected := follower.Stats().RejectedWrites; rejected != 6 {
+		t.Errorf("Writes rejected by follower should be counted, rejected=%v", rejected)
+	}
+
+	follower.Promote()
+	follower.Add(replicatedItem{100, "x"})
+	if follower.IsFollower() || follower.Get(replicatedItem{ID: 100}, "id") == nil {
+		t.Error("Promoted follower should accept writes")
+	}
+}
+
+func TestApplySnapshotAggregates(t *testing.T) {
+	leader := New([]string{"id", "name"}, WithOpLog(10))
+	writeReplicated(leader, 0, 20)
+
+	follower := New([]string{"id", "name"}, AsFollower())
+	follower.AddAggregate("name", Count())
+	follower.ApplySnapshot(Snapshot{Items: []SnapshotItem{{ID: 1, Version: 1, Item: replicatedItem{1000, "x"}}}})
+	follower.ApplySnapshot(leader.Snapshot())
+
+	if count := follower.Aggregate("name", Unbounded(), Unbounded(), Count()); count != leader.Len() {
+		t.Errorf("Maintained aggregates should be rebuilt from snapshot, count=%v", count)
+	}
+	if report := follower.Verify(); !report.OK() {
+		t.Errorf("Follower should be consistent, report=%v", report)
+	}
+}
+
+// Wait until follower caught up with leader
+func waitReplicated(t *testing.T, leader, follower *Memstore) {
+	deadline := time.Now().Add(5 * time.Second)
+	for follower.Sequence() != leader.Sequence() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Follower didn't catch up, follower=%v leader=%v", follower.Sequence(), leader.Sequence())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if !reflect.DeepEqual(replicaState(leader), replicaState(follower)) {
+		t.Error("Follower should have the same items and versions as leader")
+	}
+}
+
+func TestReplicationOverConnection(t *testing.T) {
+	leader := New([]string{"id", "name"}, WithOpLog(50))
+	writeReplicated(leader, 0, 50)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listening failed, err=%v", err)
+	}
+	defer l.Close()
+	go leader.ServeFollowers(l)
+
+	var snapshots int32
+	follower := New([]string{"id", "name"}, AsFollower(), WithHooks(HookFuncs{
+		BeforeFunc: func(op *Operation) interface{} {
+			if op.Name == opApplySnapshot {
+				atomic.AddInt32(&snapshots, 1)
+			}
+			return nil
+		},
+	}))
+	follow := func() (net.Conn, chan error) {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatalf("Dialing failed, err=%v", err)
+		}
+		done := make(chan error)
+		go func() {
+			done <- follower.Follow(conn)
+		}()
+		return conn, done
+	}
+
+	// Initial sync from snapshot as log doesn't go back far enough, then mutations as they happen
+	conn, done := follow()
+	waitReplicated(t, leader, follower)
+	writeReplicated(leader, 50, 60)
+	waitReplicated(t, leader, follower)
+	if n := atomic.LoadInt32(&snapshots); n != 1 {
+		t.Errorf("Follower should start from a snapshot, snapshots=%v", n)
+	}
+
+	// Catching up from the log after a disconnection
+	conn.Close()
+	<-done
+	writeReplicated(leader, 60, 70)
+	conn, done = follow()
+	waitReplicated(t, leader, follower)
+	if n := atomic.LoadInt32(&snapshots); n != 1 {
+		t.Errorf("Follower should catch up from the log, snapshots=%v", n)
+	}
+
+	// Or from a snapshot once the log moved on
+	conn.Close()
+	<-done
+	writeReplicated(leader, 70, 270)
+	conn, done = follow()
+	waitReplicated(t, leader, follower)
+	if n := atomic.LoadInt32(&snapshots); n != 2 {
+		t.Errorf("Follower should be sent a snapshot, snapshots=%v", n)
+	}
+
+	// Leader going away ends replication
+	l.Close()
+	if err := <-done; err == nil {
+		t.Error("Following should fail once leader is gone")
+	}
+}
+
+func TestReplicationPipe(t *testing.T) {
+	leader := New([]string{"id", "name"}, WithOpLog(100))
+	follower := New([]string{"id", "name"}, AsFollower())
+
+	leaderConn, followerConn := net.Pipe()
+	go leader.ServeFollower(leaderConn)
+	go follower.Follow(followerConn)
+	defer followerConn.Close()
+
+	writeReplicated(leader, 0, 100)
+	waitReplicated(t, leader, follower)
+}
+
+func TestReplicationOtherLeader(t *testing.T) {
+	first := New([]string{"id", "name"}, WithOpLog(200))
+	second := New([]string{"id", "name"}, WithOpLog(200))
+	writeReplicated(first, 0, 30)
+	writeReplicated(second, 100, 140)
+	follower := New([]string{"id", "name"}, AsFollower())
+
+	follow := func(leader *Memstore) {
+		leaderConn, followerConn := net.Pipe()
+		go leader.ServeFollower(leaderConn)
+		done := make(chan error)
+		go func() {
+			done <- follower.Follow(followerConn)
+		}()
+		waitReplicated(t, leader, follower)
+		followerConn.Close()
+		<-done
+	}
+
+	// Log of the second leader covers where the follower is, but identities of its items mean others
+	follow(first)
+	follow(second)
+}
@@ -0,0 +1,232 @@
+package resp
+
+import (
+	"github.com/mngharbi/memstore"
+	"math"
+	"sync"
+)
+
+// Indexes of stores
+const (
+	byKey    = "key"
+	byMember = "member"
+	byScore  = "score"
+)
+
+/*
+	Value of a string key
+*/
+type stringEntry struct {
+	key   string
+	value string
+}
+
+func (e stringEntry) Less(index string, than interface{}) bool {
+	return e.key < than.(stringEntry).key
+}
+
+/*
+	Member of a sorted set
+
+	Sorted sets are ordered by key then member (byMember), or key, score and member (byScore)
+*/
+type memberEntry struct {
+	key    string
+	member string
+	score  float64
+
+	// Probe placed before (-1) or after (1) every member of its key
+	edge int
+}
+
+func (e memberEntry) Less(index string, than interface{}) bool {
+	other := than.(memberEntry)
+	switch {
+	case e.key != other.key:
+		return e.key < other.key
+	case e.edge != other.edge:
+		return e.edge < other.edge
+	case index == byScore && e.score != other.score:
+		return e.score < other.score
+	default:
+		return e.member < other.member
+	}
+}
+
+/*
+	Keys of a RESP server: strings and sorted sets, each in a store
+*/
+type keyspace struct {
+	strings *memstore.Memstore
+	members *memstore.Memstore
+
+	// Commands check the type of keys before writing, so they go one at a time
+	writes sync.Mutex
+}
+
+func newKeyspace(options ...memstore.Option) *keyspace {
+	ks := &keyspace{
+		strings: memstore.New([]string{byKey}, options...),
+		members: memstore.New([]string{byMember, byScore}, options...),
+	}
+
+	// Cardinalities and ranks are counted in logarithmic time
+	ks.members.AddAggregate(byScore, memstore.Count())
+
+	return ks
+}
+
+func (ks *keyspace) getString(key string) (string, bool) {
+	found := ks.strings.Get(stringEntry{key: key}, byKey)
+	if found == nil {
+		return "", false
+	}
+	return found.(stringEntry).value, true
+}
+
+// Set string, replacing any sorted set with the same key
+func (ks *keyspace) setString(key string, value string) {
+	ks.writes.Lock()
+	defer ks.writes.Unlock()
+
+	ks.deleteMembers(key)
+	ks.strings.Delete(stringEntry{key: key}, byKey)
+	ks.strings.Add(stringEntry{key: key, value: value})
+}
+
+func (ks *keyspace) isString(key string) bool {
+	_, ok := ks.getString(key)
+	return ok
+}
+
+// Bounds around every member of a sorted set
+func keyBounds(key string) (memstore.Bound, memstore.Bound) {
+	return memstore.Exclusive(memberEntry{key: key, edge: -1}), memstore.Exclusive(memberEntry{key: key, edge: 1})
+}
+
+func (ks *keyspace) cardinality(key string) int {
+	from, to := keyBounds(key)
+	return ks.members.Aggregate(byScore, from, to, memstore.Count()).(int)
+}
+
+func (ks *keyspace) isSortedSet(key string) bool {
+	return ks.cardinality(key) > 0
+}
+
+func (ks *keyspace) getMember(key string, member string) (memberEntry, bool) {
+	found := ks.members.Get(memberEntry{key: key, member: member}, byMember)
+	if found == nil {
+		return memberEntry{}, false
+	}
+	return found.(memberEntry), true
+}
+
+// Add or move members of a sorted set, returns number of members added and changed
+func (ks *keyspace) addMembers(key string, entries []memberEntry, onlyNew bool, onlyExisting bool) (added int, changed int, err string) {
+	ks.writes.Lock()
+	defer ks.writes.Unlock()
+
+	if ks.isString(key) {
+		return 0, 0, errWrongType
+	}
+
+	for _, entry := range entries {
+		entry.key = key
+		existing, ok := ks.getMember(key, entry.member)
+		switch {
+		case ok && !onlyNew && existing.score != entry.score:
+			ks.members.UpdateWithIndexes(existing, byMember, func(memstore.Item) (memstore.Item, bool) {
+				return entry, true
+			})
+			changed++
+		case !ok && !onlyExisting:
+			ks.members.Add(entry)
+			added++
+		}
+	}
+
+	return added, changed, ""
+}
+
+func (ks *keyspace) removeMembers(key string, members []string) int {
+	ks.writes.Lock()
+	defer ks.writes.Unlock()
+
+	removed := 0
+	for _, member := range members {
+		if ks.members.Delete(memberEntry{key: key, member: member}, byMember) != nil {
+			removed++
+		}
+	}
+	return removed
+}
+
+// Delete every member of a sorted set (expects writes lock)
+func (ks *keyspace) deleteMembers(key string) {
+	var members []memberEntry
+	from, to := keyBounds(key)
+	ks.members.GetRangeBounds(memstore.NewRange(from, to), byMember, func(x memstore.Item) bool {
+		members = append(members, x.(memberEntry))
+		return true
+	})
+	for _, member := range members {
+		ks.members.Delete(member, byMember)
+	}
+}
+
+// Position of a member in its sorted set by score
+func (ks *keyspace) rank(key string, member string) (int, bool) {
+	entry, ok := ks.getMember(key, member)
+	if !ok {
+		return 0, false
+	}
+	from, _ := keyBounds(key)
+	return ks.members.Aggregate(byScore, from, memstore.Exclusive(entry), memstore.Count()).(int), true
+}
+
+/*
+	Score interval of ZRANGEBYSCORE
+*/
+type scoreRange struct {
+	min, max                   float64
+	minExclusive, maxExclusive bool
+}
+
+func (sr scoreRange) above(score float64) bool {
+	return score > sr.min || (score == sr.min && !sr.minExclusive)
+}
+
+func (sr scoreRange) below(score float64) bool {
+	return score < sr.max || (score == sr.max && !sr.maxExclusive)
+}
+
+// Members of a sorted set within a score range by increasing score, skipping offset of them
+func (ks *keyspace) rangeByScore(key string, sr scoreRange, offset int, count int) []memberEntry {
+	res := []memberEntry{}
+	if count == 0 {
+		return res
+	}
+
+	from := memstore.Inclusive(memberEntry{key: key, score: sr.min})
+	if math.IsInf(sr.min, -1) {
+		from, _ = keyBounds(key)
+	}
+	_, to := keyBounds(key)
+
+	ks.members.GetRangeBounds(memstore.NewRange(from, to), byScore, func(x memstore.Item) bool {
+		entry := x.(memberEntry)
+		if !sr.above(entry.score) {
+			return true
+		}
+		if !sr.below(entry.score) {
+			return false
+		}
+		if offset > 0 {
+			offset--
+			return true
+		}
+		res = append(res, entry)
+		return count < 0 || len(res) < count
+	})
+	return res
+}
@@ -0,0 +1,173 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Limits on requests
+const (
+	maxArguments    = 1 << 20
+	maxBulkLength   = 64 << 20
+	maxInlineLength = 64 << 10
+)
+
+var errProtocol = errors.New("ERR Protocol error")
+
+/*
+	Reader of commands, sent as arrays of bulk strings or inline (as typed in a terminal)
+*/
+type reader struct {
+	r *bufio.Reader
+}
+
+func newReader(r io.Reader) *reader {
+	return &reader{r: bufio.NewReader(r)}
+}
+
+// Whether more input is already buffered (commands are pipelined)
+func (rd *reader) buffered() bool {
+	return rd.r.Buffered() > 0
+}
+
+func (rd *reader) line(limit int) (string, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := rd.r.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		line = append(line, chunk...)
+		if len(line) > limit {
+			return "", errProtocol
+		}
+		if !isPrefix {
+			return string(line), nil
+		}
+	}
+}
+
+// Read next command with its arguments, empty if the line was blank
+func (rd *reader) command() ([]string, error) {
+	first, err := rd.r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if first[0] != '*' {
+		line, err := rd.line(maxInlineLength)
+		if err != nil {
+			return nil, err
+		}
+		return strings.Fields(line), nil
+	}
+
+	header, err := rd.line(maxInlineLength)
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(header[1:])
+	if err != nil || count > maxArguments {
+		return nil, errProtocol
+	}
+	if count <= 0 {
+		return nil, nil
+	}
+
+	// Counts and lengths come from the client, so memory only grows with what it actually sends
+	var args []string
+	for i := 0; i < count; i++ {
+		header, err := rd.line(maxInlineLength)
+		if err != nil {
+			return nil, err
+		}
+		if len(header) == 0 || header[0] != '$' {
+			return nil, errProtocol
+		}
+		length, err := strconv.Atoi(header[1:])
+		if err != nil || length < 0 || length > maxBulkLength {
+			return nil, errProtocol
+		}
+
+		// Bulk string followed by CRLF
+		bulk, err := io.ReadAll(io.LimitReader(rd.r, int64(length)+2))
+		if err != nil {
+			return nil, err
+		}
+		if len(bulk) < length+2 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if bulk[length] != '\r' || bulk[length+1] != '\n' {
+			return nil, errProtocol
+		}
+		args = append(args, string(bulk[:length]))
+	}
+
+	return args, nil
+}
+
+/*
+	Writer of replies
+*/
+type writer struct {
+	w *bufio.Writer
+}
+
+func newWriter(w io.Writer) *writer {
+	return &writer{w: bufio.NewWriter(w)}
+}
+
+func (wr *writer) flush() error {
+	return wr.w.Flush()
+}
+
+func (wr *writer) status(s string) {
+	wr.w.WriteString("+" + s + "\r\n")
+}
+
+func (wr *writer) error(s string) {
+	wr.w.WriteString("-" + s + "\r\n")
+}
+
+func (wr *writer) integer(n int) {
+	wr.w.WriteString(":" + strconv.Itoa(n) + "\r\n")
+}
+
+func (wr *writer) bulk(s string) {
+	wr.w.WriteString("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+}
+
+func (wr *writer) null() {
+	wr.w.WriteString("$-1\r\n")
+}
+
+func (wr *writer) array(length int) {
+	wr.w.WriteString("*" + strconv.Itoa(length) + "\r\n")
+}
+
+// Format score the way Redis does
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	default:
+		return strconv.FormatFloat(score, 'g', -1, 64)
+	}
+}
+
+// Parse score, accepting infinities
+func parseScore(s string) (float64, bool) {
+	switch strings.ToLower(s) {
+	case "inf", "+inf":
+		return math.Inf(1), true
+	case "-inf":
+		return math.Inf(-1), true
+	}
+	score, err := strconv.ParseFloat(s, 64)
+	return score, err == nil && !math.IsNaN(score)
+}
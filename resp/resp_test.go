@@ -0,0 +1,203 @@
+package resp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+/*
+	Client sending commands as arrays of bulk strings, replies are read back as
+	strings ("+OK", "-ERR ...", ":1", "$-1" for null bulk) or slices of them for arrays
+*/
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func startServer(t *testing.T) (*testClient, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listening failed, err=%v", err)
+	}
+	s := New()
+	go s.Serve(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dialing failed, err=%v", err)
+	}
+	return &testClient{t: t, conn: conn, r: bufio.NewReader(conn)}, func() {
+		conn.Close()
+		s.Close()
+	}
+}
+
+func encode(args ...string) string {
+	res := "*" + strconv.Itoa(len(args)) + "\r\n"
+	for _, arg := range args {
+		res += "$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n"
+	}
+	return res
+}
+
+func (c *testClient) send(raw string) {
+	if _, err := c.conn.Write([]byte(raw)); err != nil {
+		c.t.Fatalf("Sending failed, err=%v", err)
+	}
+}
+
+func (c *testClient) reply() interface{} {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatalf("Reading reply failed, err=%v", err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+
+	switch line[0] {
+	case '$':
+		length, _ := strconv.Atoi(line[1:])
+		if length < 0 {
+			return line
+		}
+		bulk := make([]byte, length+2)
+		if _, err := io.ReadFull(c.r, bulk); err != nil {
+			c.t.Fatalf("Reading bulk failed, err=%v", err)
+		}
+		return string(bulk[:length])
+	case '*':
+		length, _ := strconv.Atoi(line[1:])
+		res := []string{}
+		for i := 0; i < length; i++ {
+			res = append(res, fmt.Sprint(c.reply()))
+		}
+		return res
+	default:
+		return line
+	}
+}
+
+func (c *testClient) do(args ...string) interface{} {
+	c.send(encode(args...))
+	return c.reply()
+}
+
+func (c *testClient) expect(expected interface{}, args ...string) {
+	if res := c.do(args...); fmt.Sprint(res) != fmt.Sprint(expected) {
+		c.t.Errorf("%v replied %q, expected %q", args, res, expected)
+	}
+}
+
+func TestStrings(t *testing.T) {
+	c, done := startServer(t)
+	defer done()
+
+	c.expect("+PONG", "PING")
+	c.expect("hello", "ping", "hello")
+	c.expect("$-1", "GET", "a")
+	c.expect("+OK", "SET", "a", "1")
+	c.expect("+OK", "SET", "a", "two words")
+	c.expect("two words", "GET", "a")
+	c.expect("+OK", "SET", "empty", "")
+	c.expect("", "GET", "empty")
+}
+
+func TestSortedSets(t *testing.T) {
+	c, done := startServer(t)
+	defer done()
+
+	c.expect(":3", "ZADD", "z", "1", "a", "2", "b", "2", "c")
+	c.expect(":0", "ZADD", "z", "3", "a")
+	c.expect(":1", "ZADD", "z", "CH", "0.5", "a", "2", "b")
+	c.expect(":3", "ZCARD", "z")
+	c.expect(":0", "ZCARD", "missing")
+
+	// NX only adds, XX only updates
+	c.expect(":1", "ZADD", "z", "NX", "9", "a", "3", "d")
+	c.expect(":0", "ZADD", "z", "XX", "9", "e", "-1", "d")
+	c.expect([]string{"d", "-1", "a", "0.5", "b", "2", "c", "2"}, "ZRANGEBYSCORE", "z", "-inf", "+inf", "WITHSCORES")
+
+	c.expect(":0", "ZRANK", "z", "d")
+	c.expect(":3", "ZRANK", "z", "c")
+	c.expect("$-1", "ZRANK", "z", "e")
+
+	c.expect([]string{"b", "c"}, "ZRANGEBYSCORE", "z", "(0.5", "2")
+	c.expect([]string{"a"}, "ZRANGEBYSCORE", "z", "0", "(2")
+	c.expect([]string{"a", "b"}, "ZRANGEBYSCORE", "z", "-inf", "inf", "LIMIT", "1", "2")
+	c.expect([]string{"c"}, "ZRANGEBYSCORE", "z", "2", "2", "LIMIT", "1", "-1")
+	c.expect([]string{}, "ZRANGEBYSCORE", "z", "3", "inf")
+
+	c.expect(":2", "ZREM", "z", "a", "b", "e")
+	c.expect([]string{"d", "c"}, "ZRANGEBYSCORE", "z", "-inf", "inf")
+
+	// Sorted sets are independent of each other
+	c.expect(":1", "ZADD", "y", "1", "a")
+	c.expect([]string{"a"}, "ZRANGEBYSCORE", "y", "-inf", "inf")
+	c.expect(":2", "ZCARD", "z")
+}
+
+func TestErrors(t *testing.T) {
+	c, done := startServer(t)
+	defer done()
+
+	c.expect("+OK", "SET", "s", "1")
+	c.expect(":1", "ZADD", "z", "1", "a")
+
+	c.expect("-"+errWrongType, "ZADD", "s", "1", "a")
+	c.expect("-"+errWrongType, "ZCARD", "s")
+	c.expect("-"+errWrongType, "GET", "z")
+	c.expect("-"+errNotFloat, "ZADD", "z", "x", "a")
+	c.expect("-"+errSyntax, "ZADD", "z", "NX", "1")
+	c.expect("-"+errSyntax, "ZRANGEBYSCORE", "z", "0", "1", "LIMIT", "1")
+	c.expect("-ERR min or max is not a float", "ZRANGEBYSCORE", "z", "a", "1")
+	c.expect("-ERR wrong number of arguments for 'get' command", "GET")
+	c.expect("-ERR unknown command 'NOPE'", "NOPE")
+
+	// Strings replace sorted sets
+	c.expect("+OK", "SET", "z", "1")
+	c.expect("1", "GET", "z")
+	c.expect("-"+errWrongType, "ZCARD", "z")
+}
+
+func TestPipelineAndInline(t *testing.T) {
+	c, done := startServer(t)
+	defer done()
+
+	c.send(encode("SET", "a", "1") + "GET a\r\n\r\n" + encode("ZADD", "z", "1", "m") + "ZCARD z\n")
+	for _, expected := range []string{"+OK", "1", ":1", ":1"} {
+		if res := c.reply(); res != expected {
+			t.Errorf("Pipelined reply is %q, expected %q", res, expected)
+		}
+	}
+
+	c.send("*1\r\n$4\r\nPING\r\n*1\r\n+PING\r\n")
+	if res := c.reply(); res != "+PONG" {
+		t.Errorf("Reply before protocol error is %q", res)
+	}
+	if res := c.reply(); res != "-"+errProtocol.Error() {
+		t.Errorf("Protocol error should be reported, res=%q", res)
+	}
+}
+
+func TestTruncatedCommand(t *testing.T) {
+	// Headers announcing more than is sent shouldn't allocate what they announce
+	for _, raw := range []string{"*1000000\r\n$4\r\nPING\r\n", "*1\r\n$60000000\r\nPING"} {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		args, err := newReader(strings.NewReader(raw)).command()
+		runtime.ReadMemStats(&after)
+
+		if err == nil {
+			t.Errorf("Reading truncated command should fail, args=%v", args)
+		}
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+			t.Errorf("Reading truncated command allocated too much, raw=%q allocated=%v", raw[:12], allocated)
+		}
+	}
+}
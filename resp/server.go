@@ -0,0 +1,353 @@
+/*
+	Front end speaking the Redis protocol (RESP), so that Redis clients can use memstore
+
+	Sorted sets (ZADD, ZRANGEBYSCORE, ZRANK, ZREM, ZCARD) and strings (GET, SET) are supported
+*/
+
+package resp
+
+import (
+	"github.com/mngharbi/memstore"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	errWrongType = "WRONGTYPE Operation against a key holding the wrong kind of value"
+	errSyntax    = "ERR syntax error"
+	errNotFloat  = "ERR value is not a valid float"
+	errNotInt    = "ERR value is not an integer or out of range"
+)
+
+/*
+	RESP server, holding its own keys
+*/
+type Server struct {
+	ks *keyspace
+
+	m         sync.Mutex
+	listeners map[net.Listener]bool
+	conns     map[net.Conn]bool
+	closed    bool
+}
+
+// Server whose stores are created with options
+func New(options ...memstore.Option) *Server {
+	return &Server{
+		ks:        newKeyspace(options...),
+		listeners: map[net.Listener]bool{},
+		conns:     map[net.Conn]bool{},
+	}
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Accept connections until the listener fails or the server is closed
+func (s *Server) Serve(l net.Listener) error {
+	s.m.Lock()
+	if s.closed {
+		s.m.Unlock()
+		l.Close()
+		return net.ErrClosed
+	}
+	s.listeners[l] = true
+	s.m.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.m.Lock()
+			delete(s.listeners, l)
+			closed := s.closed
+			s.m.Unlock()
+			if closed {
+				return net.ErrClosed
+			}
+			return err
+		}
+
+		s.m.Lock()
+		s.conns[conn] = true
+		s.m.Unlock()
+
+		go s.serveConn(conn)
+	}
+}
+
+// Stop listening and close every connection
+func (s *Server) Close() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return nil
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		s.m.Lock()
+		delete(s.conns, conn)
+		s.m.Unlock()
+		conn.Close()
+	}()
+
+	rd := newReader(conn)
+	wr := newWriter(conn)
+
+	for {
+		args, err := rd.command()
+		if err == errProtocol {
+			wr.error(err.Error())
+			wr.flush()
+			return
+		}
+		if err != nil {
+			if err != io.EOF {
+				wr.flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		quit := strings.ToUpper(args[0]) == "QUIT"
+		if quit {
+			wr.status("OK")
+		} else {
+			s.execute(wr, args)
+		}
+
+		// Flush once pipelined commands are all answered
+		if quit || !rd.buffered() {
+			if wr.flush() != nil || quit {
+				return
+			}
+		}
+	}
+}
+
+/*
+	Command with the number of arguments it takes (including its name),
+	negative for at least that many
+*/
+type command struct {
+	arity int
+	run   func(s *Server, wr *writer, args []string)
+}
+
+var commands = map[string]command{
+	"PING":          {-1, (*Server).ping},
+	"COMMAND":       {-1, (*Server).command},
+	"GET":           {2, (*Server).get},
+	"SET":           {3, (*Server).set},
+	"ZADD":          {-4, (*Server).zadd},
+	"ZREM":          {-3, (*Server).zrem},
+	"ZCARD":         {2, (*Server).zcard},
+	"ZRANK":         {3, (*Server).zrank},
+	"ZRANGEBYSCORE": {-4, (*Server).zrangebyscore},
+}
+
+func (s *Server) execute(wr *writer, args []string) {
+	name := strings.ToUpper(args[0])
+	cmd, ok := commands[name]
+	if !ok {
+		wr.error("ERR unknown command '" + args[0] + "'")
+		return
+	}
+	if (cmd.arity > 0 && len(args) != cmd.arity) || (cmd.arity < 0 && len(args) < -cmd.arity) {
+		wr.error("ERR wrong number of arguments for '" + strings.ToLower(name) + "' command")
+		return
+	}
+	cmd.run(s, wr, args)
+}
+
+func (s *Server) ping(wr *writer, args []string) {
+	switch len(args) {
+	case 1:
+		wr.status("PONG")
+	case 2:
+		wr.bulk(args[1])
+	default:
+		wr.error("ERR wrong number of arguments for 'ping' command")
+	}
+}
+
+// Clients ask for command documentation on startup, none is given
+func (s *Server) command(wr *writer, args []string) {
+	wr.array(0)
+}
+
+func (s *Server) get(wr *writer, args []string) {
+	value, ok := s.ks.getString(args[1])
+	switch {
+	case ok:
+		wr.bulk(value)
+	case s.ks.isSortedSet(args[1]):
+		wr.error(errWrongType)
+	default:
+		wr.null()
+	}
+}
+
+func (s *Server) set(wr *writer, args []string) {
+	s.ks.setString(args[1], args[2])
+	wr.status("OK")
+}
+
+// ZADD key [NX|XX] [CH] score member [score member ...]
+func (s *Server) zadd(wr *writer, args []string) {
+	var onlyNew, onlyExisting, countChanged bool
+
+	i := 2
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			onlyNew = true
+		case "XX":
+			onlyExisting = true
+		case "CH":
+			countChanged = true
+		default:
+			break options
+		}
+	}
+
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		wr.error(errSyntax)
+		return
+	}
+	if onlyNew && onlyExisting {
+		wr.error("ERR XX and NX options at the same time are not compatible")
+		return
+	}
+
+	entries := make([]memberEntry, 0, len(pairs)/2)
+	for j := 0; j < len(pairs); j += 2 {
+		score, ok := parseScore(pairs[j])
+		if !ok {
+			wr.error(errNotFloat)
+			return
+		}
+		entries = append(entries, memberEntry{member: pairs[j+1], score: score})
+	}
+
+	added, changed, err := s.ks.addMembers(args[1], entries, onlyNew, onlyExisting)
+	switch {
+	case err != "":
+		wr.error(err)
+	case countChanged:
+		wr.integer(added + changed)
+	default:
+		wr.integer(added)
+	}
+}
+
+func (s *Server) zrem(wr *writer, args []string) {
+	if s.ks.isString(args[1]) {
+		wr.error(errWrongType)
+		return
+	}
+	wr.integer(s.ks.removeMembers(args[1], args[2:]))
+}
+
+func (s *Server) zcard(wr *writer, args []string) {
+	if s.ks.isString(args[1]) {
+		wr.error(errWrongType)
+		return
+	}
+	wr.integer(s.ks.cardinality(args[1]))
+}
+
+func (s *Server) zrank(wr *writer, args []string) {
+	if s.ks.isString(args[1]) {
+		wr.error(errWrongType)
+		return
+	}
+	rank, ok := s.ks.rank(args[1], args[2])
+	if !ok {
+		wr.null()
+		return
+	}
+	wr.integer(rank)
+}
+
+// Parse bound of a score range, "(" making it exclusive
+func parseScoreBound(s string) (float64, bool, bool) {
+	exclusive := strings.HasPrefix(s, "(")
+	score, ok := parseScore(strings.TrimPrefix(s, "("))
+	return score, exclusive, ok
+}
+
+// ZRANGEBYSCORE key min max [WITHSCORES] [LIMIT offset count]
+func (s *Server) zrangebyscore(wr *writer, args []string) {
+	var sr scoreRange
+	var minOK, maxOK bool
+	sr.min, sr.minExclusive, minOK = parseScoreBound(args[2])
+	sr.max, sr.maxExclusive, maxOK = parseScoreBound(args[3])
+	if !minOK || !maxOK {
+		wr.error("ERR min or max is not a float")
+		return
+	}
+
+	withScores := false
+	offset, count := 0, -1
+	for i := 4; i < len(args); i++ {
+		switch {
+		case strings.ToUpper(args[i]) == "WITHSCORES":
+			withScores = true
+		case strings.ToUpper(args[i]) == "LIMIT" && i+2 < len(args):
+			var err1, err2 error
+			offset, err1 = strconv.Atoi(args[i+1])
+			count, err2 = strconv.Atoi(args[i+2])
+			if err1 != nil || err2 != nil {
+				wr.error(errNotInt)
+				return
+			}
+			i += 2
+		default:
+			wr.error(errSyntax)
+			return
+		}
+	}
+
+	if s.ks.isString(args[1]) {
+		wr.error(errWrongType)
+		return
+	}
+
+	// Negative offset returns nothing, negative count everything after offset
+	var entries []memberEntry
+	if offset >= 0 {
+		entries = s.ks.rangeByScore(args[1], sr, offset, count)
+	}
+
+	if withScores {
+		wr.array(2 * len(entries))
+	} else {
+		wr.array(len(entries))
+	}
+	for _, entry := range entries {
+		wr.bulk(entry.member)
+		if withScores {
+			wr.bulk(formatScore(entry.score))
+		}
+	}
+}
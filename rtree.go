@@ -0,0 +1,297 @@
+package memstore
+
+import (
+	"math"
+)
+
+// Bounds on entries of R-tree nodes
+const (
+	rtreeMaxEntries = 16
+	rtreeMinEntries = 6
+)
+
+/*
+	Entry of an R-tree node: a child node with the box covering it, or an item at a point (as an empty box)
+*/
+type rtreeEntry struct {
+	box   Box
+	child *rtreeNode
+	ii    *internalItem
+}
+
+type rtreeNode struct {
+	leaf    bool
+	entries []rtreeEntry
+	parent  *rtreeNode
+}
+
+/*
+	R-tree of items located by points (Guttman, with quadratic splits)
+
+	Every node but the root holds between rtreeMinEntries and rtreeMaxEntries entries, leaves being at the same depth.
+*/
+type rtree struct {
+	root *rtreeNode
+	size int
+}
+
+func newRTree() *rtree {
+	return &rtree{root: &rtreeNode{leaf: true}}
+}
+
+func pointBox(p Point) Box {
+	return Box{Min: p, Max: p}
+}
+
+func (b Box) union(other Box) Box {
+	return Box{
+		Min: Point{math.Min(b.Min.Lat, other.Min.Lat), math.Min(b.Min.Lon, other.Min.Lon)},
+		Max: Point{math.Max(b.Max.Lat, other.Max.Lat), math.Max(b.Max.Lon, other.Max.Lon)},
+	}
+}
+
+func (b Box) intersects(other Box) bool {
+	return b.Min.Lat <= other.Max.Lat && other.Min.Lat <= b.Max.Lat && b.Min.Lon <= other.Max.Lon && other.Min.Lon <= b.Max.Lon
+}
+
+func (b Box) contains(p Point) bool {
+	return b.Min.Lat <= p.Lat && p.Lat <= b.Max.Lat && b.Min.Lon <= p.Lon && p.Lon <= b.Max.Lon
+}
+
+// Area plus half perimeter, so that boxes of aligned points still grow when enlarged
+func (b Box) extent() float64 {
+	dLat, dLon := b.Max.Lat-b.Min.Lat, b.Max.Lon-b.Min.Lon
+	return dLat*dLon + dLat + dLon
+}
+
+func (n *rtreeNode) box() Box {
+	box := n.entries[0].box
+	for _, e := range n.entries[1:] {
+		box = box.union(e.box)
+	}
+	return box
+}
+
+func (t *rtree) insert(ii *internalItem, p Point) {
+	t.size++
+	t.insertEntry(rtreeEntry{box: pointBox(p), ii: ii})
+}
+
+// Add an item entry to the leaf needing the least enlargement, splitting nodes up the tree as needed
+func (t *rtree) insertEntry(entry rtreeEntry) {
+	node := t.root
+	for !node.leaf {
+		best := 0
+		bestGrowth, bestExtent := math.Inf(1), math.Inf(1)
+		for i, e := range node.entries {
+			extent := e.box.extent()
+			growth := e.box.union(entry.box).extent() - extent
+			if growth < bestGrowth || (growth == bestGrowth && extent < bestExtent) {
+				best, bestGrowth, bestExtent = i, growth, extent
+			}
+		}
+		node = node.entries[best].child
+	}
+
+	node.entries = append(node.entries, entry)
+	var split *rtreeNode
+	if len(node.entries) > rtreeMaxEntries {
+		split = t.split(node)
+	}
+	t.adjust(node, split)
+}
+
+// Update boxes from a node up to the root, adding the node split from it to its parent
+func (t *rtree) adjust(node, split *rtreeNode) {
+	for node != t.root {
+		parent := node.parent
+		parent.entries[parent.find(node)].box = node.box()
+		if split != nil {
+			split.parent = parent
+			parent.entries = append(parent.entries, rtreeEntry{box: split.box(), child: split})
+			split = nil
+			if len(parent.entries) > rtreeMaxEntries {
+				split = t.split(parent)
+			}
+		}
+		node = parent
+	}
+
+	if split != nil {
+		root := &rtreeNode{}
+		for _, child := range []*rtreeNode{node, split} {
+			child.parent = root
+			root.entries = append(root.entries, rtreeEntry{box: child.box(), child: child})
+		}
+		t.root = root
+	}
+}
+
+// Position of the entry of a child
+func (n *rtreeNode) find(child *rtreeNode) int {
+	for i, e := range n.entries {
+		if e.child == child {
+			return i
+		}
+	}
+	panic("memstore: R-tree node missing from its parent")
+}
+
+// Move about half the entries of an overflowing node to a new node (quadratic split)
+func (t *rtree) split(node *rtreeNode) *rtreeNode {
+	entries := node.entries
+
+	// Seeds are the pair of entries wasting the most space together
+	seedA, seedB := 0, 1
+	worst := math.Inf(-1)
+	for i := range entries {
+		for j := i + 1; j < len(entries); j++ {
+			waste := entries[i].box.union(entries[j].box).extent() - entries[i].box.extent() - entries[j].box.extent()
+			if waste > worst {
+				seedA, seedB, worst = i, j, waste
+			}
+		}
+	}
+
+	groups := [2][]rtreeEntry{{entries[seedA]}, {entries[seedB]}}
+	boxes := [2]Box{entries[seedA].box, entries[seedB].box}
+	var rest []rtreeEntry
+	for i, e := range entries {
+		if i != seedA && i != seedB {
+			rest = append(rest, e)
+		}
+	}
+
+	for len(rest) > 0 {
+		// Fill a group that needs every remaining entry to reach the minimum
+		for g := range groups {
+			if len(groups[g])+len(rest) == rtreeMinEntries {
+				groups[g] = append(groups[g], rest...)
+				rest = nil
+			}
+		}
+		if len(rest) == 0 {
+			break
+		}
+
+		// Assign the entry with the strongest preference for a group
+		next, preference := 0, math.Inf(-1)
+		for i, e := range rest {
+			growthA := boxes[0].union(e.box).extent() - boxes[0].extent()
+			growthB := boxes[1].union(e.box).extent() - boxes[1].extent()
+			if d := math.Abs(growthA - growthB); d > preference {
+				next, preference = i, d
+			}
+		}
+		e := rest[next]
+		rest = append(rest[:next], rest[next+1:]...)
+
+		growthA := boxes[0].union(e.box).extent() - boxes[0].extent()
+		growthB := boxes[1].union(e.box).extent() - boxes[1].extent()
+		g := 0
+		switch {
+		case growthA > growthB:
+			g = 1
+		case growthA == growthB && boxes[0].extent() > boxes[1].extent():
+			g = 1
+		case growthA == growthB && boxes[0].extent() == boxes[1].extent() && len(groups[0]) > len(groups[1]):
+			g = 1
+		}
+		groups[g] = append(groups[g], e)
+		boxes[g] = boxes[g].union(e.box)
+	}
+
+	node.entries = groups[0]
+	sibling := &rtreeNode{leaf: node.leaf, entries: groups[1]}
+	for _, e := range sibling.entries {
+		if e.child != nil {
+			e.child.parent = sibling
+		}
+	}
+	return sibling
+}
+
+// Remove the entry of an item located at a point, reinserting items of nodes left underfull
+func (t *rtree) remove(id uint64, p Point) bool {
+	leaf, at := t.findLeaf(t.root, id, p)
+	if leaf == nil {
+		return false
+	}
+	leaf.entries = append(leaf.entries[:at], leaf.entries[at+1:]...)
+	t.size--
+
+	// Condense tree, collecting entries of dropped nodes
+	var orphans []rtreeEntry
+	node := leaf
+	for node != t.root {
+		parent := node.parent
+		at := parent.find(node)
+		if len(node.entries) < rtreeMinEntries {
+			parent.entries = append(parent.entries[:at], parent.entries[at+1:]...)
+			orphans = node.collect(orphans)
+		} else {
+			parent.entries[at].box = node.box()
+		}
+		node = parent
+	}
+
+	for !t.root.leaf && len(t.root.entries) == 1 {
+		t.root = t.root.entries[0].child
+		t.root.parent = nil
+	}
+	if !t.root.leaf && len(t.root.entries) == 0 {
+		t.root = &rtreeNode{leaf: true}
+	}
+
+	for _, e := range orphans {
+		t.insertEntry(e)
+	}
+	return true
+}
+
+// Leaf holding the entry of an item, and its position
+func (t *rtree) findLeaf(node *rtreeNode, id uint64, p Point) (*rtreeNode, int) {
+	for i, e := range node.entries {
+		if !e.box.contains(p) {
+			continue
+		}
+		if node.leaf {
+			if e.ii.id == id {
+				return node, i
+			}
+			continue
+		}
+		if leaf, at := t.findLeaf(e.child, id, p); leaf != nil {
+			return leaf, at
+		}
+	}
+	return nil, 0
+}
+
+// Item entries of a subtree
+func (n *rtreeNode) collect(res []rtreeEntry) []rtreeEntry {
+	if n.leaf {
+		return append(res, n.entries...)
+	}
+	for _, e := range n.entries {
+		res = e.child.collect(res)
+	}
+	return res
+}
+
+// Iterate over items located in a box, until iterator returns false
+func (t *rtree) search(node *rtreeNode, box Box, iterator func(*internalItem, Point) bool) bool {
+	for _, e := range node.entries {
+		if !e.box.intersects(box) {
+			continue
+		}
+		if node.leaf {
+			if !iterator(e.ii, e.box.Min) {
+				return false
+			}
+		} else if !t.search(e.child, box, iterator) {
+			return false
+		}
+	}
+	return true
+}
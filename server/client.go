@@ -0,0 +1,292 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mngharbi/memstore"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Number of attempts of UpdateData while the item keeps changing, and longest pause between them
+const (
+	updateAttempts = 64
+	updateBackoff  = 10 * time.Millisecond
+)
+
+/*
+	Client of a store hosted by a server, implementing memstore.Store
+
+	Items are sent as JSON objects (see Document) and come back as Documents.
+	Methods of memstore.Store return nil or zero on failure, Err tells what went wrong.
+*/
+type Client struct {
+	url  string
+	http *http.Client
+
+	m   sync.Mutex
+	err error
+}
+
+var _ memstore.Store = (*Client)(nil)
+
+// Client of a store of a server (e.g. "http://localhost:7070"), using http.DefaultClient if none is given
+func NewClient(server string, store string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{
+		url:  strings.TrimRight(server, "/") + "/stores/" + url.PathEscape(store),
+		http: httpClient,
+	}
+}
+
+// Get and clear the last error of methods of memstore.Store
+func (c *Client) Err() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	err := c.err
+	c.err = nil
+	return err
+}
+
+func (c *Client) fail(err error) {
+	c.m.Lock()
+	c.err = err
+	c.m.Unlock()
+}
+
+// Create store on the server
+func (c *Client) CreateStore(schema Schema) error {
+	return c.do(http.MethodPut, c.url, schema, nil)
+}
+
+// Drop store from the server
+func (c *Client) DropStore() error {
+	return c.do(http.MethodDelete, c.url, nil, nil)
+}
+
+func (c *Client) do(method string, url string, body interface{}, res interface{}) error {
+	var encoded bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&encoded).Encode(body); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, url, &encoded)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+
+	if resp.StatusCode != http.StatusOK {
+		var failure errorResponse
+		if err := decoder.Decode(&failure); err != nil {
+			return fmt.Errorf("memstore/server: %v", resp.Status)
+		}
+		switch {
+		case resp.StatusCode == http.StatusConflict:
+			return memstore.ErrConflict
+		case failure.Error == ErrUnknownStore.Error():
+			return ErrUnknownStore
+		default:
+			return errors.New(failure.Error)
+		}
+	}
+
+	if res == nil {
+		return nil
+	}
+	return decoder.Decode(res)
+}
+
+func (c *Client) call(method string, req request) (res response, err error) {
+	err = c.do(http.MethodPost, c.url+"/"+method, req, &res)
+	return res, err
+}
+
+// Convert item to a document through JSON, unless it already is one
+func toDocument(x memstore.Item) (Document, error) {
+	if doc, ok := x.(Document); ok {
+		return doc, nil
+	}
+
+	encoded, err := json.Marshal(x)
+	if err != nil {
+		return nil, err
+	}
+	var doc Document
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("memstore/server: item isn't a JSON object: %v", err)
+	}
+	return doc, nil
+}
+
+// Document as an item, nil if there is none
+func toItem(doc Document) memstore.Item {
+	if doc == nil {
+		return nil
+	}
+	return doc
+}
+
+// Call a method about an item, returns item of response
+func (c *Client) callItem(method string, x memstore.Item, index string) memstore.Item {
+	var req request
+	if x != nil {
+		doc, err := toDocument(x)
+		if err != nil {
+			c.fail(err)
+			return nil
+		}
+		req.Item = doc
+	}
+	req.Index = index
+
+	res, err := c.call(method, req)
+	if err != nil {
+		c.fail(err)
+		return nil
+	}
+	return toItem(res.Item)
+}
+
+// Add item, replacing the one with the same primary key
+func (c *Client) Add(x memstore.Item) {
+	c.callItem(methodAdd, x, "")
+}
+
+func (c *Client) Get(x memstore.Item, index string) memstore.Item {
+	return c.callItem(methodGet, x, index)
+}
+
+func (c *Client) Delete(x memstore.Item, index string) memstore.Item {
+	return c.callItem(methodDelete, x, index)
+}
+
+func (c *Client) Min(index string) memstore.Item {
+	return c.callItem(methodMin, nil, index)
+}
+
+func (c *Client) Max(index string) memstore.Item {
+	return c.callItem(methodMax, nil, index)
+}
+
+func (c *Client) Len() int {
+	res, err := c.call(methodLen, request{})
+	if err != nil {
+		c.fail(err)
+		return 0
+	}
+	return res.Len
+}
+
+/*
+	Iterate over items from (inclusive) to (exclusive), fetched a page at a time
+
+	Nil bounds are unbounded
+*/
+func (c *Client) GetRange(from, to memstore.Item, index string, test func(memstore.Item) bool) {
+	req := request{Index: index, Limit: defaultPageSize}
+	var err error
+	if from != nil {
+		if req.From, err = toDocument(from); err != nil {
+			c.fail(err)
+			return
+		}
+	}
+	if to != nil {
+		if req.To, err = toDocument(to); err != nil {
+			c.fail(err)
+			return
+		}
+	}
+
+	for {
+		res, err := c.call(methodRange, req)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		for _, doc := range res.Items {
+			if !test(doc) {
+				return
+			}
+		}
+		if !res.More || len(res.Items) == 0 {
+			return
+		}
+		req.After = res.Items[len(res.Items)-1]
+	}
+}
+
+/*
+	Update an item with modify, retrying while it is changed by others
+
+	Unlike in-process stores, modify can change indexed fields
+*/
+func (c *Client) UpdateData(x memstore.Item, index string, modify func(memstore.Item) (memstore.Item, bool)) memstore.Item {
+	doc, err := toDocument(x)
+	if err != nil {
+		c.fail(err)
+		return nil
+	}
+
+	for attempt := 0; attempt < updateAttempts; attempt++ {
+		current, err := c.call(methodGet, request{Index: index, Item: doc})
+		if err != nil {
+			c.fail(err)
+			return nil
+		}
+		if current.Item == nil {
+			return nil
+		}
+
+		modified, ok := modify(current.Item)
+		if !ok {
+			return nil
+		}
+		updated, err := toDocument(modified)
+		if err != nil {
+			c.fail(err)
+			return nil
+		}
+
+		res, err := c.call(methodUpdate, request{Index: index, Item: current.Item, Version: current.Version, Updated: updated})
+		if err == memstore.ErrConflict {
+			// Pause a little so that concurrent updates don't keep colliding
+			pause := time.Duration(attempt+1) * time.Millisecond
+			if pause > updateBackoff {
+				pause = updateBackoff
+			}
+			time.Sleep(time.Duration(rand.Int63n(int64(pause))))
+			continue
+		}
+		if err != nil {
+			c.fail(err)
+			return nil
+		}
+		return toItem(res.Item)
+	}
+
+	c.fail(memstore.ErrConflict)
+	return nil
+}
@@ -0,0 +1,69 @@
+package server
+
+/*
+	HTTP+JSON API
+
+	PUT    /stores/{store}           create store, body is its Schema
+	GET    /stores/{store}           get schema of store
+	DELETE /stores/{store}           drop store
+	GET    /stores                   list stores and their schemas
+	POST   /stores/{store}/{method}  call a method with a request, see below
+	GET    /stats                    get Stats of every store, by name (see memstore.Stats)
+
+	Errors are answered as {"error": "..."} with status 400 (bad request), 404 (unknown store),
+	409 (conflicting version) or 405 (wrong HTTP method)
+*/
+
+// Methods of hosted stores
+const (
+	methodAdd    = "add"
+	methodGet    = "get"
+	methodDelete = "delete"
+	methodRange  = "range"
+	methodLen    = "len"
+	methodMin    = "min"
+	methodMax    = "max"
+	methodUpdate = "update"
+)
+
+// Default and maximum number of items of a range page
+const (
+	defaultPageSize = 100
+	maxPageSize     = 10000
+)
+
+/*
+	Request of every method, fields used depend on the method
+
+	add:    Item
+	get:    Item, Index (returns Item and Version)
+	delete: Item, Index (returns Item)
+	range:  Index, From (inclusive), To (exclusive), After (exclusive, overrides From), Limit
+	        (returns Items and More), missing bounds are unbounded
+	len:    (returns Len)
+	min:    Index (returns Item)
+	max:    Index (returns Item)
+	update: Item, Index, Version, Updated (returns Item and Version), fails with 409 if version moved
+*/
+type request struct {
+	Index   string   `json:"index,omitempty"`
+	Item    Document `json:"item,omitempty"`
+	From    Document `json:"from,omitempty"`
+	To      Document `json:"to,omitempty"`
+	After   Document `json:"after,omitempty"`
+	Limit   int      `json:"limit,omitempty"`
+	Version uint64   `json:"version,omitempty"`
+	Updated Document `json:"updated,omitempty"`
+}
+
+type response struct {
+	Item    Document   `json:"item,omitempty"`
+	Items   []Document `json:"items,omitempty"`
+	More    bool       `json:"more,omitempty"`
+	Version uint64     `json:"version,omitempty"`
+	Len     int        `json:"len"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
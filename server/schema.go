@@ -0,0 +1,245 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+/*
+	Item of a hosted store, as a JSON object
+
+	Values of indexed fields are compared as null < booleans < numbers < strings
+*/
+type Document map[string]interface{}
+
+// Compare documents on the field named like the index (hosted stores compare them with their schema)
+func (d Document) Less(index string, than interface{}) bool {
+	return compareValues(d[index], than.(Document)[index]) < 0
+}
+
+/*
+	Index of a hosted store, ordering documents by some of their fields
+
+	Documents equal on the fields of a non-unique index are ordered by the primary index,
+	while writes giving a document the fields of another one in a unique index fail
+*/
+type Index struct {
+	Name   string   `json:"name"`
+	Fields []string `json:"fields"`
+	Unique bool     `json:"unique,omitempty"`
+}
+
+/*
+	Indexes of a hosted store, the first one being the primary index (always unique)
+*/
+type Schema struct {
+	Indexes []Index `json:"indexes"`
+}
+
+var ErrInvalidSchema = errors.New("memstore/server: invalid schema")
+
+// Schema checked and ready to compare documents
+type schema struct {
+	Schema
+
+	// Fields compared for every index, including those of the primary index for non-unique ones
+	fields map[string][]string
+}
+
+func compileSchema(s Schema) (*schema, error) {
+	if len(s.Indexes) == 0 {
+		return nil, fmt.Errorf("%w: no index", ErrInvalidSchema)
+	}
+
+	compiled := &schema{
+		Schema: s,
+		fields: map[string][]string{},
+	}
+	compiled.Indexes = append([]Index(nil), s.Indexes...)
+	compiled.Indexes[0].Unique = true
+
+	primary := compiled.Indexes[0].Fields
+	for _, index := range compiled.Indexes {
+		if index.Name == "" || len(index.Fields) == 0 {
+			return nil, fmt.Errorf("%w: index %q has no name or fields", ErrInvalidSchema, index.Name)
+		}
+		if _, ok := compiled.fields[index.Name]; ok {
+			return nil, fmt.Errorf("%w: index %q defined twice", ErrInvalidSchema, index.Name)
+		}
+
+		fields := append([]string(nil), index.Fields...)
+		if !index.Unique {
+			fields = append(fields, primary...)
+		}
+		compiled.fields[index.Name] = fields
+	}
+
+	return compiled, nil
+}
+
+func (s *schema) names() []string {
+	names := make([]string, len(s.Indexes))
+	for i, index := range s.Indexes {
+		names[i] = index.Name
+	}
+	return names
+}
+
+// Names of unique indexes other than the primary one
+func (s *schema) unique() []string {
+	var names []string
+	for _, index := range s.Indexes[1:] {
+		if index.Unique {
+			names = append(names, index.Name)
+		}
+	}
+	return names
+}
+
+func (s *schema) primary() Index {
+	return s.Indexes[0]
+}
+
+func (s *schema) compare(index string, a, b Document) int {
+	for _, field := range s.fields[index] {
+		if comparison := compareValues(a[field], b[field]); comparison != 0 {
+			return comparison
+		}
+	}
+	return 0
+}
+
+// Check whether documents are equal on the fields of an index, ignoring the primary index
+func (s *schema) sameKey(index Index, a, b Document) bool {
+	for _, field := range index.Fields {
+		if compareValues(a[field], b[field]) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Check that indexed fields of a document to store can be compared
+func (s *schema) validate(d Document) error {
+	for _, field := range s.primary().Fields {
+		if d[field] == nil {
+			return fmt.Errorf("memstore/server: document has no %q field of primary index", field)
+		}
+	}
+	for _, index := range s.Indexes {
+		for _, field := range index.Fields {
+			if valueRank(d[field]) < 0 {
+				return fmt.Errorf("memstore/server: field %q of index %q can't be compared", field, index.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// Document stored in a hosted store, compared using its schema
+type record struct {
+	doc    Document
+	schema *schema
+}
+
+func (r record) Less(index string, than interface{}) bool {
+	return r.schema.compare(index, r.doc, than.(record).doc) < 0
+}
+
+// Position of the type of a value in the ordering, -1 if it can't be compared
+func valueRank(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case json.Number, float64, float32, int, int64, int32, uint, uint64, uint32:
+		return 2
+	case string:
+		return 3
+	default:
+		return -1
+	}
+}
+
+func compareValues(a, b interface{}) int {
+	ra, rb := valueRank(a), valueRank(b)
+	if ra != rb {
+		return threeWay(ra < rb, ra > rb)
+	}
+
+	switch x := a.(type) {
+	case bool:
+		y := b.(bool)
+		return threeWay(!x && y, x && !y)
+	case string:
+		return strings.Compare(x, b.(string))
+	}
+
+	if ra == 2 {
+		// Compare as integers when both are, to keep precision
+		xi, xInt := toInt(a)
+		yi, yInt := toInt(b)
+		if xInt && yInt {
+			return threeWay(xi < yi, xi > yi)
+		}
+		xf, yf := toFloat(a), toFloat(b)
+		return threeWay(xf < yf, xf > yf)
+	}
+
+	return 0
+}
+
+func threeWay(less, greater bool) int {
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func toInt(v interface{}) (int64, bool) {
+	switch x := v.(type) {
+	case json.Number:
+		i, err := x.Int64()
+		return i, err == nil
+	case int:
+		return int64(x), true
+	case int64:
+		return x, true
+	case int32:
+		return int64(x), true
+	case uint:
+		return int64(x), x <= 1<<63-1
+	case uint64:
+		return int64(x), x <= 1<<63-1
+	case uint32:
+		return int64(x), true
+	default:
+		return 0, false
+	}
+}
+
+func toFloat(v interface{}) float64 {
+	switch x := v.(type) {
+	case json.Number:
+		f, _ := x.Float64()
+		return f
+	case float64:
+		return x
+	case float32:
+		return float64(x)
+	case uint:
+		return float64(x)
+	case uint64:
+		return float64(x)
+	default:
+		i, _ := toInt(v)
+		return float64(i)
+	}
+}
@@ -0,0 +1,404 @@
+/*
+	Network server hosting named stores of JSON documents, and its client
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mngharbi/memstore"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrUnknownStore = errors.New("memstore/server: unknown store")
+	ErrStoreExists  = errors.New("memstore/server: store already exists")
+	ErrUnique       = errors.New("memstore/server: document conflicts with another one on a unique index")
+)
+
+// Maximum size of a request body
+const maxRequestSize = 32 << 20
+
+/*
+	Server hosting named stores, serving the API described in protocol.go
+*/
+type Server struct {
+	// Options of every store created
+	options []memstore.Option
+
+	m      sync.RWMutex
+	stores map[string]*hostedStore
+}
+
+type hostedStore struct {
+	schema *schema
+	ms     *memstore.Memstore
+}
+
+func New(options ...memstore.Option) *Server {
+	return &Server{
+		options: options,
+		stores:  map[string]*hostedStore{},
+	}
+}
+
+func (s *Server) CreateStore(name string, schema Schema) error {
+	compiled, err := compileSchema(schema)
+	if err != nil {
+		return err
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if _, ok := s.stores[name]; ok {
+		return ErrStoreExists
+	}
+	options := append([]memstore.Option{memstore.WithUnique(compiled.unique()...)}, s.options...)
+	s.stores[name] = &hostedStore{
+		schema: compiled,
+		ms:     memstore.New(compiled.names(), options...),
+	}
+	return nil
+}
+
+func (s *Server) DropStore(name string) bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	_, ok := s.stores[name]
+	delete(s.stores, name)
+	return ok
+}
+
+// Schemas of every store, by name
+func (s *Server) Schemas() map[string]Schema {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	res := map[string]Schema{}
+	for name, hs := range s.stores {
+		res[name] = hs.schema.Schema
+	}
+	return res
+}
+
+/*
+	Stats of every store, by name
+
+	Operation and lock metrics need stores to be created with memstore.WithMetrics
+*/
+func (s *Server) Stats() map[string]memstore.Stats {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	res := map[string]memstore.Stats{}
+	for name, hs := range s.stores {
+		res[name] = hs.ms.Stats()
+	}
+	return res
+}
+
+func (s *Server) store(name string) *hostedStore {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	return s.stores[name]
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	if path == "stats" {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("memstore/server: method %v not allowed", r.Method))
+			return
+		}
+		writeJSON(w, s.Stats())
+		return
+	}
+
+	parts := strings.Split(path, "/")
+	if parts[0] != "stores" || len(parts) > 3 {
+		writeError(w, http.StatusNotFound, fmt.Errorf("memstore/server: no such path %q", r.URL.Path))
+		return
+	}
+
+	switch len(parts) {
+	case 1:
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("memstore/server: method %v not allowed", r.Method))
+			return
+		}
+		writeJSON(w, s.Schemas())
+	case 2:
+		s.serveStore(w, r, parts[1])
+	default:
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("memstore/server: method %v not allowed", r.Method))
+			return
+		}
+		s.serveMethod(w, r, parts[1], parts[2])
+	}
+}
+
+func (s *Server) serveStore(w http.ResponseWriter, r *http.Request, name string) {
+	switch r.Method {
+	case http.MethodPut:
+		var schema Schema
+		if err := decode(w, r, &schema); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := s.CreateStore(name, schema); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, schema)
+	case http.MethodGet:
+		hs := s.store(name)
+		if hs == nil {
+			writeError(w, http.StatusNotFound, ErrUnknownStore)
+			return
+		}
+		writeJSON(w, hs.schema.Schema)
+	case http.MethodDelete:
+		if !s.DropStore(name) {
+			writeError(w, http.StatusNotFound, ErrUnknownStore)
+			return
+		}
+		writeJSON(w, struct{}{})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("memstore/server: method %v not allowed", r.Method))
+	}
+}
+
+func (s *Server) serveMethod(w http.ResponseWriter, r *http.Request, name string, method string) {
+	hs := s.store(name)
+	if hs == nil {
+		writeError(w, http.StatusNotFound, ErrUnknownStore)
+		return
+	}
+
+	var req request
+	if err := decode(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	res, err := hs.call(method, req)
+	switch {
+	case err == memstore.ErrConflict:
+		writeError(w, http.StatusConflict, err)
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err)
+	default:
+		writeJSON(w, res)
+	}
+}
+
+// Decode request body, closing the connection once it goes over maxRequestSize
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{Error: err.Error()})
+}
+
+func (hs *hostedStore) call(method string, req request) (res response, err error) {
+	switch method {
+	case methodAdd:
+		err = hs.add(req.Item)
+	case methodGet:
+		res.Item, res.Version, err = hs.get(req.Item, req.Index)
+	case methodDelete:
+		res.Item, err = hs.delete(req.Item, req.Index)
+	case methodRange:
+		res.Items, res.More, err = hs.getRange(req)
+	case methodLen:
+		res.Len = hs.ms.Len()
+	case methodMin, methodMax:
+		res.Item, err = hs.extremum(method, req.Index)
+	case methodUpdate:
+		res.Item, res.Version, err = hs.update(req)
+	default:
+		err = fmt.Errorf("memstore/server: unknown method %q", method)
+	}
+	return res, err
+}
+
+func (hs *hostedStore) index(name string) (Index, error) {
+	for _, index := range hs.schema.Indexes {
+		if index.Name == name {
+			return index, nil
+		}
+	}
+	return Index{}, memstore.ErrUnknownIndex
+}
+
+// Find stored document with the same fields as a document for an index
+func (hs *hostedStore) find(doc Document, index Index) (record, bool) {
+	probe := record{doc: doc, schema: hs.schema}
+
+	if index.Unique {
+		found := hs.ms.Get(probe, index.Name)
+		if found == nil {
+			return record{}, false
+		}
+		return found.(record), true
+	}
+
+	// Probe missing fields of the primary index comes before documents with the same key
+	var res record
+	ok := false
+	hs.ms.GetRangeBounds(memstore.NewRange(memstore.Inclusive(probe), memstore.Unbounded()), index.Name, func(x memstore.Item) bool {
+		res = x.(record)
+		ok = hs.schema.sameKey(index, doc, res.doc)
+		return false
+	})
+	return res, ok
+}
+
+// Add document, replacing the one with the same primary key
+func (hs *hostedStore) add(doc Document) error {
+	if err := hs.schema.validate(doc); err != nil {
+		return err
+	}
+	return uniqueError(hs.ms.Put(record{doc: doc, schema: hs.schema}))
+}
+
+// Name conflicts on unique indexes like the server does
+func uniqueError(err error) error {
+	if err == memstore.ErrDuplicate {
+		return ErrUnique
+	}
+	return err
+}
+
+func (hs *hostedStore) get(doc Document, indexName string) (Document, uint64, error) {
+	index, err := hs.index(indexName)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	found, ok := hs.find(doc, index)
+	if !ok {
+		return nil, 0, nil
+	}
+
+	// Version is kept by the primary index
+	res, version := hs.ms.GetWithVersion(found, hs.schema.primary().Name)
+	if res == nil {
+		return nil, 0, nil
+	}
+	return res.(record).doc, version, nil
+}
+
+func (hs *hostedStore) delete(doc Document, indexName string) (Document, error) {
+	index, err := hs.index(indexName)
+	if err != nil {
+		return nil, err
+	}
+
+	found, ok := hs.find(doc, index)
+	if !ok {
+		return nil, nil
+	}
+	deleted := hs.ms.Delete(found, hs.schema.primary().Name)
+	if deleted == nil {
+		return nil, nil
+	}
+	return deleted.(record).doc, nil
+}
+
+func (hs *hostedStore) getRange(req request) ([]Document, bool, error) {
+	if _, err := hs.index(req.Index); err != nil {
+		return nil, false, err
+	}
+
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	r := memstore.All()
+	switch {
+	case req.After != nil:
+		r.From = memstore.Exclusive(record{doc: req.After, schema: hs.schema})
+	case req.From != nil:
+		r.From = memstore.Inclusive(record{doc: req.From, schema: hs.schema})
+	}
+	if req.To != nil {
+		r.To = memstore.Exclusive(record{doc: req.To, schema: hs.schema})
+	}
+
+	// Look one item further to know if there are more
+	items := []Document{}
+	more := false
+	hs.ms.GetRangeBounds(r, req.Index, func(x memstore.Item) bool {
+		if len(items) == limit {
+			more = true
+			return false
+		}
+		items = append(items, x.(record).doc)
+		return true
+	})
+	return items, more, nil
+}
+
+func (hs *hostedStore) extremum(method string, indexName string) (Document, error) {
+	if _, err := hs.index(indexName); err != nil {
+		return nil, err
+	}
+
+	var found memstore.Item
+	if method == methodMin {
+		found = hs.ms.Min(indexName)
+	} else {
+		found = hs.ms.Max(indexName)
+	}
+	if found == nil {
+		return nil, nil
+	}
+	return found.(record).doc, nil
+}
+
+// Replace document found if still at version, returns updated document and its new version
+func (hs *hostedStore) update(req request) (Document, uint64, error) {
+	index, err := hs.index(req.Index)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := hs.schema.validate(req.Updated); err != nil {
+		return nil, 0, err
+	}
+
+	found, ok := hs.find(req.Item, index)
+	if !ok {
+		return nil, 0, nil
+	}
+
+	version, err := hs.ms.UpdateIfVersion(found, hs.schema.primary().Name, req.Version, record{doc: req.Updated, schema: hs.schema})
+	if err == memstore.ErrNotFound {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, uniqueError(err)
+	}
+	return req.Updated, version, nil
+}
@@ -0,0 +1,305 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/mngharbi/memstore"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func testSchema() Schema {
+	return Schema{Indexes: []Index{
+		{Name: "id", Fields: []string{"id"}},
+		{Name: "email", Fields: []string{"email"}, Unique: true},
+		{Name: "team_age", Fields: []string{"team", "age"}},
+	}}
+}
+
+type user struct {
+	ID    int    `json:"id"`
+	Email string `json:"email"`
+	Team  string `json:"team"`
+	Age   int    `json:"age"`
+}
+
+// Users are items, so that they can be given to clients as they are
+func (u user) Less(index string, than interface{}) bool {
+	return false
+}
+
+func testClient(t *testing.T) (*Client, func()) {
+	srv := httptest.NewServer(New())
+	client := NewClient(srv.URL, "users", srv.Client())
+	if err := client.CreateStore(testSchema()); err != nil {
+		t.Fatalf("Creating store failed, err=%v", err)
+	}
+
+	for _, u := range []user{
+		{1, "a@x", "red", 30},
+		{2, "b@x", "blue", 25},
+		{3, "c@x", "red", 25},
+		{4, "d@x", "red", 40},
+		{5, "e@x", "blue", 30},
+	} {
+		client.Add(u)
+	}
+	if err := client.Err(); err != nil {
+		t.Fatalf("Adding users failed, err=%v", err)
+	}
+
+	return client, srv.Close
+}
+
+func ids(items []memstore.Item) []int {
+	res := []int{}
+	for _, item := range items {
+		id, _ := item.(Document)["id"].(json.Number).Int64()
+		res = append(res, int(id))
+	}
+	return res
+}
+
+func sameInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestClientGet(t *testing.T) {
+	client, done := testClient(t)
+	defer done()
+
+	if client.Len() != 5 {
+		t.Errorf("Length is wrong, len=%v", client.Len())
+	}
+
+	found := client.Get(Document{"email": "c@x"}, "email")
+	if found == nil || found.(Document)["id"] != json.Number("3") {
+		t.Errorf("Getting by unique index failed, found=%v", found)
+	}
+
+	// Non-unique index finds first document with the same fields
+	found = client.Get(Document{"team": "blue", "age": 30}, "team_age")
+	if found == nil || found.(Document)["id"] != json.Number("5") {
+		t.Errorf("Getting by non-unique index failed, found=%v", found)
+	}
+
+	if client.Get(Document{"id": 10}, "id") != nil || client.Err() != nil {
+		t.Error("Getting missing document should return nil without error")
+	}
+
+	if client.Get(Document{"id": 1}, "notID") != nil || client.Err() == nil {
+		t.Error("Getting by unknown index should fail")
+	}
+
+	if min, max := client.Min("team_age"), client.Max("email"); ids([]memstore.Item{min, max})[0] != 2 || ids([]memstore.Item{min, max})[1] != 5 {
+		t.Errorf("Min or max is wrong, min=%v max=%v", min, max)
+	}
+}
+
+func TestClientGetRange(t *testing.T) {
+	client, done := testClient(t)
+	defer done()
+
+	collect := func(from, to memstore.Item, index string, limit int) []int {
+		res := []memstore.Item{}
+		client.GetRange(from, to, index, func(x memstore.Item) bool {
+			res = append(res, x)
+			return len(res) < limit
+		})
+		return ids(res)
+	}
+
+	if res := collect(Document{"team": "red"}, Document{"team": "red", "age": 40}, "team_age", 10); !sameInts(res, []int{3, 1}) {
+		t.Errorf("Range on compound index is wrong, res=%v", res)
+	}
+	if res := collect(nil, nil, "id", 10); !sameInts(res, []int{1, 2, 3, 4, 5}) {
+		t.Errorf("Unbounded range is wrong, res=%v", res)
+	}
+	if res := collect(Document{"id": 2}, nil, "id", 2); !sameInts(res, []int{2, 3}) {
+		t.Errorf("Range should stop once test fails, res=%v", res)
+	}
+
+	// Ranges larger than a page are fetched in several calls
+	for id := 6; id < 2*defaultPageSize+10; id++ {
+		client.Add(Document{"id": id, "email": strings.Repeat("z", id)})
+	}
+	if res := collect(nil, nil, "id", 1000); len(res) != 2*defaultPageSize+9 || res[len(res)-1] != 2*defaultPageSize+9 {
+		t.Errorf("Range over several pages is wrong, len=%v", len(res))
+	}
+}
+
+func TestClientWrites(t *testing.T) {
+	client, done := testClient(t)
+	defer done()
+
+	// Adding with the same primary key replaces, moving document within indexes
+	client.Add(user{1, "a@y", "green", 31})
+	if client.Len() != 5 || client.Get(Document{"email": "a@x"}, "email") != nil || client.Get(Document{"email": "a@y"}, "email") == nil {
+		t.Error("Adding with existing primary key should replace")
+	}
+
+	// Unique index rejects duplicates
+	client.Add(user{6, "b@x", "blue", 20})
+	if err := client.Err(); err == nil || !strings.Contains(err.Error(), "unique") || client.Len() != 5 {
+		t.Errorf("Adding duplicate on unique index should fail, err=%v", err)
+	}
+
+	deleted := client.Delete(Document{"team": "red", "age": 25}, "team_age")
+	if ids([]memstore.Item{deleted})[0] != 3 || client.Len() != 4 || client.Get(Document{"id": 3}, "id") != nil {
+		t.Errorf("Deleting by non-unique index failed, deleted=%v", deleted)
+	}
+
+	updated := client.UpdateData(Document{"id": 4}, "id", func(x memstore.Item) (memstore.Item, bool) {
+		doc := Document{}
+		for field, value := range x.(Document) {
+			doc[field] = value
+		}
+		doc["age"] = 18
+		return doc, true
+	})
+	if updated == nil || client.Min("team_age").(Document)["id"] != json.Number("2") {
+		t.Errorf("Updating failed, updated=%v err=%v", updated, client.Err())
+	}
+	if found := client.Get(Document{"team": "red", "age": 18}, "team_age"); found == nil || found.(Document)["id"] != json.Number("4") {
+		t.Errorf("Updated document should be moved within indexes, found=%v", found)
+	}
+
+	rejected := client.UpdateData(Document{"id": 4}, "id", func(x memstore.Item) (memstore.Item, bool) {
+		return x, false
+	})
+	if rejected != nil || client.Err() != nil {
+		t.Error("Update rejected by modify should return nil")
+	}
+}
+
+func TestClientConcurrentUpdates(t *testing.T) {
+	client, done := testClient(t)
+	defer done()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				client.UpdateData(Document{"id": 1}, "id", func(x memstore.Item) (memstore.Item, bool) {
+					doc := Document{}
+					for field, value := range x.(Document) {
+						doc[field] = value
+					}
+					age, _ := doc["age"].(json.Number).Int64()
+					doc["age"] = age + 1
+					return doc, true
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := client.Err(); err != nil {
+		t.Fatalf("Concurrent updates failed, err=%v", err)
+	}
+	if age := client.Get(Document{"id": 1}, "id").(Document)["age"]; age != json.Number("80") {
+		t.Errorf("No concurrent update should be lost, age=%v", age)
+	}
+}
+
+func TestServerStores(t *testing.T) {
+	srv := httptest.NewServer(New())
+	defer srv.Close()
+
+	client := NewClient(srv.URL, "users", nil)
+	if client.Len() != 0 || client.Err() != ErrUnknownStore {
+		t.Error("Unknown store should be reported")
+	}
+
+	if err := client.CreateStore(Schema{}); err == nil {
+		t.Error("Creating store without index should fail")
+	}
+	if err := client.CreateStore(testSchema()); err != nil {
+		t.Errorf("Creating store failed, err=%v", err)
+	}
+	if err := client.CreateStore(testSchema()); err == nil {
+		t.Error("Creating existing store should fail")
+	}
+
+	resp, err := http.Get(srv.URL + "/stores")
+	if err != nil {
+		t.Fatalf("Listing stores failed, err=%v", err)
+	}
+	defer resp.Body.Close()
+	schemas := map[string]Schema{}
+	if err := json.NewDecoder(resp.Body).Decode(&schemas); err != nil || len(schemas["users"].Indexes) != 3 {
+		t.Errorf("Listing stores is wrong, schemas=%v err=%v", schemas, err)
+	}
+
+	if err := client.DropStore(); err != nil || client.DropStore() != ErrUnknownStore {
+		t.Errorf("Dropping store failed, err=%v", err)
+	}
+}
+
+func TestCompareValues(t *testing.T) {
+	ordered := []interface{}{nil, false, true, json.Number("-3"), 2, json.Number("2.5"), 3.0, json.Number("9007199254740993"), "", "a", "b"}
+	for i, a := range ordered {
+		for j, b := range ordered {
+			expected := threeWay(i < j, i > j)
+			if comparison := compareValues(a, b); comparison != expected {
+				t.Errorf("Comparing %#v and %#v gave %v, expected %v", a, b, comparison, expected)
+			}
+		}
+	}
+
+	// Integers beyond float precision are compared exactly
+	if compareValues(json.Number("9007199254740993"), json.Number("9007199254740992")) != 1 {
+		t.Error("Large integers should be compared exactly")
+	}
+}
+
+func TestServerStats(t *testing.T) {
+	srv := httptest.NewServer(New(memstore.WithMetrics()))
+	defer srv.Close()
+
+	client := NewClient(srv.URL, "users", nil)
+	client.CreateStore(testSchema())
+	client.Add(user{1, "a@x", "red", 30})
+
+	resp, err := http.Get(srv.URL + "/stats")
+	if err != nil {
+		t.Fatalf("Getting stats failed, err=%v", err)
+	}
+	defer resp.Body.Close()
+	stats := map[string]memstore.Stats{}
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		t.Fatalf("Decoding stats failed, err=%v", err)
+	}
+	if users := stats["users"]; users.Items != 1 || len(users.Indexes) != 3 || users.Operations["Put"].Count != 1 {
+		t.Errorf("Stats of store are wrong, stats=%+v", users)
+	}
+}
+
+func TestServerRequestTooLarge(t *testing.T) {
+	srv := httptest.NewServer(New())
+	defer srv.Close()
+	NewClient(srv.URL, "users", nil).CreateStore(testSchema())
+
+	// Connection isn't kept for the rest of a body too large to read
+	body := `{"item": {"id": 1, "email": "` + strings.Repeat("a", maxRequestSize) + `"}}`
+	resp, err := http.Post(srv.URL+"/stores/users/add", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Posting request failed, err=%v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || !resp.Close {
+		t.Errorf("Request too large should be rejected and close the connection, status=%v close=%v", resp.StatusCode, resp.Close)
+	}
+}
@@ -0,0 +1,267 @@
+package memstore
+
+import (
+	"fmt"
+	"github.com/mngharbi/GoLLRB/llrb"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Maximum number of levels of a skip list
+const skipListMaxLevel = 32
+
+/*
+	Skip list backend
+
+	Like other backends, it's only read and written under the store lock.
+	Nodes link back to the previous one on the bottom level, so descending is as cheap as ascending.
+*/
+type skipListIndex struct {
+	index string
+	head  *skipNode
+
+	// Number of levels in use, and of items
+	level  int32
+	length int64
+
+	// State of random level generator (xorshift)
+	seed uint64
+}
+
+type skipNode struct {
+	item llrb.Item
+
+	// Next node (*skipNode) on every level of the node
+	next []unsafe.Pointer
+
+	// Previous node on the bottom level (head if none)
+	prev *skipNode
+}
+
+func newSkipListIndex(index string) *skipListIndex {
+	return &skipListIndex{
+		index: index,
+		head:  &skipNode{next: make([]unsafe.Pointer, skipListMaxLevel)},
+		level: 1,
+		seed:  0x9e3779b97f4a7c15,
+	}
+}
+
+func (n *skipNode) nextAt(level int) *skipNode {
+	return (*skipNode)(atomic.LoadPointer(&n.next[level]))
+}
+
+func (n *skipNode) setNext(level int, next *skipNode) {
+	atomic.StorePointer(&n.next[level], unsafe.Pointer(next))
+}
+
+func (t *skipListIndex) less(a, b llrb.Item) bool {
+	return a.Less(t.index, b)
+}
+
+// Random level, each level being a quarter as likely as the previous one
+func (t *skipListIndex) randomLevel() int {
+	t.seed ^= t.seed << 13
+	t.seed ^= t.seed >> 7
+	t.seed ^= t.seed << 17
+
+	level := 1
+	for bits := t.seed; level < skipListMaxLevel && bits&3 == 0; bits >>= 2 {
+		level++
+	}
+	return level
+}
+
+// Last node before key on every level (head if none)
+func (t *skipListIndex) predecessors(key llrb.Item) (preds [skipListMaxLevel]*skipNode) {
+	x := t.head
+	for level := int(atomic.LoadInt32(&t.level)) - 1; level >= 0; level-- {
+		for next := x.nextAt(level); next != nil && t.less(next.item, key); next = x.nextAt(level) {
+			x = next
+		}
+		preds[level] = x
+	}
+	return preds
+}
+
+// Last node not after key (head if none)
+func (t *skipListIndex) lastNotAfter(key llrb.Item) *skipNode {
+	x := t.head
+	for level := int(atomic.LoadInt32(&t.level)) - 1; level >= 0; level-- {
+		for next := x.nextAt(level); next != nil && !t.less(key, next.item); next = x.nextAt(level) {
+			x = next
+		}
+	}
+	return x
+}
+
+// Last node before key (head if none)
+func (t *skipListIndex) lastBefore(key llrb.Item) *skipNode {
+	x := t.head
+	for level := int(atomic.LoadInt32(&t.level)) - 1; level >= 0; level-- {
+		for next := x.nextAt(level); next != nil && t.less(next.item, key); next = x.nextAt(level) {
+			x = next
+		}
+	}
+	return x
+}
+
+func (t *skipListIndex) Len() int {
+	return int(atomic.LoadInt64(&t.length))
+}
+
+func (t *skipListIndex) Get(key llrb.Item) llrb.Item {
+	candidate := t.lastBefore(key).nextAt(0)
+	if candidate == nil || t.less(key, candidate.item) {
+		return nil
+	}
+	return candidate.item
+}
+
+func (t *skipListIndex) Min() llrb.Item {
+	first := t.head.nextAt(0)
+	if first == nil {
+		return nil
+	}
+	return first.item
+}
+
+func (t *skipListIndex) Max() llrb.Item {
+	x := t.head
+	for level := int(atomic.LoadInt32(&t.level)) - 1; level >= 0; level-- {
+		for next := x.nextAt(level); next != nil; next = x.nextAt(level) {
+			x = next
+		}
+	}
+	if x == t.head {
+		return nil
+	}
+	return x.item
+}
+
+func (t *skipListIndex) ReplaceOrInsert(item llrb.Item) llrb.Item {
+	preds := t.predecessors(item)
+
+	// Replace equivalent node by a new one, readers on the old one keep going through its links
+	if candidate := preds[0].nextAt(0); candidate != nil && !t.less(item, candidate.item) {
+		replacement := &skipNode{item: item, next: make([]unsafe.Pointer, len(candidate.next)), prev: candidate.prev}
+		for level := range candidate.next {
+			replacement.next[level] = unsafe.Pointer(candidate.nextAt(level))
+		}
+		for level := range candidate.next {
+			preds[level].setNext(level, replacement)
+		}
+		if next := replacement.nextAt(0); next != nil {
+			next.prev = replacement
+		}
+		return candidate.item
+	}
+
+	height := t.randomLevel()
+	current := int(atomic.LoadInt32(&t.level))
+	for level := current; level < height; level++ {
+		preds[level] = t.head
+	}
+
+	node := &skipNode{item: item, next: make([]unsafe.Pointer, height), prev: preds[0]}
+	for level := 0; level < height; level++ {
+		node.next[level] = unsafe.Pointer(preds[level].nextAt(level))
+	}
+	for level := 0; level < height; level++ {
+		preds[level].setNext(level, node)
+	}
+	if next := node.nextAt(0); next != nil {
+		next.prev = node
+	}
+
+	if height > current {
+		atomic.StoreInt32(&t.level, int32(height))
+	}
+	atomic.AddInt64(&t.length, 1)
+	return nil
+}
+
+func (t *skipListIndex) Delete(key llrb.Item) llrb.Item {
+	preds := t.predecessors(key)
+
+	candidate := preds[0].nextAt(0)
+	if candidate == nil || t.less(key, candidate.item) {
+		return nil
+	}
+
+	for level := len(candidate.next) - 1; level >= 0; level-- {
+		preds[level].setNext(level, candidate.nextAt(level))
+	}
+	if next := candidate.nextAt(0); next != nil {
+		next.prev = candidate.prev
+	}
+
+	// Drop empty levels
+	level := atomic.LoadInt32(&t.level)
+	for level > 1 && t.head.nextAt(int(level)-1) == nil {
+		level--
+	}
+	atomic.StoreInt32(&t.level, level)
+
+	atomic.AddInt64(&t.length, -1)
+	return candidate.item
+}
+
+func (t *skipListIndex) AscendGreaterOrEqual(pivot llrb.Item, iterator llrb.ItemIterator) {
+	for x := t.lastBefore(pivot).nextAt(0); x != nil; x = x.nextAt(0) {
+		if !iterator(x.item) {
+			return
+		}
+	}
+}
+
+func (t *skipListIndex) DescendLessOrEqual(pivot llrb.Item, iterator llrb.ItemIterator) {
+	for x := t.lastNotAfter(pivot); x != t.head; x = x.prev {
+		if !iterator(x.item) {
+			return
+		}
+	}
+}
+
+func (t *skipListIndex) clear() {
+	for level := range t.head.next {
+		t.head.setNext(level, nil)
+	}
+	atomic.StoreInt32(&t.level, 1)
+	atomic.StoreInt64(&t.length, 0)
+}
+
+func (t *skipListIndex) height() int {
+	return int(atomic.LoadInt32(&t.level))
+}
+
+// Check that every level is ordered, only links nodes that tall, and that the bottom one holds every item linked back
+func (t *skipListIndex) check(ir *IndexReport) {
+	level := int(atomic.LoadInt32(&t.level))
+	for l := level; l < skipListMaxLevel; l++ {
+		if t.head.nextAt(l) != nil {
+			ir.addStructureViolation(fmt.Sprintf("level %v is used but list has %v levels", l, level))
+		}
+	}
+
+	for l := 0; l < level; l++ {
+		count := 0
+		prev := t.head
+		for x := t.head.nextAt(l); x != nil; prev, x = x, x.nextAt(l) {
+			count++
+			if l == 0 && x.prev != prev {
+				ir.addStructureViolation(fmt.Sprintf("%+v doesn't link back to the node before it", x.item.(*internalItem).value))
+			}
+			if len(x.next) <= l {
+				ir.addStructureViolation(fmt.Sprintf("%+v is linked on level %v above its height", x.item.(*internalItem).value, l))
+				break
+			}
+			if next := x.nextAt(l); next != nil && !t.less(x.item, next.item) {
+				ir.addStructureViolation(fmt.Sprintf("%+v is not before %+v on level %v", x.item.(*internalItem).value, next.item.(*internalItem).value, l))
+			}
+		}
+		if l == 0 && count != t.Len() {
+			ir.addStructureViolation(fmt.Sprintf("list reports %v items but has %v on bottom level", t.Len(), count))
+		}
+	}
+}
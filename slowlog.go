@@ -0,0 +1,244 @@
+package memstore
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	Operation that held the store lock for at least the slow log threshold
+
+	Stack starts at the public method, Caller is the first frame outside of the package
+*/
+type SlowOperation struct {
+	Operation
+	Caller runtime.Frame
+	Stack  []runtime.Frame
+}
+
+func (so SlowOperation) String() string {
+	return fmt.Sprintf("memstore: slow %v on %q held lock for %v (waited %v, %v items) from %v (%v:%v)",
+		so.Name, so.Index, so.LockHold, so.LockWait, so.Items, so.Caller.Function, so.Caller.File, so.Caller.Line)
+}
+
+/*
+	Slow operations from the same call site, on the same operation and index
+*/
+type Contention struct {
+	Caller runtime.Frame
+	Name   string
+	Index  string
+
+	Count     int
+	TotalHold time.Duration
+	MaxHold   time.Duration
+	TotalWait time.Duration
+}
+
+/*
+	Configuration of the slow operation log
+*/
+type SlowLogOptions struct {
+	// Operations holding the lock at least this long are logged
+	Threshold time.Duration
+
+	// Number of recent slow operations kept (100 if zero)
+	Capacity int
+
+	// Maximum number of frames captured per operation (32 if zero)
+	StackDepth int
+
+	// Called with every slow operation, once the lock is released
+	OnSlow func(SlowOperation)
+}
+
+// Handler logging every slow operation
+func LogSlowOperations(logger *log.Logger) func(SlowOperation) {
+	return func(so SlowOperation) {
+		logger.Println(so.String())
+	}
+}
+
+/*
+	Log operations holding the store lock for too long, with the call site responsible
+
+	Call sites are summarized in ContentionReport, recent operations are kept for SlowOperations
+*/
+func WithSlowLog(options SlowLogOptions) Option {
+	if options.Capacity <= 0 {
+		options.Capacity = 100
+	}
+	if options.StackDepth <= 0 {
+		options.StackDepth = 32
+	}
+	return func(ms *Memstore) {
+		ms.slowLog = &slowLog{
+			options: options,
+			sites:   map[contentionKey]*Contention{},
+		}
+	}
+}
+
+// Prefix of functions of this package, e.g. "github.com/mngharbi/memstore."
+var packagePrefix = strings.TrimSuffix(runtime.FuncForPC(reflect.ValueOf(New).Pointer()).Name(), "New")
+
+type contentionKey struct {
+	function string
+	file     string
+	line     int
+	name     string
+	index    string
+}
+
+type slowLog struct {
+	options SlowLogOptions
+
+	// Ring of recent slow operations, and summary per call site
+	m      sync.Mutex
+	recent []SlowOperation
+	next   int
+	sites  map[contentionKey]*Contention
+}
+
+// Record operation if it is slow (called from end, after the lock was released)
+func (sl *slowLog) observe(op *operation) {
+	if !op.locked || op.LockHold < sl.options.Threshold {
+		return
+	}
+
+	so := SlowOperation{Operation: op.Operation}
+	so.Stack = stack(3, sl.options.StackDepth)
+	so.Caller = caller(so.Stack)
+
+	sl.m.Lock()
+
+	if len(sl.recent) < sl.options.Capacity {
+		sl.recent = append(sl.recent, so)
+	} else {
+		sl.recent[sl.next] = so
+		sl.next = (sl.next + 1) % len(sl.recent)
+	}
+
+	key := contentionKey{
+		function: so.Caller.Function,
+		file:     so.Caller.File,
+		line:     so.Caller.Line,
+		name:     so.Name,
+		index:    so.Index,
+	}
+	site := sl.sites[key]
+	if site == nil {
+		site = &Contention{Caller: so.Caller, Name: so.Name, Index: so.Index}
+		sl.sites[key] = site
+	}
+	site.Count++
+	site.TotalHold += so.LockHold
+	site.TotalWait += so.LockWait
+	if so.LockHold > site.MaxHold {
+		site.MaxHold = so.LockHold
+	}
+
+	sl.m.Unlock()
+
+	if sl.options.OnSlow != nil {
+		sl.options.OnSlow(so)
+	}
+}
+
+// Frames of the current goroutine, skipping the innermost ones
+func stack(skip int, depth int) []runtime.Frame {
+	pcs := make([]uintptr, depth)
+	n := runtime.Callers(skip+1, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	res := []runtime.Frame{}
+	for {
+		frame, more := frames.Next()
+		res = append(res, frame)
+		if !more {
+			break
+		}
+	}
+	return res
+}
+
+// First frame outside of the package and the runtime, tests of the package count as callers
+func caller(frames []runtime.Frame) runtime.Frame {
+	for _, frame := range frames {
+		internal := strings.HasPrefix(frame.Function, packagePrefix) && !strings.HasSuffix(frame.File, "_test.go")
+		if !internal && !strings.HasPrefix(frame.Function, "runtime.") {
+			return frame
+		}
+	}
+	if len(frames) == 0 {
+		return runtime.Frame{}
+	}
+	return frames[len(frames)-1]
+}
+
+// Get recent slow operations, oldest first (nil without WithSlowLog)
+func (ms *Memstore) SlowOperations() []SlowOperation {
+	if ms.slowLog == nil {
+		return nil
+	}
+	sl := ms.slowLog
+
+	sl.m.Lock()
+	defer sl.m.Unlock()
+
+	res := make([]SlowOperation, 0, len(sl.recent))
+	res = append(res, sl.recent[sl.next:]...)
+	res = append(res, sl.recent[:sl.next]...)
+	return res
+}
+
+/*
+	Get call sites of slow operations, worst offenders (most time holding the lock) first
+
+	Only the first limit call sites are returned, unless limit is zero or less
+*/
+func (ms *Memstore) ContentionReport(limit int) []Contention {
+	if ms.slowLog == nil {
+		return nil
+	}
+	sl := ms.slowLog
+
+	sl.m.Lock()
+	res := make([]Contention, 0, len(sl.sites))
+	for _, site := range sl.sites {
+		res = append(res, *site)
+	}
+	sl.m.Unlock()
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].TotalHold != res[j].TotalHold {
+			return res[i].TotalHold > res[j].TotalHold
+		}
+		return res[i].Count > res[j].Count
+	})
+
+	if limit > 0 && len(res) > limit {
+		res = res[:limit]
+	}
+	return res
+}
+
+// Forget slow operations recorded so far
+func (ms *Memstore) ResetSlowLog() {
+	if ms.slowLog == nil {
+		return
+	}
+	sl := ms.slowLog
+
+	sl.m.Lock()
+	sl.recent = nil
+	sl.next = 0
+	sl.sites = map[contentionKey]*Contention{}
+	sl.m.Unlock()
+}
@@ -0,0 +1,117 @@
+package memstore
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func slowRange(ms *Memstore, pause time.Duration) {
+	ms.GetRangeBounds(All(), "id", func(item Item) bool {
+		time.Sleep(pause)
+		return false
+	})
+}
+
+func TestSlowLog(t *testing.T) {
+	var buffer bytes.Buffer
+	ms := New([]string{"id", "importance"}, WithSlowLog(SlowLogOptions{
+		Threshold: 5 * time.Millisecond,
+		OnSlow:    LogSlowOperations(log.New(&buffer, "", 0)),
+	}))
+	for _, v := range shuffeledTestData() {
+		var vItem Item = v
+		ms.Add(vItem)
+	}
+
+	// Fast operations aren't logged
+	ms.Get(TestStruct{id: 1}, "id")
+	if len(ms.SlowOperations()) != 0 {
+		t.Errorf("Fast operations shouldn't be logged, slow=%+v", ms.SlowOperations())
+	}
+
+	slowRange(ms, 10*time.Millisecond)
+
+	slow := ms.SlowOperations()
+	if len(slow) != 1 {
+		t.Fatalf("Slow operation should be logged, slow=%+v", slow)
+	}
+	so := slow[0]
+	if so.Name != opGetRange || so.Index != "id" || so.Items != 1 || so.LockHold < 10*time.Millisecond {
+		t.Errorf("Slow operation logged is wrong, slow=%+v", so.Operation)
+	}
+	if !strings.HasSuffix(so.Caller.Function, ".slowRange") || !strings.HasSuffix(so.Caller.File, "slowlog_test.go") {
+		t.Errorf("Caller of slow operation is wrong, caller=%+v", so.Caller)
+	}
+	if len(so.Stack) == 0 || !strings.HasSuffix(so.Stack[0].Function, ".GetRangeBounds") {
+		t.Errorf("Stack of slow operation should start at public method, stack=%+v", so.Stack)
+	}
+	if !strings.Contains(buffer.String(), "slow GetRange") || !strings.Contains(buffer.String(), "slowRange") {
+		t.Errorf("Slow operation should be passed to handler, log=%v", buffer.String())
+	}
+
+	ms.ResetSlowLog()
+	if len(ms.SlowOperations()) != 0 || len(ms.ContentionReport(0)) != 0 {
+		t.Errorf("Slow log should be empty after reset")
+	}
+}
+
+func TestSlowLogCapacity(t *testing.T) {
+	ms := New([]string{"id"}, WithSlowLog(SlowLogOptions{Capacity: 2}))
+	for i := 0; i < 5; i++ {
+		var vItem Item = TestStruct{id: i}
+		ms.Add(vItem)
+	}
+
+	slow := ms.SlowOperations()
+	if len(slow) != 2 {
+		t.Fatalf("Slow log should be bounded, slow=%+v", slow)
+	}
+	if slow[1].Start.Before(slow[0].Start) {
+		t.Errorf("Slow operations should be oldest first, slow=%+v", slow)
+	}
+
+	report := ms.ContentionReport(0)
+	if len(report) != 1 || report[0].Count != 5 || report[0].Name != opAdd {
+		t.Errorf("Contention report should count every slow operation, report=%+v", report)
+	}
+}
+
+func TestContentionReport(t *testing.T) {
+	ms := New([]string{"id"}, WithSlowLog(SlowLogOptions{Threshold: time.Millisecond}))
+	var vItem Item = TestStruct{id: 1}
+	ms.Add(vItem)
+
+	// Worst offender holds the lock the longest overall, not the most often
+	for i := 0; i < 3; i++ {
+		slowRange(ms, 2*time.Millisecond)
+	}
+	ms.ApplyDataSubset([]Item{vItem}, "id", func(item Item) bool {
+		time.Sleep(20 * time.Millisecond)
+		return true
+	})
+
+	report := ms.ContentionReport(0)
+	if len(report) != 2 {
+		t.Fatalf("Contention report should have a call site per operation, report=%+v", report)
+	}
+	if report[0].Name != opApplyDataSubset || report[0].Count != 1 || report[0].MaxHold < 20*time.Millisecond {
+		t.Errorf("Worst offender should come first, report=%+v", report)
+	}
+	if report[1].Name != opGetRange || report[1].Count != 3 || report[1].TotalHold < 6*time.Millisecond {
+		t.Errorf("Call sites should be summarized, report=%+v", report)
+	}
+
+	if limited := ms.ContentionReport(1); len(limited) != 1 || limited[0] != report[0] {
+		t.Errorf("Contention report should be limited, report=%+v", limited)
+	}
+}
+
+func TestSlowLogDisabled(t *testing.T) {
+	ms := New([]string{"id"})
+	if ms.SlowOperations() != nil || ms.ContentionReport(0) != nil {
+		t.Errorf("Slow log should be disabled by default")
+	}
+}
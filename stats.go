@@ -0,0 +1,105 @@
+package memstore
+
+import (
+	"github.com/mngharbi/GoLLRB/llrb"
+	"sync/atomic"
+)
+
+// Number of items sampled per index to estimate selectivity
+const statsSamples = 64
+
+/*
+	Statistics about an index, used to plan queries
+
+	Samples are items taken at regular intervals in index order
+*/
+type indexStats struct {
+	// Sequence number when statistics were gathered
+	sequence uint64
+
+	size    int
+	samples []*internalItem
+
+	// Whether all sampled items expose raw keys
+	keyed bool
+}
+
+// Get statistics of an index, gathering them again if stale (expects at least a read lock)
+func (ms *Memstore) indexStatistics(index string) *indexStats {
+	tree := ms.indexTree[index]
+	if tree == nil {
+		return nil
+	}
+
+	ms.statsMutex.Lock()
+	defer ms.statsMutex.Unlock()
+
+	if ms.stats == nil {
+		ms.stats = map[string]*indexStats{}
+	}
+
+	sequence := atomic.LoadUint64(&ms.sequence)
+	st := ms.stats[index]
+	if st == nil || st.stale(sequence) {
+		st = gatherStats(tree, sequence)
+		ms.stats[index] = st
+	}
+
+	return st
+}
+
+// Statistics are stale once about a tenth of the index has changed
+func (st *indexStats) stale(sequence uint64) bool {
+	return sequence-st.sequence > uint64(st.size/10)
+}
+
+// Sample a tree in order
+func gatherStats(tree OrderedIndex, sequence uint64) *indexStats {
+	st := &indexStats{
+		sequence: sequence,
+		size:     tree.Len(),
+		keyed:    true,
+	}
+
+	step := st.size / statsSamples
+	if step == 0 {
+		step = 1
+	}
+
+	position := 0
+	tree.AscendGreaterOrEqual(makeSentinelItem(-1), func(it llrb.Item) bool {
+		if position%step == 0 {
+			ii := it.(*internalItem)
+			if _, ok := ii.value.(KeyedItem); !ok {
+				st.keyed = false
+			}
+			st.samples = append(st.samples, ii)
+		}
+		position++
+		return true
+	})
+
+	return st
+}
+
+// Estimate number of items of the index within a range
+func (st *indexStats) estimate(index string, r Range) int {
+	if len(st.samples) == 0 {
+		return 0
+	}
+
+	matching := 0
+	for _, sample := range st.samples {
+		if r.contains(index, sample.value) {
+			matching++
+		}
+	}
+
+	// Every sample stands for the items up to the next one
+	estimate := (matching + 1) * st.size / len(st.samples)
+	if estimate > st.size {
+		estimate = st.size
+	}
+
+	return estimate
+}
@@ -0,0 +1,17 @@
+package memstore
+
+/*
+	Operations shared by in-process stores and clients of remote ones (see package server)
+*/
+type Store interface {
+	Add(x Item)
+	Get(x Item, index string) Item
+	Delete(x Item, index string) Item
+	GetRange(from, to Item, index string, test func(Item) bool)
+	Len() int
+	Min(index string) Item
+	Max(index string) Item
+	UpdateData(x Item, index string, modify func(Item) (Item, bool)) Item
+}
+
+var _ Store = (*Memstore)(nil)
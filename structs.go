@@ -0,0 +1,334 @@
+package memstore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidTag = errors.New("memstore: invalid struct tag")
+
+/*
+	Index a struct field belongs to, as given by its tag
+
+	Tags list indexes separated by semicolons, each with comma-separated options:
+
+		ID     int       `memstore:"index=id,primary"`
+		Email  string    `memstore:"index=email,unique"`
+		Tenant string    `memstore:"index=tenant_ts,part=0"`
+		Time   time.Time `memstore:"index=tenant_ts,part=1;index=time"`
+
+	Fields of an index are compared in order of their part. The primary index is the one marked primary,
+	or the first one found. Items equal on the fields of a non-unique index are ordered by the primary index.
+*/
+type FieldIndex struct {
+	Index   string
+	Part    int
+	Unique  bool
+	Primary bool
+}
+
+// Parse the value of a memstore struct tag
+func ParseStructTag(tag string) ([]FieldIndex, error) {
+	var res []FieldIndex
+	for _, spec := range strings.Split(tag, ";") {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+
+		var fi FieldIndex
+		for _, option := range strings.Split(spec, ",") {
+			option = strings.TrimSpace(option)
+			name, value, hasValue := strings.Cut(option, "=")
+			switch {
+			case name == "index" && hasValue && value != "":
+				fi.Index = value
+			case name == "part" && hasValue:
+				part, err := strconv.Atoi(value)
+				if err != nil || part < 0 {
+					return nil, fmt.Errorf("%w: bad part %q", ErrInvalidTag, value)
+				}
+				fi.Part = part
+			case name == "unique" && !hasValue:
+				fi.Unique = true
+			case name == "primary" && !hasValue:
+				fi.Primary = true
+			default:
+				return nil, fmt.Errorf("%w: unknown option %q", ErrInvalidTag, option)
+			}
+		}
+		if fi.Index == "" {
+			return nil, fmt.Errorf("%w: %q names no index", ErrInvalidTag, spec)
+		}
+		res = append(res, fi)
+	}
+	return res, nil
+}
+
+/*
+	Index built from struct tags, with its fields in order
+*/
+type StructIndex struct {
+	Name   string
+	Fields []string
+	Unique bool
+}
+
+// Field compared by an index
+type structField struct {
+	name    string
+	index   int
+	compare func(index string, a, b reflect.Value) int
+}
+
+// Indexes of a struct type, the first one being the primary index
+type structSchema struct {
+	typ     reflect.Type
+	indexes []StructIndex
+
+	// Fields of every index, and those of the primary index ordering items equal on non-unique ones
+	fields   map[string][]structField
+	tieBreak map[string][]structField
+}
+
+/*
+	Field of a struct with its memstore tag
+*/
+type TaggedField struct {
+	Name string
+	Tag  string
+}
+
+/*
+	Indexes declared by the tags of struct fields, the first one being the primary index
+
+	Non-unique indexes don't list the fields of the primary index ordering their equal items.
+*/
+func IndexesFromTags(fields []TaggedField) ([]StructIndex, error) {
+	type part struct {
+		FieldIndex
+		field string
+	}
+	parts := map[string][]part{}
+	var names []string
+	primary := ""
+
+	for _, field := range fields {
+		fieldIndexes, err := ParseStructTag(field.Tag)
+		if err != nil {
+			return nil, fmt.Errorf("field %v: %w", field.Name, err)
+		}
+		for _, fi := range fieldIndexes {
+			if _, ok := parts[fi.Index]; !ok {
+				names = append(names, fi.Index)
+			}
+			if fi.Primary {
+				if primary != "" && primary != fi.Index {
+					return nil, fmt.Errorf("%w: several primary indexes", ErrInvalidTag)
+				}
+				primary = fi.Index
+			}
+			parts[fi.Index] = append(parts[fi.Index], part{fi, field.Name})
+		}
+	}
+
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%w: no indexed field", ErrInvalidTag)
+	}
+	if primary == "" {
+		primary = names[0]
+	}
+
+	// Primary index first, others in order of appearance
+	ordered := []string{primary}
+	for _, name := range names {
+		if name != primary {
+			ordered = append(ordered, name)
+		}
+	}
+
+	var res []StructIndex
+	for _, name := range ordered {
+		indexParts := parts[name]
+		sort.SliceStable(indexParts, func(i, j int) bool {
+			return indexParts[i].Part < indexParts[j].Part
+		})
+
+		index := StructIndex{Name: name, Unique: name == primary}
+		for i, p := range indexParts {
+			if i > 0 && p.Part == indexParts[i-1].Part {
+				return nil, fmt.Errorf("%w: index %q has two fields at part %v", ErrInvalidTag, name, p.Part)
+			}
+			index.Unique = index.Unique || p.Unique
+			index.Fields = append(index.Fields, p.field)
+		}
+		res = append(res, index)
+	}
+	return res, nil
+}
+
+/*
+	Read indexes from the tags of a struct type
+
+	Tagged fields must be exported, and be ints, floats, strings, time.Time, []byte or implement Item.
+*/
+func compileStructSchema(typ reflect.Type) (*structSchema, error) {
+	if typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%w: %v isn't a struct", ErrInvalidTag, typ)
+	}
+
+	var tagged []TaggedField
+	byName := map[string]structField{}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag, ok := field.Tag.Lookup("memstore")
+		if !ok {
+			continue
+		}
+		if field.PkgPath != "" {
+			return nil, fmt.Errorf("%w: field %v isn't exported", ErrInvalidTag, field.Name)
+		}
+		compare := fieldComparator(field.Type)
+		if compare == nil {
+			return nil, fmt.Errorf("%w: field %v has unsupported type %v", ErrInvalidTag, field.Name, field.Type)
+		}
+		tagged = append(tagged, TaggedField{Name: field.Name, Tag: tag})
+		byName[field.Name] = structField{name: field.Name, index: i, compare: compare}
+	}
+
+	indexes, err := IndexesFromTags(tagged)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", typ, err)
+	}
+
+	schema := &structSchema{
+		typ:      typ,
+		indexes:  indexes,
+		fields:   map[string][]structField{},
+		tieBreak: map[string][]structField{},
+	}
+	for _, index := range indexes {
+		for _, name := range index.Fields {
+			schema.fields[index.Name] = append(schema.fields[index.Name], byName[name])
+		}
+	}
+	for _, index := range indexes {
+		if !index.Unique {
+			schema.tieBreak[index.Name] = schema.fields[indexes[0].Name]
+		}
+	}
+
+	return schema, nil
+}
+
+func (schema *structSchema) names() []string {
+	var res []string
+	for _, index := range schema.indexes {
+		res = append(res, index.Name)
+	}
+	return res
+}
+
+func (schema *structSchema) primary() string {
+	return schema.indexes[0].Name
+}
+
+// Compare fields of two structs
+func compareFields(index string, fields []structField, a, b reflect.Value) int {
+	for _, field := range fields {
+		if comparison := field.compare(index, a.Field(field.index), b.Field(field.index)); comparison != 0 {
+			return comparison
+		}
+	}
+	return 0
+}
+
+// Comparator of values of a type, nil if the type isn't supported
+func fieldComparator(typ reflect.Type) func(index string, a, b reflect.Value) int {
+	switch {
+	case typ == timeType:
+		return func(index string, a, b reflect.Value) int {
+			ta, tb := a.Interface().(time.Time), b.Interface().(time.Time)
+			return threeWay(ta.Before(tb), ta.After(tb))
+		}
+	case typ.Implements(itemType):
+		return func(index string, a, b reflect.Value) int {
+			ia, ib := a.Interface().(Item), b.Interface().(Item)
+			return threeWay(ia.Less(index, ib), ib.Less(index, ia))
+		}
+	}
+
+	switch typ.Kind() {
+	case reflect.String:
+		return func(index string, a, b reflect.Value) int {
+			return strings.Compare(a.String(), b.String())
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return func(index string, a, b reflect.Value) int {
+			return threeWay(a.Int() < b.Int(), a.Int() > b.Int())
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return func(index string, a, b reflect.Value) int {
+			return threeWay(a.Uint() < b.Uint(), a.Uint() > b.Uint())
+		}
+	case reflect.Float32, reflect.Float64:
+		return func(index string, a, b reflect.Value) int {
+			return threeWay(a.Float() < b.Float(), a.Float() > b.Float())
+		}
+	case reflect.Bool:
+		return func(index string, a, b reflect.Value) int {
+			return threeWay(!a.Bool() && b.Bool(), a.Bool() && !b.Bool())
+		}
+	case reflect.Slice:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			return func(index string, a, b reflect.Value) int {
+				return bytes.Compare(a.Bytes(), b.Bytes())
+			}
+		}
+	}
+	return nil
+}
+
+var itemType = reflect.TypeOf((*Item)(nil)).Elem()
+
+/*
+	Struct stored in a store built from its tags
+
+	Probes placed before (-1) or after (1) every item with the same fields of an index
+	find items of non-unique indexes without knowing their primary key.
+*/
+type structItem struct {
+	value  reflect.Value
+	schema *structSchema
+	edge   int
+}
+
+func (si structItem) Less(index string, than interface{}) bool {
+	other := than.(structItem)
+	if comparison := compareFields(index, si.schema.fields[index], si.value, other.value); comparison != 0 {
+		return comparison < 0
+	}
+	if si.edge != other.edge {
+		return si.edge < other.edge
+	}
+	return compareFields(index, si.schema.tieBreak[index], si.value, other.value) < 0
+}
+
+// Raw key of indexes of a single field, so that queries can match them
+func (si structItem) Key(index string) interface{} {
+	fields := si.schema.fields[index]
+	if len(fields) != 1 {
+		return nil
+	}
+	return si.value.Field(fields[0].index).Interface()
+}
+
+// Whether two structs have the same fields for an index
+func (schema *structSchema) sameKey(index string, a, b reflect.Value) bool {
+	return compareFields(index, schema.fields[index], a, b) == 0
+}
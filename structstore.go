@@ -0,0 +1,192 @@
+package memstore
+
+import (
+	"reflect"
+)
+
+/*
+	Store of structs of type T, indexed as told by their tags (see FieldIndex)
+
+	Items are looked up by structs holding the fields of an index, other fields being ignored.
+*/
+type StructStore[T any] struct {
+	ms     *Memstore
+	schema *structSchema
+}
+
+/*
+	Store of structs of type T, with indexes and comparators read from its tags
+
+	Updates move structs within indexes unless options say otherwise (see WithIndexChangePolicy).
+*/
+func NewFromStruct[T any](options ...Option) (*StructStore[T], error) {
+	schema, err := compileStructSchema(reflect.TypeOf((*T)(nil)).Elem())
+	if err != nil {
+		return nil, err
+	}
+
+	var unique []string
+	for _, index := range schema.indexes[1:] {
+		if index.Unique {
+			unique = append(unique, index.Name)
+		}
+	}
+	options = append([]Option{WithIndexChangePolicy(RepositionOnIndexChange)}, options...)
+	options = append(options, WithUnique(unique...))
+
+	return &StructStore[T]{
+		ms:     New(schema.names(), options...),
+		schema: schema,
+	}, nil
+}
+
+// Underlying store, holding items that order structs (for aggregates, queries, metrics...)
+func (s *StructStore[T]) Store() *Memstore {
+	return s.ms
+}
+
+// Indexes read from tags, the first one being the primary index
+func (s *StructStore[T]) Indexes() []StructIndex {
+	return append([]StructIndex(nil), s.schema.indexes...)
+}
+
+func (s *StructStore[T]) wrap(x T, edge int) structItem {
+	return structItem{value: reflect.ValueOf(x), schema: s.schema, edge: edge}
+}
+
+func (s *StructStore[T]) unwrap(x Item) T {
+	return x.(structItem).value.Interface().(T)
+}
+
+func (s *StructStore[T]) unique(index string) bool {
+	for _, si := range s.schema.indexes {
+		if si.Name == index {
+			return si.Unique
+		}
+	}
+	return false
+}
+
+// Find stored struct with the same fields as x for an index
+func (s *StructStore[T]) find(x T, index string) (structItem, bool) {
+	if _, ok := s.schema.fields[index]; !ok {
+		return structItem{}, false
+	}
+
+	if s.unique(index) {
+		found := s.ms.Get(s.wrap(x, 0), index)
+		if found == nil {
+			return structItem{}, false
+		}
+		return found.(structItem), true
+	}
+
+	// Probe comes before every struct with the same fields
+	probe := s.wrap(x, -1)
+	var res structItem
+	ok := false
+	s.ms.GetRangeBounds(NewRange(Inclusive(probe), Unbounded()), index, func(found Item) bool {
+		res = found.(structItem)
+		ok = s.schema.sameKey(index, probe.value, res.value)
+		return false
+	})
+	return res, ok
+}
+
+/*
+	Add struct, replacing the one with the same primary key
+
+	Fails with ErrDuplicate if another one has the same unique fields, or ErrReadOnly on followers
+*/
+func (s *StructStore[T]) Add(x T) error {
+	return s.ms.Put(s.wrap(x, 0))
+}
+
+// Get the struct with the same fields for an index (the first one by primary key for non-unique indexes)
+func (s *StructStore[T]) Get(x T, index string) (T, bool) {
+	found, ok := s.find(x, index)
+	if !ok {
+		var zero T
+		return zero, false
+	}
+	return s.unwrap(found), true
+}
+
+// Delete the struct found like Get does
+func (s *StructStore[T]) Delete(x T, index string) (T, bool) {
+	var zero T
+	found, ok := s.find(x, index)
+	if !ok {
+		return zero, false
+	}
+	deleted := s.ms.Delete(found, s.schema.primary())
+	if deleted == nil {
+		return zero, false
+	}
+	return s.unwrap(deleted), true
+}
+
+/*
+	Iterate over structs from the fields of from (inclusive) to those of to (exclusive) for an index,
+	until test returns false
+*/
+func (s *StructStore[T]) GetRange(from, to T, index string, test func(T) bool) {
+	r := NewRange(Inclusive(s.wrap(from, -1)), Exclusive(s.wrap(to, -1)))
+	s.ms.GetRangeBounds(r, index, func(x Item) bool {
+		return test(s.unwrap(x))
+	})
+}
+
+// Iterate over every struct in order of an index, until test returns false
+func (s *StructStore[T]) Ascend(index string, test func(T) bool) {
+	s.ms.GetRangeBounds(All(), index, func(x Item) bool {
+		return test(s.unwrap(x))
+	})
+}
+
+func (s *StructStore[T]) Len() int {
+	return s.ms.Len()
+}
+
+func (s *StructStore[T]) Min(index string) (T, bool) {
+	return s.extremum(s.ms.Min(index))
+}
+
+func (s *StructStore[T]) Max(index string) (T, bool) {
+	return s.extremum(s.ms.Max(index))
+}
+
+func (s *StructStore[T]) extremum(found Item) (T, bool) {
+	if found == nil {
+		var zero T
+		return zero, false
+	}
+	return s.unwrap(found), true
+}
+
+/*
+	Replace the struct found like Get does with the result of modify, moving it within indexes
+
+	Fails with ErrNotFound, ErrNotModified if modify rejects the struct, ErrDuplicate, or ErrReadOnly on followers
+*/
+func (s *StructStore[T]) Update(x T, index string, modify func(T) (T, bool)) (T, error) {
+	var zero T
+	if _, ok := s.schema.fields[index]; !ok {
+		return zero, ErrUnknownIndex
+	}
+	found, ok := s.find(x, index)
+	if !ok {
+		return zero, ErrNotFound
+	}
+
+	// Modify runs on the struct stored under the write lock
+	var modified T
+	if _, err := s.ms.Update(found, s.schema.primary(), func(current Item) (Item, bool) {
+		var ok bool
+		modified, ok = modify(s.unwrap(current))
+		return s.wrap(modified, 0), ok
+	}); err != nil {
+		return zero, err
+	}
+	return modified, nil
+}
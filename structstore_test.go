@@ -0,0 +1,205 @@
+package memstore
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+/*
+	Version compared by its own Less, as custom types are
+*/
+type semver struct {
+	major, minor int
+}
+
+func (v semver) Less(index string, than interface{}) bool {
+	other := than.(semver)
+	if v.major != other.major {
+		return v.major < other.major
+	}
+	return v.minor < other.minor
+}
+
+type event struct {
+	ID      uint64    `memstore:"index=id,primary"`
+	Email   string    `memstore:"index=email,unique"`
+	Tenant  string    `memstore:"index=tenant_ts,part=0"`
+	Time    time.Time `memstore:"index=tenant_ts,part=1"`
+	Score   float64   `memstore:"index=score"`
+	Payload []byte    `memstore:"index=payload"`
+	Version semver    `memstore:"index=version"`
+	Note    string
+}
+
+func testEvents(t *testing.T) *StructStore[event] {
+	s, err := NewFromStruct[event]()
+	if err != nil {
+		t.Fatalf("Creating store failed, err=%v", err)
+	}
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, e := range []event{
+		{1, "a@x", "acme", base.Add(3 * time.Hour), 0.5, []byte("b"), semver{1, 2}, ""},
+		{2, "b@x", "acme", base.Add(1 * time.Hour), 2.5, []byte("a"), semver{1, 10}, ""},
+		{3, "c@x", "beta", base, -1, []byte("ab"), semver{0, 9}, ""},
+		{4, "d@x", "acme", base.Add(1 * time.Hour), 2.5, []byte("c"), semver{2, 0}, ""},
+	} {
+		if err := s.Add(e); err != nil {
+			t.Fatalf("Adding failed, err=%v", err)
+		}
+	}
+	return s
+}
+
+func eventIDs(s *StructStore[event], index string) []uint64 {
+	var res []uint64
+	s.Ascend(index, func(e event) bool {
+		res = append(res, e.ID)
+		return true
+	})
+	return res
+}
+
+func TestStructTags(t *testing.T) {
+	fields, err := ParseStructTag("index=tenant_ts,part=1;index=time,unique")
+	expected := []FieldIndex{{Index: "tenant_ts", Part: 1}, {Index: "time", Unique: true}}
+	if err != nil || !reflect.DeepEqual(fields, expected) {
+		t.Errorf("Parsing tag is wrong, fields=%v err=%v", fields, err)
+	}
+
+	s := testEvents(t)
+	indexes := []StructIndex{
+		{"id", []string{"ID"}, true},
+		{"email", []string{"Email"}, true},
+		{"tenant_ts", []string{"Tenant", "Time"}, false},
+		{"score", []string{"Score"}, false},
+		{"payload", []string{"Payload"}, false},
+		{"version", []string{"Version"}, false},
+	}
+	if !reflect.DeepEqual(s.Indexes(), indexes) {
+		t.Errorf("Indexes are wrong, indexes=%v", s.Indexes())
+	}
+
+	for _, invalid := range []interface{}{
+		struct {
+			A int `memstore:"index"`
+		}{},
+		struct {
+			A int `memstore:"index=a,desc"`
+		}{},
+		struct {
+			a int `memstore:"index=a"`
+		}{},
+		struct {
+			A map[string]int `memstore:"index=a"`
+		}{},
+		struct {
+			A int `memstore:"index=a,part=1"`
+			B int `memstore:"index=a,part=1"`
+		}{},
+		struct {
+			A int `memstore:"index=a,primary"`
+			B int `memstore:"index=b,primary"`
+		}{},
+		struct{ A int }{},
+		0,
+	} {
+		if _, err := compileStructSchema(reflect.TypeOf(invalid)); !errors.Is(err, ErrInvalidTag) {
+			t.Errorf("Tags of %T should be rejected, err=%v", invalid, err)
+		}
+	}
+}
+
+func TestStructStoreOrder(t *testing.T) {
+	s := testEvents(t)
+
+	for index, expected := range map[string][]uint64{
+		"id":        {1, 2, 3, 4},
+		"email":     {1, 2, 3, 4},
+		"tenant_ts": {2, 4, 1, 3},
+		"score":     {3, 1, 2, 4},
+		"payload":   {2, 3, 1, 4},
+		"version":   {3, 1, 2, 4},
+	} {
+		if ids := eventIDs(s, index); !reflect.DeepEqual(ids, expected) {
+			t.Errorf("Order of %v is wrong, ids=%v", index, ids)
+		}
+	}
+
+	// Ranges over some fields of a compound index
+	var ids []uint64
+	s.GetRange(event{Tenant: "acme"}, event{Tenant: "beta"}, "tenant_ts", func(e event) bool {
+		ids = append(ids, e.ID)
+		return true
+	})
+	if !reflect.DeepEqual(ids, []uint64{2, 4, 1}) {
+		t.Errorf("Range on compound index is wrong, ids=%v", ids)
+	}
+
+	if min, ok := s.Min("score"); !ok || min.ID != 3 {
+		t.Errorf("Min is wrong, min=%v", min)
+	}
+	if max, ok := s.Max("tenant_ts"); !ok || max.ID != 3 {
+		t.Errorf("Max is wrong, max=%v", max)
+	}
+}
+
+func TestStructStoreWrites(t *testing.T) {
+	s := testEvents(t)
+
+	if e, ok := s.Get(event{Email: "c@x"}, "email"); !ok || e.ID != 3 {
+		t.Errorf("Getting by unique index failed, e=%v", e)
+	}
+	if e, ok := s.Get(event{Score: 2.5}, "score"); !ok || e.ID != 2 {
+		t.Errorf("Getting by non-unique index should find first by primary key, e=%v", e)
+	}
+	if _, ok := s.Get(event{ID: 1}, "notIndex"); ok {
+		t.Error("Getting by unknown index should fail")
+	}
+
+	// Adding replaces struct with the same primary key, unless a unique index conflicts
+	if err := s.Add(event{ID: 1, Email: "z@x", Note: "replaced"}); err != nil || s.Len() != 4 {
+		t.Errorf("Adding with existing primary key should replace, err=%v", err)
+	}
+	if _, ok := s.Get(event{Email: "a@x"}, "email"); ok {
+		t.Error("Replaced struct should be moved within indexes")
+	}
+	if err := s.Add(event{ID: 5, Email: "b@x"}); err != ErrDuplicate || s.Len() != 4 {
+		t.Errorf("Duplicate on unique index should be rejected, err=%v", err)
+	}
+
+	updated, err := s.Update(event{ID: 2}, "id", func(e event) (event, bool) {
+		e.Score = -5
+		return e, true
+	})
+	if err != nil || updated.Score != -5 || eventIDs(s, "score")[0] != 2 {
+		t.Errorf("Updating failed, updated=%v err=%v", updated, err)
+	}
+	if _, err := s.Update(event{ID: 2}, "id", func(e event) (event, bool) {
+		e.Email = "c@x"
+		return e, true
+	}); err != ErrDuplicate {
+		t.Errorf("Update conflicting on unique index should fail, err=%v", err)
+	}
+	if _, err := s.Update(event{ID: 2}, "id", func(e event) (event, bool) {
+		e.ID = 3
+		return e, true
+	}); err != ErrDuplicate {
+		t.Errorf("Update conflicting on primary key should fail, err=%v", err)
+	}
+	if _, err := s.Update(event{ID: 9}, "id", func(e event) (event, bool) { return e, true }); err != ErrNotFound {
+		t.Errorf("Updating missing struct should fail, err=%v", err)
+	}
+
+	if deleted, ok := s.Delete(event{Tenant: "acme"}, "tenant_ts"); ok {
+		t.Errorf("Deleting needs every field of the index, deleted=%v", deleted)
+	}
+	if deleted, ok := s.Delete(event{Email: "d@x"}, "email"); !ok || deleted.ID != 4 || s.Len() != 3 {
+		t.Errorf("Deleting failed, deleted=%v", deleted)
+	}
+	if report := s.Store().Verify(); !report.OK() {
+		t.Errorf("Store should be consistent, report=%v", report)
+	}
+}
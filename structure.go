@@ -15,14 +15,52 @@ type Item interface {
 }
 
 type internalItem struct {
-	item *Item
+	// Item as given, shared by the nodes of every tree
+	value Item
+
+	// Identity of the item, shared by the nodes of every tree
+	id uint64
+
+	// Sequence number of the last write to the item
+	version uint64
 }
 
 func (ii *internalItem) Less(index string, than llrb.Item) bool {
-	ithan := than.(*internalItem)
-	var anonItem interface{} = *(ithan.item)
-	var item Item = *(ii.item)
-	return item.Less(index, anonItem)
+	if b, ok := than.(*boundItem); ok {
+		return b.compare(index, ii) > 0
+	}
+	return ii.value.Less(index, than.(*internalItem).value)
+}
+
+/*
+	Node to walk trees from, never stored: before (-1) or after (1) every item, or at a raw key (0)
+
+	Kept apart from internalItem so that stored items don't carry what only bounds need.
+*/
+type boundItem struct {
+	position int
+	key      interface{}
+}
+
+// Compare bound to an item: negative if it's before the item, positive if after
+func (b *boundItem) compare(index string, ii *internalItem) int {
+	if b.position != 0 {
+		return b.position
+	}
+	comparison, _ := compareKeys(b.key, keyOf(ii.value, index))
+	return comparison
+}
+
+func (b *boundItem) Less(index string, than llrb.Item) bool {
+	other, ok := than.(*boundItem)
+	if !ok {
+		return b.compare(index, than.(*internalItem)) < 0
+	}
+	if b.position != other.position {
+		return b.position < other.position
+	}
+	comparison, _ := compareKeys(b.key, other.key)
+	return comparison < 0
 }
 
 /*
@@ -30,12 +68,76 @@ func (ii *internalItem) Less(index string, than llrb.Item) bool {
 	Nothing is exported
 */
 type Memstore struct {
+	// Names of indexes, in the order given at creation
+	indexes []string
+
 	// Slice of trees for each index
-	trees []*llrb.LLRB
+	trees []OrderedIndex
 
 	// Map of indexes we're supporting
-	indexTree map[string]*llrb.LLRB
+	indexTree map[string]OrderedIndex
+
+	// Backend of trees, for every index or some of them
+	defaultBackend Backend
+	backends       map[string]Backend
+
+	// Augmented trees maintaining aggregates, for each index
+	augmented map[string][]*augmentedTree
+
+	// Persistent copy of the primary index, only if views are kept
+	shared *sharedTree
+
+	// Full-text, trigram and geospatial indexes, by name
+	text     map[string]*textIndex
+	trigrams map[string]*trigramIndex
+	geo      map[string]*geoIndex
+
+	// What to do when an in-place update changes indexed fields
+	indexChangePolicy IndexChangePolicy
+
+	// Secondary indexes holding at most one item per key
+	unique []string
+
+	// Comparator checks, only in debug mode
+	debug *debugState
+
+	// Instrumentation, only if enabled
+	metrics *metrics
+	slowLog *slowLog
+	hooks   []Hooks
 
 	// RW lock
 	m sync.RWMutex
+
+	// Last identity given to an item
+	lastID uint64
+
+	// Global sequence number, incremented on every mutation
+	sequence uint64
+
+	// Lineage of identities and versions, shared with followers
+	lineage uint64
+
+	// Whether lineage is that of a leader, until the store writes on its own
+	inherited bool
+
+	// Last mutations, kept for followers
+	opLog *opLog
+
+	// Items by identity, kept once mutations of a leader are applied
+	byID map[uint64]*internalItem
+
+	// Whether writes are rejected, as a follower, and the number rejected (accessed atomically)
+	readOnly       int32
+	rejectedWrites uint64
+
+	// Items evicted by an insert of another one with their primary key (accessed atomically)
+	evictions uint64
+
+	// Last write of every primary key, when mergeable with other replicas
+	merge *mergeState
+
+	// Per-index statistics used to plan queries (guarded by their own lock)
+	stats      map[string]*indexStats
+	statsMutex sync.Mutex
 }
@@ -0,0 +1,261 @@
+package memstore
+
+import (
+	"github.com/mngharbi/GoLLRB/llrb"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+/*
+	Full-text index over string fields of items, searched with Search
+
+	Fields are split into terms by Tokenize (lower-cased runs of letters and digits if nil).
+	Phrases don't span fields.
+*/
+type TextIndex struct {
+	Name     string
+	Fields   []func(Item) string
+	Tokenize func(string) []string
+}
+
+// Default tokenizer: lower-cased runs of letters and digits
+func Tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Item found by a search, and its BM25 score
+type TextHit struct {
+	Item  Item
+	Score float64
+}
+
+// Terms of an indexed item
+type textDoc struct {
+	ii *internalItem
+
+	// Distinct terms, and number of terms
+	terms  []string
+	length int
+}
+
+// Term in the sorted dictionary of a text index
+type textTerm string
+
+func (t textTerm) Less(index string, than llrb.Item) bool {
+	return t < than.(textTerm)
+}
+
+/*
+	Inverted index: positions of every term in every item holding it
+
+	Items are identified by their identity, so that updates in place replace their terms.
+*/
+type textIndex struct {
+	config   TextIndex
+	postings map[string]map[uint64][]int
+	docs     map[uint64]*textDoc
+
+	// Terms in order, for prefix queries
+	dictionary OrderedIndex
+
+	// Sum of lengths of documents
+	totalLength int
+}
+
+func newTextIndex(config TextIndex) *textIndex {
+	if config.Tokenize == nil {
+		config.Tokenize = Tokenize
+	}
+	return &textIndex{
+		config:     config,
+		postings:   map[string]map[uint64][]int{},
+		docs:       map[uint64]*textDoc{},
+		dictionary: newOrderedIndex(LLRBBackend, ""),
+	}
+}
+
+// Index terms of an item, replacing those it had (expects a write lock)
+func (ti *textIndex) put(ii *internalItem) {
+	ti.remove(ii.id)
+
+	doc := &textDoc{ii: ii}
+	position := 0
+	for _, field := range ti.config.Fields {
+		for _, term := range ti.config.Tokenize(field(ii.value)) {
+			positions, ok := ti.postings[term]
+			if !ok {
+				positions = map[uint64][]int{}
+				ti.postings[term] = positions
+				ti.dictionary.ReplaceOrInsert(textTerm(term))
+			}
+			if _, ok := positions[ii.id]; !ok {
+				doc.terms = append(doc.terms, term)
+			}
+			positions[ii.id] = append(positions[ii.id], position)
+			position++
+			doc.length++
+		}
+
+		// Gap between fields keeps phrases within one
+		position++
+	}
+
+	ti.docs[ii.id] = doc
+	ti.totalLength += doc.length
+}
+
+// Forget terms of an item (expects a write lock)
+func (ti *textIndex) remove(id uint64) {
+	doc, ok := ti.docs[id]
+	if !ok {
+		return
+	}
+	for _, term := range doc.terms {
+		positions := ti.postings[term]
+		delete(positions, id)
+		if len(positions) == 0 {
+			delete(ti.postings, term)
+			ti.dictionary.Delete(textTerm(term))
+		}
+	}
+	delete(ti.docs, id)
+	ti.totalLength -= doc.length
+}
+
+// Terms of the dictionary starting with prefix, in order
+func (ti *textIndex) withPrefix(prefix string, iterator func(term string) bool) {
+	ti.dictionary.AscendGreaterOrEqual(textTerm(prefix), func(it llrb.Item) bool {
+		term := string(it.(textTerm))
+		return strings.HasPrefix(term, prefix) && iterator(term)
+	})
+}
+
+// Okapi BM25 parameters
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// BM25 score of a document for distinct terms
+func (ti *textIndex) score(id uint64, terms []string) float64 {
+	doc := ti.docs[id]
+	n := float64(len(ti.docs))
+	averageLength := float64(ti.totalLength) / n
+
+	score := 0.0
+	for _, term := range terms {
+		positions := ti.postings[term]
+		tf := float64(len(positions[id]))
+		if tf == 0 {
+			continue
+		}
+		df := float64(len(positions))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		score += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*float64(doc.length)/averageLength))
+	}
+	return score
+}
+
+/*
+	Maintain a full-text index of items, kept in sync with every write
+
+	Fails if a text index with the same name exists
+*/
+func (ms *Memstore) AddTextIndex(config TextIndex) bool {
+	op := ms.begin(opAddTextIndex, config.Name)
+	defer ms.end(op)
+
+	ms.lock(op)
+	defer ms.unlock(op)
+
+	if _, ok := ms.text[config.Name]; ok {
+		return false
+	}
+
+	ti := newTextIndex(config)
+	ascendRange(ms.trees[0], ms.indexes[0], All(), func(ii *internalItem) bool {
+		ti.put(ii)
+		return true
+	})
+	op.addItems(len(ti.docs))
+
+	if ms.text == nil {
+		ms.text = map[string]*textIndex{}
+	}
+	ms.text[config.Name] = ti
+
+	return true
+}
+
+/*
+	Items of a text index matching a query, by decreasing BM25 score of the terms they matched
+
+	Items with the same score are in the order they were added. Returns at most limit hits (all if limit isn't positive),
+	fails with ErrUnknownIndex if there is no text index with that name.
+*/
+func (ms *Memstore) Search(name string, query TextQuery, limit int) ([]TextHit, error) {
+	op := ms.begin(opSearch, name)
+	defer ms.end(op)
+
+	ms.rlock(op)
+	defer ms.runlock(op)
+
+	ti, ok := ms.text[name]
+	if !ok {
+		return nil, ErrUnknownIndex
+	}
+
+	docs, terms := query.match(ti)
+	terms = distinct(terms)
+
+	ids := make([]uint64, 0, len(docs))
+	scores := make(map[uint64]float64, len(docs))
+	for id := range docs {
+		ids = append(ids, id)
+		scores[id] = ti.score(id, terms)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if scores[ids[i]] != scores[ids[j]] {
+			return scores[ids[i]] > scores[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	if limit > 0 && len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	hits := make([]TextHit, len(ids))
+	for i, id := range ids {
+		hits[i] = TextHit{Item: ti.docs[id].ii.value, Score: scores[id]}
+	}
+	op.addItems(len(hits))
+
+	return hits, nil
+}
+
+func distinct(terms []string) []string {
+	seen := make(map[string]bool, len(terms))
+	res := terms[:0:0]
+	for _, term := range terms {
+		if !seen[term] {
+			seen[term] = true
+			res = append(res, term)
+		}
+	}
+	return res
+}
+
+// Keep text indexes in sync with a put or delete (expects a write lock)
+func (ms *Memstore) indexText(kind MutationKind, ii *internalItem) {
+	for _, ti := range ms.text {
+		if kind == MutationDelete {
+			ti.remove(ii.id)
+		} else {
+			ti.put(ii)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package memstore
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type article struct {
+	id          int
+	title, body string
+}
+
+func (a article) Less(index string, than interface{}) bool {
+	return a.id < than.(article).id
+}
+
+func articleText() TextIndex {
+	return TextIndex{
+		Name: "text",
+		Fields: []func(Item) string{
+			func(x Item) string { return x.(article).title },
+			func(x Item) string { return x.(article).body },
+		},
+	}
+}
+
+// Ids of items found by a search, in order
+func searchIDs(t *testing.T, ms *Memstore, query TextQuery) []int {
+	hits, err := ms.Search("text", query, 0)
+	if err != nil {
+		t.Fatalf("Search failed, err=%v", err)
+	}
+	res := []int{}
+	for _, hit := range hits {
+		res = append(res, hit.Item.(article).id)
+	}
+	return res
+}
+
+func TestTextSearch(t *testing.T) {
+	ms := New([]string{"id"})
+	ms.Add(article{1, "In-memory stores", "A store keeps items in memory, ordered by indexes."})
+	ms.Add(article{2, "Disk stores", "Items are kept on disk. Memory is a cache."})
+
+	// Index is built from items already there, and kept up to date
+	if !ms.AddTextIndex(articleText()) || ms.AddTextIndex(articleText()) {
+		t.Fatal("Text index should be added once")
+	}
+	ms.Add(article{3, "Caching", "Cache items in memory memory memory."})
+	ms.Add(article{4, "Indexes", "Ordered indexes find items by key."})
+
+	for _, c := range []struct {
+		query    TextQuery
+		expected []int
+	}{
+		// Items mentioning memory more often, relative to their length, rank first
+		{TextTerm("Memory"), []int{3, 1, 2}},
+		{TextTerm("nothing"), []int{}},
+		{TextPhrase("items in memory"), []int{3, 1}},
+		{TextPhrase("memory items"), []int{}},
+		{TextPhrase("stores a store"), []int{}},
+		{TextPrefix("cach"), []int{3, 2}},
+		{TextPrefix("ind"), []int{4, 1}},
+		{TextAnd(TextTerm("items"), TextTerm("disk")), []int{2}},
+		{TextAnd(TextTerm("items"), TextNot(TextTerm("memory"))), []int{4}},
+		{TextOr(TextTerm("disk"), TextTerm("key")), []int{2, 4}},
+		{TextNot(TextPrefix("stor")), []int{3, 4}},
+	} {
+		if got := searchIDs(t, ms, c.query); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("Search for %+v is wrong, expected=%v got=%v", c.query, c.expected, got)
+		}
+	}
+
+	hits, _ := ms.Search("text", TextTerm("items"), 2)
+	if len(hits) != 2 || hits[0].Score < hits[1].Score || hits[1].Score <= 0 {
+		t.Errorf("Hits should be limited and by decreasing score, hits=%v", hits)
+	}
+	if _, err := ms.Search("unknown", TextTerm("items"), 0); err != ErrUnknownIndex {
+		t.Errorf("Search of unknown index should fail, err=%v", err)
+	}
+}
+
+func TestTextIndexSync(t *testing.T) {
+	ms := New([]string{"id"})
+	ms.AddTextIndex(articleText())
+	ms.Add(article{1, "red fox", ""})
+	ms.Add(article{2, "brown fox", ""})
+
+	ms.UpdateData(article{id: 1}, "id", func(x Item) (Item, bool) {
+		return article{1, "red wolf", ""}, true
+	})
+	ms.UpdateWithIndexes(article{id: 2}, "id", func(x Item) (Item, bool) {
+		return article{5, "brown fox", "jumps"}, true
+	})
+	ms.Add(article{3, "grey fox", ""})
+	ms.Delete(article{id: 3}, "id")
+
+	if got := searchIDs(t, ms, TextTerm("fox")); !reflect.DeepEqual(got, []int{5}) {
+		t.Errorf("Updates and deletes should be indexed, got=%v", got)
+	}
+	if got := searchIDs(t, ms, TextTerm("wolf")); !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("Updates in place should be indexed, got=%v", got)
+	}
+	if hits, _ := ms.Search("text", TextTerm("fox"), 0); len(hits) != 1 || hits[0].Item != (article{5, "brown fox", "jumps"}) {
+		t.Errorf("Hits should hold current items, hits=%v", hits)
+	}
+
+	// Followers index items of snapshots and mutations
+	leader := New([]string{"id"}, WithOpLog(10))
+	follower := New([]string{"id"}, AsFollower())
+	follower.AddTextIndex(articleText())
+	leader.Add(article{1, "red fox", ""})
+	follower.ApplySnapshot(leader.Snapshot())
+	leader.Add(article{2, "red wolf", ""})
+	mutations, _ := leader.Mutations(1, 10)
+	if err := follower.ApplyMutations(mutations); err != nil {
+		t.Fatalf("Applying mutations failed, err=%v", err)
+	}
+	if got := searchIDs(t, follower, TextTerm("red")); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("Follower should index replicated items, got=%v", got)
+	}
+}
+
+func TestTextIndexReplace(t *testing.T) {
+	ms := New([]string{"id"}, WithOpLog(10))
+	follower := New([]string{"id"}, AsFollower())
+	follower.AddTextIndex(articleText())
+	follower.ApplySnapshot(ms.Snapshot())
+	ms.AddTextIndex(articleText())
+
+	// Adding an item with the same primary key deletes the one it replaces
+	ms.Add(article{1, "red fox", ""})
+	ms.Add(article{1, "red wolf", ""})
+	if got := searchIDs(t, ms, TextTerm("fox")); len(got) != 0 {
+		t.Errorf("Replaced item should be forgotten, got=%v", got)
+	}
+	ms.Delete(article{id: 1}, "id")
+	if got := searchIDs(t, ms, TextTerm("red")); len(got) != 0 || ms.Len() != 0 {
+		t.Errorf("Deleted item should be forgotten, got=%v", got)
+	}
+
+	mutations, _ := ms.Mutations(0, 10)
+	if err := follower.ApplyMutations(mutations); err != nil {
+		t.Fatalf("Applying mutations failed, err=%v", err)
+	}
+	if got := searchIDs(t, follower, TextTerm("red")); len(got) != 0 || len(follower.byID) != 0 {
+		t.Errorf("Follower should forget replaced items, got=%v", got)
+	}
+}
+
+func TestParseTextQuery(t *testing.T) {
+	for text, expected := range map[string]TextQuery{
+		"memory":                  TextAnd(TextTerm("memory")),
+		`memory "key value" -di*`: TextAnd(TextTerm("memory"), TextPhrase("key value"), TextNot(TextPrefix("di"))),
+		"a b OR c":                TextOr(TextAnd(TextTerm("a"), TextTerm("b")), TextAnd(TextTerm("c"))),
+		` -"a b"  `:               TextAnd(TextNot(TextPhrase("a b"))),
+	} {
+		query, err := ParseTextQuery(text)
+		if err != nil || !reflect.DeepEqual(query, expected) {
+			t.Errorf("Parsing %q is wrong, query=%+v err=%v", text, query, err)
+		}
+	}
+
+	for _, text := range []string{"", `"open`, "a OR", "OR a"} {
+		if _, err := ParseTextQuery(text); !errors.Is(err, ErrInvalidTextQuery) {
+			t.Errorf("Parsing %q should fail, err=%v", text, err)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package memstore
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+var ErrInvalidTextQuery = errors.New("memstore: invalid text query")
+
+/*
+	Query on a text index (see Search)
+
+	Items are scored by the terms matched by term, phrase and prefix queries, excluded ones don't count.
+*/
+type TextQuery interface {
+	// Identities of items matching, and terms scoring them
+	match(ti *textIndex) (map[uint64]bool, []string)
+}
+
+type phraseQuery struct {
+	text string
+}
+
+type prefixQuery struct {
+	prefix string
+}
+
+type andQuery struct {
+	queries []TextQuery
+}
+
+type orQuery struct {
+	queries []TextQuery
+}
+
+type notQuery struct {
+	query TextQuery
+}
+
+// Items holding a word, in any field (several words are matched as a phrase)
+func TextTerm(word string) TextQuery {
+	return phraseQuery{word}
+}
+
+// Items holding words next to each other, in this order and within one field
+func TextPhrase(text string) TextQuery {
+	return phraseQuery{text}
+}
+
+// Items holding a word starting with prefix
+func TextPrefix(prefix string) TextQuery {
+	return prefixQuery{prefix}
+}
+
+// Items matching every query
+func TextAnd(queries ...TextQuery) TextQuery {
+	return andQuery{queries}
+}
+
+// Items matching any query
+func TextOr(queries ...TextQuery) TextQuery {
+	return orQuery{queries}
+}
+
+// Items not matching a query, typically within TextAnd
+func TextNot(query TextQuery) TextQuery {
+	return notQuery{query}
+}
+
+func (q phraseQuery) match(ti *textIndex) (map[uint64]bool, []string) {
+	terms := ti.config.Tokenize(q.text)
+	res := map[uint64]bool{}
+	if len(terms) == 0 {
+		return res, nil
+	}
+
+	// Look for the phrase from the first term, in items holding every term
+	first := ti.postings[terms[0]]
+candidates:
+	for id, positions := range first {
+		for _, term := range terms[1:] {
+			if _, ok := ti.postings[term][id]; !ok {
+				continue candidates
+			}
+		}
+		for _, start := range positions {
+			if ti.phraseAt(id, terms, start) {
+				res[id] = true
+				break
+			}
+		}
+	}
+	return res, terms
+}
+
+// Whether terms follow each other from a position of an item
+func (ti *textIndex) phraseAt(id uint64, terms []string, start int) bool {
+	for i, term := range terms[1:] {
+		positions := ti.postings[term][id]
+		at := sort.SearchInts(positions, start+i+1)
+		if at == len(positions) || positions[at] != start+i+1 {
+			return false
+		}
+	}
+	return true
+}
+
+func (q prefixQuery) match(ti *textIndex) (map[uint64]bool, []string) {
+	res := map[uint64]bool{}
+	terms := ti.config.Tokenize(q.prefix)
+	if len(terms) != 1 {
+		return res, nil
+	}
+
+	var matched []string
+	ti.withPrefix(terms[0], func(term string) bool {
+		matched = append(matched, term)
+		for id := range ti.postings[term] {
+			res[id] = true
+		}
+		return true
+	})
+	return res, matched
+}
+
+func (q andQuery) match(ti *textIndex) (map[uint64]bool, []string) {
+	var res map[uint64]bool
+	var terms []string
+	var excluded []map[uint64]bool
+
+	for _, query := range q.queries {
+		if not, ok := query.(notQuery); ok {
+			docs, _ := not.query.match(ti)
+			excluded = append(excluded, docs)
+			continue
+		}
+
+		docs, queryTerms := query.match(ti)
+		terms = append(terms, queryTerms...)
+		if res == nil {
+			res = docs
+			continue
+		}
+		for id := range res {
+			if !docs[id] {
+				delete(res, id)
+			}
+		}
+	}
+
+	// Only exclusions: every other item matches
+	if res == nil {
+		res = ti.everything()
+	}
+	for _, docs := range excluded {
+		for id := range docs {
+			delete(res, id)
+		}
+	}
+	return res, terms
+}
+
+func (q orQuery) match(ti *textIndex) (map[uint64]bool, []string) {
+	res := map[uint64]bool{}
+	var terms []string
+	for _, query := range q.queries {
+		docs, queryTerms := query.match(ti)
+		terms = append(terms, queryTerms...)
+		for id := range docs {
+			res[id] = true
+		}
+	}
+	return res, terms
+}
+
+func (q notQuery) match(ti *textIndex) (map[uint64]bool, []string) {
+	return andQuery{[]TextQuery{q}}.match(ti)
+}
+
+func (ti *textIndex) everything() map[uint64]bool {
+	res := make(map[uint64]bool, len(ti.docs))
+	for id := range ti.docs {
+		res[id] = true
+	}
+	return res
+}
+
+/*
+	Read a query written like a search box
+
+	Words must all match, "quoted words" match as a phrase, a word ending with * is a prefix
+	and one starting with - is excluded. OR between words makes alternatives of what's around it:
+
+		memory "key value" -disk OR cach*
+
+	Fails with ErrInvalidTextQuery if a quote isn't closed or nothing is searched.
+*/
+func ParseTextQuery(text string) (TextQuery, error) {
+	var alternatives []TextQuery
+	var clause []TextQuery
+
+	closeClause := func() error {
+		if len(clause) == 0 {
+			return ErrInvalidTextQuery
+		}
+		alternatives = append(alternatives, TextAnd(clause...))
+		clause = nil
+		return nil
+	}
+
+	rest := strings.TrimSpace(text)
+	for rest != "" {
+		negated := false
+		if strings.HasPrefix(rest, "-") {
+			negated = true
+			rest = rest[1:]
+		}
+
+		var query TextQuery
+		switch {
+		case strings.HasPrefix(rest, `"`):
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				return nil, ErrInvalidTextQuery
+			}
+			query = TextPhrase(rest[1 : end+1])
+			rest = rest[end+2:]
+		default:
+			word := rest
+			if end := strings.IndexAny(rest, " \t\n"); end >= 0 {
+				word = rest[:end]
+			}
+			rest = rest[len(word):]
+
+			switch {
+			case word == "OR" && !negated:
+				if err := closeClause(); err != nil {
+					return nil, err
+				}
+			case strings.HasSuffix(word, "*"):
+				query = TextPrefix(strings.TrimSuffix(word, "*"))
+			case word != "":
+				query = TextTerm(word)
+			}
+		}
+		rest = strings.TrimSpace(rest)
+
+		if query == nil {
+			continue
+		}
+		if negated {
+			query = TextNot(query)
+		}
+		clause = append(clause, query)
+	}
+
+	if err := closeClause(); err != nil {
+		return nil, err
+	}
+	if len(alternatives) == 1 {
+		return alternatives[0], nil
+	}
+	return TextOr(alternatives...), nil
+}
@@ -0,0 +1,438 @@
+package memstore
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+)
+
+/*
+	Index of the trigrams of a string of items, finding those containing a substring or matching a regular expression
+
+	Trigrams are taken from the lower-cased string, so that case-insensitive expressions use the index too.
+*/
+type TrigramIndex struct {
+	Name string
+	Text func(Item) string
+}
+
+// Item and the string it's searched by
+type trigramDoc struct {
+	ii    *internalItem
+	text  string
+	grams []string
+}
+
+/*
+	Items holding every trigram, by identity
+
+	Candidates found by intersecting posting lists are then checked against their string.
+*/
+type trigramIndex struct {
+	config   TrigramIndex
+	postings map[string]map[uint64]bool
+	docs     map[uint64]*trigramDoc
+}
+
+func newTrigramIndex(config TrigramIndex) *trigramIndex {
+	return &trigramIndex{
+		config:   config,
+		postings: map[string]map[uint64]bool{},
+		docs:     map[uint64]*trigramDoc{},
+	}
+}
+
+// Distinct trigrams of a lower-cased string
+func trigrams(s string) []string {
+	s = strings.ToLower(s)
+	var res []string
+	seen := map[string]bool{}
+	for i := 0; i+3 <= len(s); i++ {
+		if gram := s[i : i+3]; !seen[gram] {
+			seen[gram] = true
+			res = append(res, gram)
+		}
+	}
+	return res
+}
+
+// Index trigrams of an item, replacing those it had (expects a write lock)
+func (gi *trigramIndex) put(ii *internalItem) {
+	gi.remove(ii.id)
+
+	doc := &trigramDoc{ii: ii, text: gi.config.Text(ii.value)}
+	doc.grams = trigrams(doc.text)
+	for _, gram := range doc.grams {
+		ids, ok := gi.postings[gram]
+		if !ok {
+			ids = map[uint64]bool{}
+			gi.postings[gram] = ids
+		}
+		ids[ii.id] = true
+	}
+	gi.docs[ii.id] = doc
+}
+
+// Forget trigrams of an item (expects a write lock)
+func (gi *trigramIndex) remove(id uint64) {
+	doc, ok := gi.docs[id]
+	if !ok {
+		return
+	}
+	for _, gram := range doc.grams {
+		ids := gi.postings[gram]
+		delete(ids, id)
+		if len(ids) == 0 {
+			delete(gi.postings, gram)
+		}
+	}
+	delete(gi.docs, id)
+}
+
+/*
+	Trigrams a string must hold: every trigram of a gram node, any of an or node, all of an and node
+
+	Nil matches every string.
+*/
+type gramQuery struct {
+	gram string
+	or   bool
+	subs []*gramQuery
+}
+
+// Query for strings containing any of strings, nil if one of them is too short to have trigrams
+func anyOf(strs []string) *gramQuery {
+	alternatives := &gramQuery{or: true}
+	for _, s := range strs {
+		grams := trigrams(s)
+		if len(grams) == 0 {
+			return nil
+		}
+		all := &gramQuery{}
+		for _, gram := range grams {
+			all.subs = append(all.subs, &gramQuery{gram: gram})
+		}
+		alternatives.subs = append(alternatives.subs, all)
+	}
+	if len(alternatives.subs) == 0 {
+		return nil
+	}
+	return alternatives
+}
+
+func allOf(queries ...*gramQuery) *gramQuery {
+	res := &gramQuery{}
+	for _, q := range queries {
+		if q != nil {
+			res.subs = append(res.subs, q)
+		}
+	}
+	if len(res.subs) == 0 {
+		return nil
+	}
+	return res
+}
+
+// Identities of items that may match a query, nil for every item
+func (gi *trigramIndex) candidates(q *gramQuery) map[uint64]bool {
+	switch {
+	case q == nil:
+		return nil
+	case q.gram != "":
+		res := make(map[uint64]bool, len(gi.postings[q.gram]))
+		for id := range gi.postings[q.gram] {
+			res[id] = true
+		}
+		return res
+	case q.or:
+		res := map[uint64]bool{}
+		for _, sub := range q.subs {
+			ids := gi.candidates(sub)
+			if ids == nil {
+				return nil
+			}
+			for id := range ids {
+				res[id] = true
+			}
+		}
+		return res
+	}
+
+	var res map[uint64]bool
+	for _, sub := range q.subs {
+		ids := gi.candidates(sub)
+		switch {
+		case ids == nil:
+		case res == nil:
+			res = ids
+		default:
+			for id := range res {
+				if !ids[id] {
+					delete(res, id)
+				}
+			}
+		}
+	}
+	return res
+}
+
+// Largest set of exact strings tracked while analyzing an expression
+const maxExactStrings = 16
+
+/*
+	What is known of the strings matched by a regular expression:
+	either exactly the strings they can be (exact isn't nil), or a query they satisfy
+*/
+type regexpInfo struct {
+	exact []string
+	match *gramQuery
+}
+
+func (info regexpInfo) query() *gramQuery {
+	if info.exact != nil {
+		return anyOf(info.exact)
+	}
+	return info.match
+}
+
+// Strings made of one of a followed by one of b, nil if there would be too many
+func crossProduct(a, b []string) []string {
+	if len(a)*len(b) > maxExactStrings {
+		return nil
+	}
+	var res []string
+	seen := map[string]bool{}
+	for _, x := range a {
+		for _, y := range b {
+			if s := x + y; !seen[s] {
+				seen[s] = true
+				res = append(res, s)
+			}
+		}
+	}
+	return res
+}
+
+// Analyze a simplified regular expression, like the indexing of Russ Cox's Google Code Search
+func analyzeRegexp(re *syntax.Regexp) regexpInfo {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return regexpInfo{exact: []string{strings.ToLower(string(re.Rune))}}
+
+	case syntax.OpEmptyMatch, syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText, syntax.OpEndText,
+		syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+		return regexpInfo{exact: []string{""}}
+
+	case syntax.OpCharClass:
+		count := 0
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			count += int(re.Rune[i+1]-re.Rune[i]) + 1
+		}
+		if count > maxExactStrings*2 {
+			return regexpInfo{}
+		}
+		var exact []string
+		seen := map[string]bool{}
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			for r := re.Rune[i]; r <= re.Rune[i+1]; r++ {
+				if s := strings.ToLower(string(r)); !seen[s] {
+					seen[s] = true
+					exact = append(exact, s)
+				}
+			}
+		}
+		if len(exact) > maxExactStrings {
+			return regexpInfo{}
+		}
+		return regexpInfo{exact: exact}
+
+	case syntax.OpCapture:
+		return analyzeRegexp(re.Sub[0])
+
+	case syntax.OpQuest:
+		sub := analyzeRegexp(re.Sub[0])
+		if sub.exact != nil && len(sub.exact) < maxExactStrings {
+			return regexpInfo{exact: append(sub.exact, "")}
+		}
+		return regexpInfo{}
+
+	case syntax.OpPlus:
+		return regexpInfo{match: analyzeRegexp(re.Sub[0]).query()}
+
+	case syntax.OpRepeat:
+		if re.Min == 0 {
+			return regexpInfo{}
+		}
+		return regexpInfo{match: analyzeRegexp(re.Sub[0]).query()}
+
+	case syntax.OpConcat:
+		// Strings so far are kept exact while there aren't too many, then required as they are
+		current := []string{""}
+		var match *gramQuery
+		flushed := false
+		for _, sub := range re.Sub {
+			info := analyzeRegexp(sub)
+			if info.exact != nil {
+				if product := crossProduct(current, info.exact); product != nil {
+					current = product
+					continue
+				}
+			}
+			match = allOf(match, anyOf(current))
+			flushed = true
+			if info.exact != nil {
+				current = info.exact
+			} else {
+				match = allOf(match, info.match)
+				current = []string{""}
+			}
+		}
+		if !flushed {
+			return regexpInfo{exact: current}
+		}
+		return regexpInfo{match: allOf(match, anyOf(current))}
+
+	case syntax.OpAlternate:
+		var exact []string
+		alternatives := &gramQuery{or: true}
+		allExact := true
+		for _, sub := range re.Sub {
+			info := analyzeRegexp(sub)
+			if info.exact == nil {
+				allExact = false
+			} else {
+				exact = append(exact, info.exact...)
+			}
+			q := info.query()
+			if q == nil {
+				// An alternative matching anything makes the whole expression match anything
+				alternatives = nil
+			} else if alternatives != nil {
+				alternatives.subs = append(alternatives.subs, q)
+			}
+		}
+		if allExact && len(exact) <= maxExactStrings {
+			return regexpInfo{exact: exact}
+		}
+		if alternatives == nil {
+			return regexpInfo{}
+		}
+		return regexpInfo{match: alternatives}
+	}
+
+	return regexpInfo{}
+}
+
+// Query of trigrams every string matched by an expression holds
+func regexpQuery(re *regexp.Regexp) *gramQuery {
+	parsed, err := syntax.Parse(re.String(), syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	return analyzeRegexp(parsed.Simplify()).query()
+}
+
+/*
+	Maintain a trigram index of a string of items, kept in sync with every write
+
+	Fails if a trigram index with the same name exists
+*/
+func (ms *Memstore) AddTrigramIndex(config TrigramIndex) bool {
+	op := ms.begin(opAddTrigramIndex, config.Name)
+	defer ms.end(op)
+
+	ms.lock(op)
+	defer ms.unlock(op)
+
+	if _, ok := ms.trigrams[config.Name]; ok {
+		return false
+	}
+
+	gi := newTrigramIndex(config)
+	ascendRange(ms.trees[0], ms.indexes[0], All(), func(ii *internalItem) bool {
+		gi.put(ii)
+		return true
+	})
+	op.addItems(len(gi.docs))
+
+	if ms.trigrams == nil {
+		ms.trigrams = map[string]*trigramIndex{}
+	}
+	ms.trigrams[config.Name] = gi
+
+	return true
+}
+
+// Items of a trigram index whose string satisfies match, among candidates (nil for all), in order of the primary index
+func (ms *Memstore) findTrigrams(op *operation, index string, q *gramQuery, match func(string) bool) ([]Item, error) {
+	ms.rlock(op)
+	defer ms.runlock(op)
+
+	gi, ok := ms.trigrams[index]
+	if !ok {
+		return nil, ErrUnknownIndex
+	}
+
+	var res []Item
+	check := func(doc *trigramDoc) {
+		if match(doc.text) {
+			res = append(res, doc.ii.value)
+		}
+	}
+	if candidates := gi.candidates(q); candidates != nil {
+		for id := range candidates {
+			check(gi.docs[id])
+		}
+	} else {
+		for _, doc := range gi.docs {
+			check(doc)
+		}
+	}
+
+	primary := ms.indexes[0]
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Less(primary, res[j])
+	})
+	op.addItems(len(res))
+
+	return res, nil
+}
+
+/*
+	Items of a trigram index whose string contains substr, in order of the primary index
+
+	Substrings shorter than three bytes check every item. Fails with ErrUnknownIndex if there is no trigram index with that name.
+*/
+func (ms *Memstore) Contains(index, substr string) ([]Item, error) {
+	op := ms.begin(opContains, index)
+	defer ms.end(op)
+
+	return ms.findTrigrams(op, index, anyOf([]string{substr}), func(text string) bool {
+		return strings.Contains(text, substr)
+	})
+}
+
+/*
+	Items of a trigram index whose string matches a regular expression, in order of the primary index
+
+	Only items holding the trigrams of literals the expression requires are checked.
+	Fails with ErrUnknownIndex if there is no trigram index with that name.
+*/
+func (ms *Memstore) Regexp(index string, re *regexp.Regexp) ([]Item, error) {
+	op := ms.begin(opRegexp, index)
+	defer ms.end(op)
+
+	return ms.findTrigrams(op, index, regexpQuery(re), re.MatchString)
+}
+
+// Keep trigram indexes in sync with a put or delete (expects a write lock)
+func (ms *Memstore) indexTrigrams(kind MutationKind, ii *internalItem) {
+	for _, gi := range ms.trigrams {
+		if kind == MutationDelete {
+			gi.remove(ii.id)
+		} else {
+			gi.put(ii)
+		}
+	}
+}
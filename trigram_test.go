@@ -0,0 +1,138 @@
+package memstore
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func trigramStore(names ...string) *Memstore {
+	ms := New([]string{"id"})
+	for i, name := range names {
+		ms.Add(TestStruct{i, 0, name})
+	}
+	ms.AddTrigramIndex(TrigramIndex{
+		Name: "name",
+		Text: func(x Item) string { return x.(TestStruct).name },
+	})
+	return ms
+}
+
+var trigramNames = []string{
+	"memstore", "MemCache", "key value store", "ordered index", "trigram index", "go", "",
+	"restore point", "storehouse", "Indexed Memory", "regexp/syntax", "abcabc",
+}
+
+// Items of a store whose name satisfies match, by id
+func matchingNames(names []string, match func(string) bool) []Item {
+	var res []Item
+	for i, name := range names {
+		if match(name) {
+			res = append(res, TestStruct{i, 0, name})
+		}
+	}
+	return res
+}
+
+func TestTrigramContains(t *testing.T) {
+	ms := trigramStore(trigramNames...)
+	for _, substr := range []string{"store", "index", "Mem", "mem", "go", "", "cabc", "missing"} {
+		found, err := ms.Contains("name", substr)
+		if err != nil {
+			t.Fatalf("Contains failed, err=%v", err)
+		}
+		expected := matchingNames(trigramNames, func(name string) bool {
+			return strings.Contains(name, substr)
+		})
+		if !reflect.DeepEqual(found, expected) {
+			t.Errorf("Contains %q is wrong, expected=%v found=%v", substr, expected, found)
+		}
+	}
+
+	if _, err := ms.Contains("unknown", "x"); err != ErrUnknownIndex {
+		t.Errorf("Contains on unknown index should fail, err=%v", err)
+	}
+}
+
+func TestTrigramRegexp(t *testing.T) {
+	ms := trigramStore(trigramNames...)
+	for _, expr := range []string{
+		"store", "^mem", "(?i)^mem", "index$", "st(o|a)re", "ord(ered|er) ind", "[rs]tore", "mem(store|cache)",
+		"(?i)MEMORY", "x*", ".", "go|key", "(abc)+", "abc{2}", "re.*t", "tri?gram", "k[^a]y", `\bvalue\b`,
+	} {
+		re := regexp.MustCompile(expr)
+		found, err := ms.Regexp("name", re)
+		if err != nil {
+			t.Fatalf("Regexp failed, err=%v", err)
+		}
+		if expected := matchingNames(trigramNames, re.MatchString); !reflect.DeepEqual(found, expected) {
+			t.Errorf("Regexp %q is wrong, expected=%v found=%v", expr, expected, found)
+		}
+	}
+}
+
+func TestTrigramCandidates(t *testing.T) {
+	ms := trigramStore(trigramNames...)
+	gi := ms.trigrams["name"]
+
+	// Literals narrow down items to check, expressions without any check them all
+	for expr, expected := range map[string]int{
+		"memstore":         1,
+		"(?i)memory":       1,
+		"mem(store|cache)": 2,
+		"re.*t":            -1,
+		"x*":               -1,
+		"tri?gram":         1,
+		"store|index":      7,
+	} {
+		candidates := gi.candidates(regexpQuery(regexp.MustCompile(expr)))
+		if expected < 0 && candidates != nil || expected >= 0 && len(candidates) != expected {
+			t.Errorf("Candidates of %q are wrong, expected=%v got=%v", expr, expected, len(candidates))
+		}
+	}
+}
+
+func TestTrigramIndexSync(t *testing.T) {
+	ms := trigramStore("alpha", "beta")
+	ms.UpdateData(TestStruct{id: 0}, "id", func(x Item) (Item, bool) {
+		return TestStruct{0, 1, "alphabet"}, true
+	})
+	ms.UpdateWithIndexes(TestStruct{id: 1}, "id", func(x Item) (Item, bool) {
+		return TestStruct{5, 0, "gamma"}, true
+	})
+	ms.Add(TestStruct{2, 0, "delta"})
+	ms.Delete(TestStruct{id: 2}, "id")
+
+	for substr, expected := range map[string][]Item{
+		"bet": {TestStruct{0, 1, "alphabet"}},
+		"mma": {TestStruct{5, 0, "gamma"}},
+		"lta": nil,
+	} {
+		if found, _ := ms.Contains("name", substr); !reflect.DeepEqual(found, expected) {
+			t.Errorf("Contains %q should follow writes, expected=%v found=%v", substr, expected, found)
+		}
+	}
+
+	if ms.AddTrigramIndex(TrigramIndex{Name: "name", Text: func(x Item) string { return "" }}) {
+		t.Error("Trigram index should be added once")
+	}
+}
+
+func TestTrigramIndexReplace(t *testing.T) {
+	ms := trigramStore()
+	ms.Add(TestStruct{1, 1, "alphabet"})
+	ms.Add(TestStruct{1, 1, "betamax"})
+
+	if found, _ := ms.Contains("name", "alpha"); len(found) != 0 {
+		t.Errorf("Replaced item should be forgotten, found=%v", found)
+	}
+	if found, _ := ms.Regexp("name", regexp.MustCompile("bet")); !reflect.DeepEqual(found, []Item{TestStruct{1, 1, "betamax"}}) {
+		t.Errorf("Only the item replacing it should match, found=%v", found)
+	}
+
+	ms.Delete(TestStruct{id: 1}, "id")
+	if found, _ := ms.Regexp("name", regexp.MustCompile("a")); len(found) != 0 {
+		t.Errorf("Deleted item should be forgotten, found=%v", found)
+	}
+}
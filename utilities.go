@@ -2,21 +2,209 @@ package memstore
 
 import (
 	"github.com/mngharbi/GoLLRB/llrb"
+	"sync"
+	"sync/atomic"
 )
 
 // Make internal item (to work with llrb) from external item
 func makeInternalItem(item Item) llrb.Item {
-	itemCopy := item
 	return &internalItem{
-		item: &itemCopy,
+		value: item,
 	}
 }
 
-// Delete item from a certain tree
-func (ms *Memstore) delete(x *internalItem, tree *llrb.LLRB) *internalItem {
-	deleted := tree.Delete(x)
+// Internal items only used to search trees, reused to save an allocation per lookup
+var probes = sync.Pool{
+	New: func() interface{} {
+		return &internalItem{}
+	},
+}
+
+// Make internal item to search trees with, never to be inserted (give back with releaseProbe)
+func makeProbe(item Item) *internalItem {
+	probe := probes.Get().(*internalItem)
+	probe.value = item
+	return probe
+}
+
+func releaseProbe(probe *internalItem) {
+	probe.value = nil
+	probes.Put(probe)
+}
+
+// Make bound placed before (-1) or after (1) every item
+func makeSentinelItem(position int) llrb.Item {
+	return &boundItem{
+		position: position,
+	}
+}
+
+// Make bound used to search by raw key
+func makeKeyItem(key interface{}) llrb.Item {
+	return &boundItem{
+		key: key,
+	}
+}
+
+// Give a new identity to an item about to be inserted (expects a write lock)
+func (ms *Memstore) identify(ii *internalItem) {
+	ms.lastID++
+	ii.id = ms.lastID
+}
+
+/*
+	Insert item in every tree, keeping augmented trees in sync (expects a write lock)
+
+	An item with the same primary key is replaced: it leaves every tree and is deleted.
+*/
+func (ms *Memstore) insert(ix *internalItem) {
+	replaced := map[string]*internalItem{}
+	for index, tree := range ms.indexTree {
+		r := tree.ReplaceOrInsert(ix)
+		for _, aug := range ms.augmented[index] {
+			if r != nil {
+				aug.remove(r.(*internalItem))
+			}
+			aug.insert(ix)
+		}
+		if r != nil {
+			replaced[index] = r.(*internalItem)
+		}
+	}
+
+	if r := replaced[ms.indexes[0]]; r != nil && r.id != ix.id {
+		ms.evict(r, replaced)
+	}
+
+	ix.version = atomic.AddUint64(&ms.sequence, 1)
+	if ms.shared != nil {
+		ms.shared.put(ix)
+	}
+	ms.record(MutationPut, ix, ix.version)
+}
+
+// Delete an item replaced by an insert from trees it's still in (expects a write lock)
+func (ms *Memstore) evict(ii *internalItem, replaced map[string]*internalItem) {
+	// Replaced item is deleted by this replica, even while it applies a remote write
+	if ms.merge != nil {
+		incoming := ms.merge.incoming
+		ms.merge.incoming = nil
+		defer func() {
+			ms.merge.incoming = incoming
+		}()
+	}
+
+	for index, tree := range ms.indexTree {
+		if replaced[index] != ii && getFromTree(ii, tree) == ii {
+			ms.delete(ii, index)
+		}
+	}
+	ms.record(MutationDelete, ii, atomic.AddUint64(&ms.sequence, 1))
+	atomic.AddUint64(&ms.evictions, 1)
+}
+
+// Delete item from a certain tree, and from views to come if it's the primary one
+func (ms *Memstore) delete(x *internalItem, index string) *internalItem {
+	deleted := ms.indexTree[index].Delete(x)
 	if deleted == nil {
 		return nil
 	}
-	return deleted.(*internalItem)
+	ii := deleted.(*internalItem)
+	for _, aug := range ms.augmented[index] {
+		aug.remove(ii)
+	}
+	if ms.shared != nil && index == ms.indexes[0] {
+		ms.shared.remove(ii)
+	}
+	return ii
+}
+
+// Delete item from a tree, then from all others using the full item found (expects a write lock)
+func (ms *Memstore) deleteEverywhere(x *internalItem, index string) *internalItem {
+	deleted := ms.delete(x, index)
+	if deleted == nil {
+		return nil
+	}
+	for other := range ms.indexTree {
+		if other != index {
+			ms.delete(deleted, other)
+		}
+	}
+	ms.record(MutationDelete, deleted, atomic.AddUint64(&ms.sequence, 1))
+	return deleted
+}
+
+// Keep augmented trees in sync after an item changed in place (expects a write lock)
+func (ms *Memstore) refresh(ii *internalItem) {
+	for _, augs := range ms.augmented {
+		for _, aug := range augs {
+			aug.update(ii)
+		}
+	}
+	ii.version = atomic.AddUint64(&ms.sequence, 1)
+	if ms.shared != nil {
+		ms.shared.put(ii)
+	}
+	ms.record(MutationPut, ii, ii.version)
+}
+
+// Check whether an update changes the position of an item in any tree
+func (ms *Memstore) indexesChanged(before, after Item) bool {
+	for _, index := range ms.indexes {
+		if before.Less(index, after) || after.Less(index, before) {
+			return true
+		}
+	}
+	return false
+}
+
+// Move item within every tree after an update, keeping its identity (expects a write lock)
+func (ms *Memstore) reposition(ii *internalItem, updated Item) {
+	// Delete using current value before changing it
+	previous := ii.value
+	for index := range ms.indexTree {
+		ms.delete(ii, index)
+	}
+
+	ii.value = updated
+	ms.insert(ii)
+
+	// Item left its primary key, which is deleted for other replicas
+	primary := ms.indexes[0]
+	if ms.merge != nil && (previous.Less(primary, updated) || updated.Less(primary, previous)) {
+		ms.merge.stamp(previous, true)
+	}
+}
+
+// Whether an item other than self has the key of x in the primary or a unique index (expects a lock)
+func (ms *Memstore) duplicate(x Item, self *internalItem) bool {
+	probe := makeProbe(x)
+	defer releaseProbe(probe)
+
+	if found := getFromTree(probe, ms.trees[0]); found != nil && found != self {
+		return true
+	}
+	for _, index := range ms.unique {
+		tree := ms.indexTree[index]
+		if tree == nil {
+			continue
+		}
+		if found := getFromTree(probe, tree); found != nil && found != self {
+			return true
+		}
+	}
+	return false
+}
+
+// Find an item in a tree and check its version
+func findVersion(tree OrderedIndex, ix llrb.Item, version uint64) (*internalItem, error) {
+	internalFoundInterfaced := tree.Get(ix)
+	if internalFoundInterfaced == nil {
+		return nil, ErrNotFound
+	}
+	internalFound := internalFoundInterfaced.(*internalItem)
+	if internalFound.version != version {
+		return nil, ErrConflict
+	}
+	return internalFound, nil
 }
@@ -0,0 +1,165 @@
+package memstore
+
+import (
+	"fmt"
+)
+
+/*
+	Result of checking the tree of an index
+
+	Missing and Extra compare items of the tree with those of the primary index (first one)
+*/
+type IndexReport struct {
+	Index string
+
+	// Size the tree reports, and number of nodes actually found
+	Size  int
+	Nodes int
+
+	// Violations of invariants of the backend (e.g. red-black for LLRB)
+	StructureViolations int
+
+	OrderViolations int
+	Missing         int
+	Extra           int
+
+	// Description of every violation found
+	Problems []string
+}
+
+func (r IndexReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+/*
+	Result of checking every tree of a store
+*/
+type VerifyReport struct {
+	Indexes []IndexReport
+}
+
+func (r VerifyReport) OK() bool {
+	for _, ir := range r.Indexes {
+		if !ir.OK() {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+	Check every tree for invariants of its backend, ordering under its index,
+	and that it holds exactly the same items as the primary index
+*/
+func (ms *Memstore) Verify() (report VerifyReport) {
+	op := ms.begin(opVerify, "")
+	defer ms.end(op)
+
+	ms.rlock(op)
+	defer ms.runlock(op)
+
+	// Identities of items in primary index
+	primary := map[uint64]bool{}
+	ascendRange(ms.trees[0], ms.indexes[0], All(), func(ii *internalItem) bool {
+		primary[ii.id] = true
+		return true
+	})
+
+	for _, index := range ms.indexes {
+		report.Indexes = append(report.Indexes, verifyTree(ms.indexTree[index], index, primary))
+	}
+
+	return report
+}
+
+func verifyTree(tree OrderedIndex, index string, primary map[uint64]bool) IndexReport {
+	ir := IndexReport{
+		Index: index,
+		Size:  tree.Len(),
+	}
+
+	tree.check(&ir)
+
+	// Walk from before every item (comparing nothing but the sentinel, so that a broken order
+	// doesn't hide any): it must be strictly increasing, and match items of primary index
+	found := map[uint64]bool{}
+	var previous *internalItem
+	ascendRange(tree, index, All(), func(ii *internalItem) bool {
+		ir.Nodes++
+		if previous != nil && !previous.Less(index, ii) {
+			ir.OrderViolations++
+			ir.Problems = append(ir.Problems, fmt.Sprintf("%+v is not before %+v", previous.value, ii.value))
+		}
+		previous = ii
+
+		if found[ii.id] || !primary[ii.id] {
+			ir.Extra++
+			ir.Problems = append(ir.Problems, fmt.Sprintf("%+v is not in primary index", ii.value))
+		}
+		found[ii.id] = true
+		return true
+	})
+
+	for id := range primary {
+		if !found[id] {
+			ir.Missing++
+		}
+	}
+	if ir.Missing > 0 {
+		ir.Problems = append(ir.Problems, fmt.Sprintf("%v items of primary index are missing", ir.Missing))
+	}
+
+	if ir.Nodes != ir.Size {
+		ir.Problems = append(ir.Problems, fmt.Sprintf("tree reports %v items but has %v nodes", ir.Size, ir.Nodes))
+	}
+
+	return ir
+}
+
+func (ir *IndexReport) addStructureViolation(problem string) {
+	ir.StructureViolations++
+	ir.Problems = append(ir.Problems, problem)
+}
+
+/*
+	Regenerate the tree of an index from items of the primary index
+
+	Aggregates maintained on the index are regenerated as well
+*/
+func (ms *Memstore) Rebuild(index string) bool {
+	op := ms.begin(opRebuild, index)
+	defer ms.end(op)
+
+	existing := ms.lookupTree(index)
+	if existing == nil {
+		return false
+	}
+
+	ms.lock(op)
+	defer ms.unlock(op)
+
+	// Collect items of primary index (a broken order doesn't hide any, see verifyTree)
+	var items []*internalItem
+	ascendRange(ms.trees[0], ms.indexes[0], All(), func(ii *internalItem) bool {
+		items = append(items, ii)
+		return true
+	})
+
+	// Refill tree in place so that trees looked up before locking stay valid
+	existing.clear()
+	for _, ii := range items {
+		existing.ReplaceOrInsert(ii)
+	}
+
+	// Regenerate augmented trees
+	for i, aug := range ms.augmented[index] {
+		rebuilt := newAugmentedTree(index, aug.agg)
+		ascendRange(existing, index, All(), func(ii *internalItem) bool {
+			rebuilt.insert(ii)
+			return true
+		})
+		ms.augmented[index][i] = rebuilt
+	}
+
+	return true
+}
@@ -0,0 +1,104 @@
+package memstore
+
+import (
+	"github.com/mngharbi/GoLLRB/llrb"
+	"testing"
+)
+
+func verifyTestStore() *Memstore {
+	ms := New([]string{"id", "importance"})
+	for _, v := range shuffeledTestData() {
+		var vItem Item = v
+		ms.Add(vItem)
+	}
+	return ms
+}
+
+func indexReport(report VerifyReport, index string) IndexReport {
+	for _, ir := range report.Indexes {
+		if ir.Index == index {
+			return ir
+		}
+	}
+	return IndexReport{}
+}
+
+func TestVerifyHealthy(t *testing.T) {
+	ms := verifyTestStore()
+	ms.Delete(TestStruct{id: 3}, "id")
+	ms.UpdateWithIndexes(TestStruct{id: 9}, "id", dataModifierFuncWithIndex)
+
+	report := ms.Verify()
+	if !report.OK() || len(report.Indexes) != 2 {
+		t.Errorf("Verifying healthy store should succeed, report=%+v", report)
+	}
+}
+
+func TestVerifyMissingAndExtra(t *testing.T) {
+	ms := verifyTestStore()
+
+	// Item only in secondary index
+	ms.indexTree["importance"].ReplaceOrInsert(&internalItem{value: TestStruct{id: 20, importance: 20}, id: 1000})
+
+	// Item only in primary index
+	var deleted Item = TestStruct{importance: 5}
+	ms.indexTree["importance"].Delete(makeInternalItem(deleted))
+
+	report := ms.Verify()
+	ir := indexReport(report, "importance")
+	if report.OK() || ir.Missing != 1 || ir.Extra != 1 || !indexReport(report, "id").OK() {
+		t.Errorf("Verifying should report missing and extra items, report=%+v", report)
+	}
+
+	if !ms.Rebuild("importance") || !ms.Verify().OK() || ms.Get(deleted, "importance") == nil {
+		t.Errorf("Rebuilding index should repair it, report=%+v", ms.Verify())
+	}
+}
+
+func TestVerifyOrder(t *testing.T) {
+	ms := verifyTestStore()
+	ms.AddAggregate("importance", Count())
+
+	// Change indexed field behind the store's back
+	found := ms.indexTree["importance"].Get(makeInternalItem(TestStruct{importance: 0})).(*internalItem)
+	found.value = TestStruct{id: 4, importance: 100, name: "t"}
+
+	ir := indexReport(ms.Verify(), "importance")
+	if ir.OrderViolations == 0 {
+		t.Errorf("Verifying should report order violations, report=%+v", ir)
+	}
+
+	ms.Rebuild("importance")
+	if !ms.Verify().OK() || ms.Max("importance").(TestStruct).id != 4 {
+		t.Error("Rebuilding index should restore order")
+	}
+	if count := ms.Aggregate("importance", Inclusive(TestStruct{importance: 50}), Unbounded(), Count()); count != 1 {
+		t.Errorf("Rebuilding index should regenerate aggregates, count=%v", count)
+	}
+}
+
+func TestVerifyRedBlack(t *testing.T) {
+	ms := verifyTestStore()
+
+	// Break color of a link
+	var node *llrb.Node = ms.indexTree["id"].(*llrbIndex).Root()
+	node.Black = false
+
+	ir := indexReport(ms.Verify(), "id")
+	if ir.StructureViolations == 0 {
+		t.Errorf("Verifying should report red-black violations, report=%+v", ir)
+	}
+
+	ms.Rebuild("id")
+	if !ms.Verify().OK() {
+		t.Error("Rebuilding index should restore red-black invariants")
+	}
+}
+
+func TestRebuildInvalidIndex(t *testing.T) {
+	ms := verifyTestStore()
+
+	if ms.Rebuild("notID") {
+		t.Error("Rebuilding unspecified index didn't fail")
+	}
+}
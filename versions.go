@@ -0,0 +1,132 @@
+package memstore
+
+import (
+	"sync/atomic"
+)
+
+// Global sequence number, incremented by every mutation of the store
+func (ms *Memstore) Sequence() uint64 {
+	return atomic.LoadUint64(&ms.sequence)
+}
+
+/*
+	Same as Get, also returning the version of the item (sequence number of its last write)
+
+	Get keeps returning the item alone: it's the most used method of the store, and every caller of it
+	(servers and generated stores included) would otherwise have to change.
+*/
+func (ms *Memstore) GetWithVersion(x Item, index string) (res Item, version uint64) {
+	op := ms.begin(opGetWithVersion, index)
+	defer ms.end(op)
+
+	// Make probe to search llrb with
+	ix := makeProbe(x)
+	defer releaseProbe(ix)
+
+	// Get corresponding tree
+	tree := ms.lookupTree(index)
+	if tree == nil {
+		return nil, 0
+	}
+
+	ms.rlock(op)
+
+	ifound := tree.Get(ix)
+	if ifound != nil {
+		res = ifound.(*internalItem).value
+		version = ifound.(*internalItem).version
+		op.addItems(1)
+	}
+
+	ms.runlock(op)
+
+	return res, version
+}
+
+/*
+	Replace an item only if it's still at a given version
+
+	Item is moved within trees if indexed fields changed
+	Returns new version of the item, fails with ErrConflict if version moved,
+	or ErrDuplicate if the item would be moved to the primary key of another one
+*/
+func (ms *Memstore) UpdateIfVersion(x Item, index string, version uint64, updated Item) (newVersion uint64, err error) {
+	if ms.rejectsWrites() {
+		return 0, ErrReadOnly
+	}
+
+	op := ms.begin(opUpdateIfVersion, index)
+	defer ms.end(op)
+
+	// Make probe to search llrb with
+	ix := makeProbe(x)
+	defer releaseProbe(ix)
+
+	// Get corresponding tree
+	tree := ms.lookupTree(index)
+	if tree == nil {
+		return 0, ErrUnknownIndex
+	}
+
+	// Check updated item once lock is released
+	defer func() {
+		if err == nil {
+			ms.debugCheck(op, updated)
+		}
+	}()
+
+	ms.lock(op)
+	defer ms.unlock(op)
+
+	internalFound, err := findVersion(tree, ix, version)
+	if err != nil {
+		return 0, err
+	}
+
+	// Update in place, or move item if indexed fields changed unless another one has its key
+	if ms.indexesChanged(internalFound.value, updated) {
+		if ms.duplicate(updated, internalFound) {
+			return 0, ErrDuplicate
+		}
+		ms.reposition(internalFound, updated)
+	} else {
+		internalFound.value = updated
+		ms.refresh(internalFound)
+	}
+	op.addItems(1)
+
+	return internalFound.version, nil
+}
+
+// Delete an item only if it's still at a given version, fails with ErrConflict if version moved
+func (ms *Memstore) DeleteIfVersion(x Item, index string, version uint64) (Item, error) {
+	if ms.rejectsWrites() {
+		return nil, ErrReadOnly
+	}
+
+	op := ms.begin(opDeleteIfVersion, index)
+	defer ms.end(op)
+
+	// Make probe to search llrb with
+	ix := makeProbe(x)
+	defer releaseProbe(ix)
+
+	// Get corresponding tree
+	tree := ms.lookupTree(index)
+	if tree == nil {
+		return nil, ErrUnknownIndex
+	}
+
+	ms.lock(op)
+	defer ms.unlock(op)
+
+	internalFound, err := findVersion(tree, ix, version)
+	if err != nil {
+		return nil, err
+	}
+
+	ms.deleteEverywhere(internalFound, index)
+	op.addItems(1)
+
+	return internalFound.value, nil
+}
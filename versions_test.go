@@ -0,0 +1,111 @@
+package memstore
+
+import (
+	"testing"
+)
+
+func TestVersionsIncrease(t *testing.T) {
+	ms := New([]string{"id", "importance"})
+
+	var vItem Item = TestStruct{id: 1, importance: 3, name: "x"}
+	ms.Add(vItem)
+
+	_, addVersion := ms.GetWithVersion(vItem, "id")
+	if addVersion == 0 || addVersion != ms.Sequence() {
+		t.Errorf("Added item should carry current sequence number, version=%v sequence=%v", addVersion, ms.Sequence())
+	}
+
+	ms.UpdateData(vItem, "id", dataModifierFunc)
+
+	_, updateVersion := ms.GetWithVersion(vItem, "importance")
+	if updateVersion <= addVersion {
+		t.Errorf("Update should increase version, before=%v after=%v", addVersion, updateVersion)
+	}
+
+	if res, version := ms.GetWithVersion(TestStruct{id: 2}, "id"); res != nil || version != 0 {
+		t.Error("Getting version of inexistent record should fail")
+	}
+}
+
+func TestUpdateIfVersion(t *testing.T) {
+	ms := New([]string{"id", "importance"})
+
+	var vItem Item = TestStruct{id: 1, importance: 3, name: "x"}
+	ms.Add(vItem)
+	_, version := ms.GetWithVersion(vItem, "id")
+
+	// Someone else updates item in between
+	ms.UpdateData(vItem, "id", dataModifierFunc)
+
+	if _, err := ms.UpdateIfVersion(vItem, "id", version, TestStruct{id: 1, importance: 4}); err != ErrConflict {
+		t.Errorf("Update with outdated version should conflict, err=%v", err)
+	}
+
+	_, version = ms.GetWithVersion(vItem, "id")
+	newVersion, err := ms.UpdateIfVersion(vItem, "id", version, TestStruct{id: 1, importance: 4})
+	if err != nil || newVersion <= version {
+		t.Errorf("Update with current version failed, err=%v", err)
+	}
+
+	if res := ms.Get(TestStruct{importance: 4}, "importance"); res == nil {
+		t.Error("Update with current version changing indexes should readjust tables")
+	}
+
+	if _, err := ms.UpdateIfVersion(TestStruct{id: 2}, "id", newVersion, vItem); err != ErrNotFound {
+		t.Errorf("Update of inexistent record should fail, err=%v", err)
+	}
+
+	if _, err := ms.UpdateIfVersion(vItem, "notID", newVersion, vItem); err != ErrUnknownIndex {
+		t.Errorf("Update with unspecified index should fail, err=%v", err)
+	}
+}
+
+func TestDeleteIfVersion(t *testing.T) {
+	ms := New([]string{"id", "importance"})
+
+	var vItem Item = TestStruct{id: 1, importance: 3, name: "x"}
+	ms.Add(vItem)
+	_, version := ms.GetWithVersion(vItem, "id")
+
+	ms.UpdateData(vItem, "id", dataModifierFunc)
+
+	if _, err := ms.DeleteIfVersion(vItem, "id", version); err != ErrConflict || ms.Len() != 1 {
+		t.Errorf("Delete with outdated version should conflict, err=%v", err)
+	}
+
+	_, version = ms.GetWithVersion(vItem, "id")
+	if res, err := ms.DeleteIfVersion(vItem, "id", version); err != nil || res.(TestStruct).name != "changed" || ms.Len() != 0 {
+		t.Errorf("Delete with current version failed, err=%v", err)
+	}
+
+	if _, err := ms.DeleteIfVersion(vItem, "id", version); err != ErrNotFound {
+		t.Errorf("Delete of inexistent record should fail, err=%v", err)
+	}
+}
+
+func TestUpdateOntoOtherKey(t *testing.T) {
+	ms := New([]string{"id", "importance"}, WithIndexChangePolicy(RepositionOnIndexChange))
+	first, second := TestStruct{1, 1, "x"}, TestStruct{2, 2, "y"}
+	ms.Add(first)
+	ms.Add(second)
+	moved := TestStruct{2, 3, "moved"}
+
+	// Moving an item to the primary key of another would replace it
+	_, version := ms.GetWithVersion(first, "id")
+	if _, err := ms.UpdateIfVersion(first, "id", version, moved); err != ErrDuplicate {
+		t.Errorf("Update onto another key should fail, err=%v", err)
+	}
+	if ms.CompareAndSwap(first, moved, "id") {
+		t.Error("Compare and swap onto another key should fail")
+	}
+	if _, err := ms.Update(first, "id", func(Item) (Item, bool) { return moved, true }); err != ErrDuplicate {
+		t.Errorf("Update onto another key should fail, err=%v", err)
+	}
+	if ms.UpdateWithIndexes(first, "id", func(Item) (Item, bool) { return moved, true }) != nil {
+		t.Error("Update with indexes onto another key should fail")
+	}
+
+	if ms.Len() != 2 || ms.Get(first, "id") != first || ms.Get(second, "id") != second {
+		t.Error("Updates onto another key shouldn't change the store")
+	}
+}
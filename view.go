@@ -0,0 +1,205 @@
+package memstore
+
+/*
+	Store as it was at some point, in order of its primary index
+
+	Taking a view copies nothing: writes copy the nodes they change instead, so a view shares every subtree
+	left untouched since with the store and its other views, and Diff skips those without walking them.
+*/
+type View struct {
+	index   string
+	root    *sharedNode
+	lineage uint64
+	seq     uint64
+}
+
+/*
+	Keep the primary index in a persistent tree as well, so that views of the store can be taken (see View)
+
+	Every write then also copies the O(log n) nodes on its path in that tree.
+*/
+func WithViews() Option {
+	return func(ms *Memstore) {
+		ms.shared = &sharedTree{
+			index: ms.indexes[0],
+			seed:  0x9e3779b97f4a7c15,
+		}
+	}
+}
+
+// View of the store as it is now, requires views to be kept (see WithViews)
+func (ms *Memstore) View() (View, error) {
+	if ms.shared == nil {
+		return View{}, ErrNoViews
+	}
+
+	op := ms.begin(opView, "")
+	defer ms.end(op)
+
+	ms.rlock(op)
+	defer ms.runlock(op)
+
+	return ms.view(), nil
+}
+
+// View of the store (expects a lock)
+func (ms *Memstore) view() View {
+	return View{
+		index:   ms.indexes[0],
+		root:    ms.shared.root,
+		lineage: ms.lineage,
+		seq:     ms.Sequence(),
+	}
+}
+
+// Sequence number of the store when the view was taken
+func (v View) Seq() uint64 {
+	return v.seq
+}
+
+// Number of items in the view
+func (v View) Len() int {
+	if v.root == nil {
+		return 0
+	}
+	return v.root.size
+}
+
+// Run test on items in order of the primary index, until it returns false
+func (v View) Ascend(test func(Item) bool) {
+	v.root.walk(func(item SnapshotItem) bool {
+		return test(item.Item)
+	})
+}
+
+/*
+	Treap of the primary index copied on write: a node never changes once a view may reach it
+*/
+type sharedTree struct {
+	index string
+	root  *sharedNode
+
+	// State of the generator of node priorities
+	seed uint64
+}
+
+type sharedNode struct {
+	item     SnapshotItem
+	priority uint64
+
+	// Number of items in the subtree, and the first and last of them
+	size        int
+	first, last Item
+
+	left, right *sharedNode
+}
+
+// Next pseudo-random priority (xorshift)
+func (t *sharedTree) nextPriority() uint64 {
+	t.seed ^= t.seed << 13
+	t.seed ^= t.seed >> 7
+	t.seed ^= t.seed << 17
+	return t.seed
+}
+
+// New node with its summary computed from its children
+func (t *sharedTree) node(item SnapshotItem, priority uint64, left, right *sharedNode) *sharedNode {
+	n := &sharedNode{
+		item:     item,
+		priority: priority,
+		size:     1,
+		first:    item.Item,
+		last:     item.Item,
+		left:     left,
+		right:    right,
+	}
+	if left != nil {
+		n.size += left.size
+		n.first = left.first
+	}
+	if right != nil {
+		n.size += right.size
+		n.last = right.last
+	}
+	return n
+}
+
+// Insert item, or replace the one with the same key (expects a write lock)
+func (t *sharedTree) put(ii *internalItem) {
+	item := SnapshotItem{ID: ii.id, Version: ii.version, Item: ii.value}
+	t.root = t.putAt(t.root, item, t.nextPriority())
+}
+
+func (t *sharedTree) putAt(n *sharedNode, item SnapshotItem, priority uint64) *sharedNode {
+	if n == nil {
+		return t.node(item, priority, nil, nil)
+	}
+
+	switch {
+	case item.Item.Less(t.index, n.item.Item):
+		left := t.putAt(n.left, item, priority)
+		if left.priority > n.priority {
+			return t.node(left.item, left.priority, left.left, t.node(n.item, n.priority, left.right, n.right))
+		}
+		return t.node(n.item, n.priority, left, n.right)
+	case n.item.Item.Less(t.index, item.Item):
+		right := t.putAt(n.right, item, priority)
+		if right.priority > n.priority {
+			return t.node(right.item, right.priority, t.node(n.item, n.priority, n.left, right.left), right.right)
+		}
+		return t.node(n.item, n.priority, n.left, right)
+	default:
+		// Same key, replace item
+		return t.node(item, n.priority, n.left, n.right)
+	}
+}
+
+// Remove the item with the same key as ii (expects a write lock)
+func (t *sharedTree) remove(ii *internalItem) {
+	t.root = t.removeAt(t.root, ii.value)
+}
+
+func (t *sharedTree) removeAt(n *sharedNode, x Item) *sharedNode {
+	if n == nil {
+		return nil
+	}
+
+	switch {
+	case x.Less(t.index, n.item.Item):
+		left := t.removeAt(n.left, x)
+		if left == n.left {
+			return n
+		}
+		return t.node(n.item, n.priority, left, n.right)
+	case n.item.Item.Less(t.index, x):
+		right := t.removeAt(n.right, x)
+		if right == n.right {
+			return n
+		}
+		return t.node(n.item, n.priority, n.left, right)
+	default:
+		return t.merge(n.left, n.right)
+	}
+}
+
+// Merge two treaps, every node of the left one being before the right one
+func (t *sharedTree) merge(left, right *sharedNode) *sharedNode {
+	if left == nil {
+		return right
+	}
+	if right == nil {
+		return left
+	}
+	if left.priority > right.priority {
+		return t.node(left.item, left.priority, left.left, t.merge(left.right, right))
+	}
+	return t.node(right.item, right.priority, t.merge(left, right.left), right.right)
+}
+
+// Run yield on items of the subtree in order, until it returns false
+func (n *sharedNode) walk(yield func(SnapshotItem) bool) bool {
+	if n == nil {
+		return true
+	}
+	return n.left.walk(yield) && yield(n.item) && n.right.walk(yield)
+}